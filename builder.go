@@ -0,0 +1,65 @@
+package fbp
+
+import (
+	"fmt"
+)
+
+//
+// Builder creates a network out of a graph. It is implemented by runtimes
+// and called either by BaseFbp.Build() or by the code WriteGo() generates.
+//
+type Builder interface {
+	// AddProcess instantiates the component of a process
+	AddProcess(name, component string, metadata map[string]string) error
+	// Connect connects an out-port of one process to an in-port of another
+	Connect(src, tgt *Endpoint, metadata map[string]string) error
+	// AddInitial sends an IIP to an in-port when the network starts
+	AddInitial(data string, tgt *Endpoint) error
+	// ExportInport makes an in-port available outside of the network
+	ExportInport(name string, e *Endpoint) error
+	// ExportOutport makes an out-port available outside of the network
+	ExportOutport(name string, e *Endpoint) error
+}
+
+// Index returns a pointer to i suitable for Endpoint.Index
+func Index(i int) *int {
+	return &i
+}
+
+// Build adds processes, connections, IIPs and exported ports of the graph
+// to b, in this order
+func (self *BaseFbp) Build(b Builder) error {
+	for _, p := range self.Processes {
+		if err := b.AddProcess(p.Name, p.Component, p.Metadata); err != nil {
+			return err
+		}
+	}
+	for i, c := range self.Connections {
+		if c.Target == nil {
+			return fmt.Errorf("fbp: connection #%d has no target", i)
+		}
+		if c.Source != nil {
+			if err := b.Connect(c.Source, c.Target, c.Metadata); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range self.Connections {
+		if c.Source == nil {
+			if err := b.AddInitial(c.Data, c.Target); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range sortedPorts(self.Inports) {
+		if err := b.ExportInport(name, self.Inports[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedPorts(self.Outports) {
+		if err := b.ExportOutport(name, self.Outports[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/oleksandr/fbp"
+	"github.com/oleksandr/fbp/lsp"
+)
+
+func runParse(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("parse", "[file]", stderr)
+	from := flags.String("from", "", "input format: fbp or json (default from the file)")
+	subgraph := flags.String("subgraph", "", "prefix of the process names")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		return exitUsage
+	}
+	inputs, err := readInputs(flags.Args(), *from, stdin)
+	if err != nil {
+		errorf(stderr, "parse", "%v", err)
+		return exitUsage
+	}
+	graph, err := inputs[0].graph(*subgraph)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitProblem
+	}
+	data, err := marshal(graph, "json")
+	if err != nil {
+		errorf(stderr, "parse", "%v", err)
+		return exitProblem
+	}
+	stdout.Write(data)
+	return exitOK
+}
+
+//
+// Diagnostic of validate -json output
+//
+type fileDiagnostic struct {
+	File string `json:"file"`
+	*fbp.Diagnostic
+}
+
+func runValidate(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("validate", "[files]", stderr)
+	from := flags.String("from", "", "input format: fbp or json (default from the file)")
+	asJSON := flags.Bool("json", false, "print the diagnostics as JSON array")
+	strict := flags.Bool("strict", false, "fail on warnings too")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	inputs, err := readInputs(flags.Args(), *from, stdin)
+	if err != nil {
+		errorf(stderr, "validate", "%v", err)
+		return exitUsage
+	}
+	status := exitOK
+	diagnostics := []*fileDiagnostic{}
+	for _, in := range inputs {
+		for _, d := range validate(in) {
+			if d.Severity == fbp.SeverityError || (*strict && d.Severity == fbp.SeverityWarning) {
+				status = exitProblem
+			}
+			diagnostics = append(diagnostics, &fileDiagnostic{File: in.name, Diagnostic: d})
+		}
+	}
+	if *asJSON {
+		writeJSON(stdout, diagnostics)
+		return status
+	}
+	for _, d := range diagnostics {
+		fmt.Fprintf(stdout, "%s:%s\n", d.File, d.Diagnostic)
+	}
+	return status
+}
+
+// validate returns the diagnostics of a .fbp source or the error of a NoFlo
+// JSON graph (at 1:1, there are no positions in the parsed JSON)
+func validate(in *input) []*fbp.Diagnostic {
+	if in.format == "fbp" {
+		return fbp.Check(in.data)
+	}
+	graph, err := fbp.ParseJSON(in.data)
+	if err == nil {
+		err = graph.Validate()
+	}
+	if err != nil {
+		return []*fbp.Diagnostic{{Severity: fbp.SeverityError, Line: 1, Column: 1, Message: err.Error()}}
+	}
+	return nil
+}
+
+func runConvert(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("convert", "[file]", stderr)
+	from := flags.String("from", "", "input format: fbp or json (default from the file)")
+	to := flags.String("to", "", "output format: fbp, json, dot or mermaid")
+	output := flags.String("o", "", "output file (default stdout)")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		return exitUsage
+	}
+	if *to == "" {
+		errorf(stderr, "convert", "-to is required")
+		return exitUsage
+	}
+	inputs, err := readInputs(flags.Args(), *from, stdin)
+	if err != nil {
+		errorf(stderr, "convert", "%v", err)
+		return exitUsage
+	}
+	graph, err := inputs[0].graph("")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitProblem
+	}
+	data, err := marshal(graph, *to)
+	if err != nil {
+		errorf(stderr, "convert", "%v", err)
+		return exitProblem
+	}
+	if *output == "" {
+		stdout.Write(data)
+		return exitOK
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		errorf(stderr, "convert", "%v", err)
+		return exitUsage
+	}
+	return exitOK
+}
+
+// marshal returns graph in format, JSON is indented and all end with newline
+func marshal(graph *fbp.BaseFbp, format string) ([]byte, error) {
+	switch format {
+	case "fbp":
+		return graph.MarshalFbp()
+	case "json":
+		data, err := graph.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	case "dot":
+		return graph.MarshalDot()
+	case "mermaid":
+		return graph.MarshalMermaid()
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+func runFmt(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("fmt", "[files]", stderr)
+	list := flags.Bool("l", false, "list the files whose formatting differs")
+	write := flags.Bool("w", false, "write the result to the file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	inputs, err := readInputs(flags.Args(), "fbp", stdin)
+	if err != nil {
+		errorf(stderr, "fmt", "%v", err)
+		return exitUsage
+	}
+	status := exitOK
+	for _, in := range inputs {
+		formatted, err := fbp.Format(in.data)
+		if err != nil {
+			if diagnostics := fbp.Check(in.data); len(diagnostics) > 0 {
+				fmt.Fprintf(stderr, "%s:%s\n", in.name, diagnostics[0])
+			} else {
+				fmt.Fprintf(stderr, "%s: %v\n", in.name, err)
+			}
+			status = exitProblem
+			continue
+		}
+		changed := !bytes.Equal(in.data, formatted)
+		if *list && changed {
+			fmt.Fprintln(stdout, in.name)
+		}
+		if *write && in.path != "" {
+			if changed {
+				if err := writeFile(in.path, formatted); err != nil {
+					errorf(stderr, "fmt", "%v", err)
+					return exitUsage
+				}
+			}
+		} else if !*list {
+			stdout.Write(formatted)
+		}
+	}
+	return status
+}
+
+// writeFile replaces the content of an existing file keeping its mode
+func writeFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, info.Mode().Perm())
+}
+
+//
+// Counts of stats output
+//
+type stats struct {
+	File        string `json:"file"`
+	Processes   int    `json:"processes"`
+	Components  int    `json:"components"`
+	Connections int    `json:"connections"`
+	IIPs        int    `json:"iips"`
+	Inports     int    `json:"inports"`
+	Outports    int    `json:"outports"`
+}
+
+func (s *stats) String() string {
+	return fmt.Sprintf("%s: processes=%d components=%d connections=%d iips=%d inports=%d outports=%d",
+		s.File, s.Processes, s.Components, s.Connections, s.IIPs, s.Inports, s.Outports)
+}
+
+func runStats(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("stats", "[files]", stderr)
+	from := flags.String("from", "", "input format: fbp or json (default from the file)")
+	asJSON := flags.Bool("json", false, "print the counts as JSON array")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	inputs, err := readInputs(flags.Args(), *from, stdin)
+	if err != nil {
+		errorf(stderr, "stats", "%v", err)
+		return exitUsage
+	}
+	status := exitOK
+	all := []*stats{}
+	for _, in := range inputs {
+		graph, err := in.graph("")
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			status = exitProblem
+			continue
+		}
+		s := &stats{
+			File:      in.name,
+			Processes: len(graph.Processes),
+			Inports:   len(graph.Inports),
+			Outports:  len(graph.Outports),
+		}
+		components := make(map[string]bool)
+		for _, p := range graph.Processes {
+			if p.Component != "" {
+				components[p.Component] = true
+			}
+		}
+		s.Components = len(components)
+		for _, c := range graph.Connections {
+			if c.Source == nil {
+				s.IIPs++
+			} else {
+				s.Connections++
+			}
+		}
+		all = append(all, s)
+	}
+	if *asJSON {
+		writeJSON(stdout, all)
+		return status
+	}
+	for _, s := range all {
+		fmt.Fprintln(stdout, s)
+	}
+	return status
+}
+
+// writeJSON writes v as indented JSON without escaping <, > and &
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func runHighlight(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("highlight", "[file]", stderr)
+	format := flags.String("format", "ansi", "output format: html, ansi or json (a token per line)")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		return exitUsage
+	}
+	highlight, ok := map[string]func(io.Writer, []byte) error{
+		"html": fbp.HighlightHTML,
+		"ansi": fbp.HighlightANSI,
+		"json": fbp.WriteTokensJSON,
+	}[*format]
+	if !ok {
+		errorf(stderr, "highlight", "unknown format %q", *format)
+		return exitUsage
+	}
+	inputs, err := readInputs(flags.Args(), "fbp", stdin)
+	if err != nil {
+		errorf(stderr, "highlight", "%v", err)
+		return exitUsage
+	}
+	if err := highlight(stdout, inputs[0].data); err != nil {
+		errorf(stderr, "highlight", "%v", err)
+		return exitUsage
+	}
+	return exitOK
+}
+
+func runLSP(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("lsp", "", stderr)
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return exitUsage
+	}
+	if err := lsp.NewServer().Serve(stdin, stdout); err != nil {
+		errorf(stderr, "lsp", "%v", err)
+		return exitProblem
+	}
+	return exitOK
+}
+
+func runDiff(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("diff", "old new", stderr)
+	from := flags.String("from", "", "input format: fbp or json (default from the file)")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return exitUsage
+	}
+	inputs, err := readInputs(flags.Args(), *from, stdin)
+	if err != nil {
+		errorf(stderr, "diff", "%v", err)
+		return exitUsage
+	}
+	var graphs []*fbp.BaseFbp
+	for _, in := range inputs {
+		graph, err := in.graph("")
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+		graphs = append(graphs, graph)
+	}
+	diff := fbp.Diff(graphs[0], graphs[1])
+	if diff.Empty() {
+		return exitOK
+	}
+	diff.WriteTo(stdout)
+	return exitProblem
+}
+
+func runMerge(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("merge", "base ours theirs", stderr)
+	from := flags.String("from", "", "input format: fbp or json (default from the file)")
+	output := flags.String("o", "", "output file (default stdout)")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 3 {
+		return exitUsage
+	}
+	inputs, err := readInputs(flags.Args(), *from, stdin)
+	if err != nil {
+		errorf(stderr, "merge", "%v", err)
+		return exitUsage
+	}
+	var graphs []*fbp.BaseFbp
+	for _, in := range inputs {
+		graph, err := in.graph("")
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+		graphs = append(graphs, graph)
+	}
+	merged, conflicts := fbp.Merge(graphs[0], graphs[1], graphs[2])
+	// the processes whose connections were all removed (some on each side)
+	// can't be declared in .fbp, they are left out and reported
+	unconnected := dropUnconnected(merged)
+	data, err := merged.MarshalFbp()
+	if err != nil {
+		errorf(stderr, "merge", "%v", err)
+		return exitProblem
+	}
+	// the conflicts go first as comments (IIPs may have line breaks), the
+	// graph has ours for them
+	var buf bytes.Buffer
+	escape := strings.NewReplacer("\n", `\n`, "\r", `\r`)
+	for _, c := range conflicts {
+		fmt.Fprintf(&buf, "# conflict: %s\n", escape.Replace(c.String()))
+		fmt.Fprintf(stderr, "conflict: %s\n", c)
+	}
+	for _, p := range unconnected {
+		fmt.Fprintf(&buf, "# unconnected: process %s has no connections left, it is left out\n", p)
+		fmt.Fprintf(stderr, "unconnected: process %s has no connections left\n", p)
+	}
+	buf.Write(data)
+	if *output == "" {
+		stdout.Write(buf.Bytes())
+	} else if err := os.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		errorf(stderr, "merge", "%v", err)
+		return exitUsage
+	}
+	if len(conflicts) > 0 || len(unconnected) > 0 {
+		return exitProblem
+	}
+	return exitOK
+}
+
+// dropUnconnected removes the processes without connections from graph and
+// returns them
+func dropUnconnected(graph *fbp.BaseFbp) []*fbp.Process {
+	connected := make(map[string]bool)
+	for _, c := range graph.Connections {
+		if c.Source != nil {
+			connected[c.Source.Process] = true
+		}
+		if c.Target != nil {
+			connected[c.Target.Process] = true
+		}
+	}
+	var kept, dropped []*fbp.Process
+	for _, p := range graph.Processes {
+		if connected[p.Name] {
+			kept = append(kept, p)
+		} else {
+			dropped = append(dropped, p)
+		}
+	}
+	graph.Processes = kept
+	return dropped
+}
+
+func runLint(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlagSet("lint", "[files]", stderr)
+	from := flags.String("from", "", "input format: fbp or json (default from the file)")
+	configFile := flags.String("config", "", "JSON file selecting the rules")
+	asJSON := flags.Bool("json", false, "print the diagnostics as JSON array")
+	strict := flags.Bool("strict", false, "fail on warnings too")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	linter := &fbp.Linter{}
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err == nil {
+			linter.Config, err = fbp.ParseLintConfig(data)
+		}
+		if err != nil {
+			errorf(stderr, "lint", "%v", err)
+			return exitUsage
+		}
+	}
+	inputs, err := readInputs(flags.Args(), *from, stdin)
+	if err != nil {
+		errorf(stderr, "lint", "%v", err)
+		return exitUsage
+	}
+	status := exitOK
+	diagnostics := []*fileDiagnostic{}
+	for _, in := range inputs {
+		graph, err := in.graph("")
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			status = exitProblem
+			continue
+		}
+		var found []*fbp.Diagnostic
+		if in.format == "fbp" {
+			found, err = linter.Lint(in.data)
+		} else {
+			found, err = linter.LintGraph(graph)
+		}
+		if err != nil {
+			errorf(stderr, "lint", "%v", err)
+			return exitUsage
+		}
+		for _, d := range found {
+			if d.Severity == fbp.SeverityError || (*strict && d.Severity == fbp.SeverityWarning) {
+				status = exitProblem
+			}
+			diagnostics = append(diagnostics, &fileDiagnostic{File: in.name, Diagnostic: d})
+		}
+	}
+	if *asJSON {
+		writeJSON(stdout, diagnostics)
+		return status
+	}
+	for _, d := range diagnostics {
+		fmt.Fprintf(stdout, "%s:%s\n", d.File, d.Diagnostic)
+	}
+	return status
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oleksandr/fbp"
+)
+
+const stdinName = "<stdin>"
+
+//
+// Graph source read from a file or standard input
+//
+type input struct {
+	name   string
+	path   string // empty for standard input
+	data   []byte
+	format string // "fbp" or "json"
+}
+
+// readInputs reads files, standard input if there are none or for "-"
+func readInputs(files []string, from string, stdin io.Reader) ([]*input, error) {
+	if from != "" && from != "fbp" && from != "json" {
+		return nil, fmt.Errorf("unknown input format %q", from)
+	}
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	var inputs []*input
+	for _, file := range files {
+		in := &input{name: file, path: file}
+		var err error
+		if file == "-" {
+			in.name, in.path = stdinName, ""
+			in.data, err = io.ReadAll(stdin)
+		} else {
+			in.data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return nil, err
+		}
+		in.format = from
+		if in.format == "" {
+			in.format = detectFormat(in.path, in.data)
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs, nil
+}
+
+func detectFormat(path string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".fbp":
+		return "fbp"
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return "json"
+	}
+	return "fbp"
+}
+
+// graph parses and validates the input, errors of .fbp sources have the
+// position of the problem
+func (in *input) graph(subgraph string) (*fbp.BaseFbp, error) {
+	if in.format == "json" {
+		graph, err := fbp.ParseJSON(in.data)
+		if err == nil {
+			err = graph.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", in.name, err)
+		}
+		return graph, nil
+	}
+	parser := &fbp.Fbp{Buffer: string(in.data)}
+	parser.Subgraph = subgraph
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		if diagnostics := fbp.Check(in.data); len(diagnostics) > 0 {
+			return nil, fmt.Errorf("%s:%s", in.name, diagnostics[0])
+		}
+		return nil, fmt.Errorf("%s: %v", in.name, err)
+	}
+	parser.Execute()
+	if err := parser.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", in.name, err)
+	}
+	return &parser.BaseFbp, nil
+}
+
+// newFlagSet returns flags of a command printing their usage to stderr
+func newFlagSet(name, arguments string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("fbp "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: fbp %s [flags] %s\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
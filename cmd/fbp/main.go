@@ -0,0 +1,92 @@
+// Command fbp parses, checks, converts and formats flow-based programming
+// graphs written in .fbp DSL or NoFlo JSON:
+//
+//	fbp parse [-subgraph name] [file]       print the graph as NoFlo JSON
+//	fbp validate [-json] [-strict] [files]  report the problems of the graphs
+//	fbp lint [-config f] [-strict] [files]  report style problems and smells
+//	fbp convert [-from f] -to f [file]      convert between fbp, json, dot and mermaid
+//	fbp fmt [-l] [-w] [files]               format .fbp sources
+//	fbp stats [-json] [files]               count processes, connections and ports
+//	fbp diff [-from f] old new              print the changes of the graph
+//	fbp merge [-o file] base ours theirs    merge the changes of two versions
+//	fbp highlight [-format f] [file]        print the source as html, ansi or json tokens
+//	fbp lsp                                 run the language server on stdin/stdout
+//
+// The graphs are read from the files or from standard input if there are
+// none (or the file is "-"). The format of an input is given by -from, else
+// by the extension of the file (.json for NoFlo JSON), else by its first
+// character ('{' for NoFlo JSON).
+//
+// The exit status is 0 on success, 1 if an input has problems (syntax
+// errors, invalid graphs, errors reported by validate and lint), the graphs
+// of diff differ or merge has conflicts and 2 on usage and I/O errors (and
+// inputs of diff and merge that don't parse).
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	exitOK      = 0
+	exitProblem = 1
+	exitUsage   = 2
+)
+
+type command struct {
+	run   func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+	usage string
+}
+
+var commands = map[string]*command{
+	"parse":     {runParse, "print the graph as NoFlo JSON"},
+	"validate":  {runValidate, "report the problems of the graphs"},
+	"lint":      {runLint, "report style problems and smells of the graphs"},
+	"convert":   {runConvert, "convert a graph between fbp, json, dot and mermaid"},
+	"fmt":       {runFmt, "format .fbp sources"},
+	"stats":     {runStats, "count processes, connections and ports of the graphs"},
+	"diff":      {runDiff, "print what changed between two versions of a graph"},
+	"merge":     {runMerge, "merge the changes two versions made to a graph"},
+	"highlight": {runHighlight, "print a .fbp source highlighted as html, ansi or json tokens"},
+	"lsp":       {runLSP, "run the language server over stdin and stdout"},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(stderr)
+		return exitUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "fbp: unknown command %q\n", args[0])
+		usage(stderr)
+		return exitUsage
+	}
+	return cmd.run(args[1:], stdin, stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: fbp <command> [flags] [files]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-9s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(w, "\nrun \"fbp <command> -h\" for the flags of a command\n")
+}
+
+// errorf prints an error of the command to w
+func errorf(w io.Writer, name, format string, args ...interface{}) {
+	fmt.Fprintf(w, "fbp %s: %s\n", name, strings.TrimPrefix(fmt.Sprintf(format, args...), "fbp: "))
+}
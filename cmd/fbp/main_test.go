@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const graph = `INPORT=Read.IN:FILE
+'x' -> IN Read(fs/read)  OUT -> IN Display(core/out)
+Read ERROR -> IN Display
+`
+
+func runFbp(t *testing.T, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestUsage(t *testing.T) {
+	if status, _, stderr := runFbp(t, ""); status != exitUsage || !strings.Contains(stderr, "validate") {
+		t.Fatalf("Unexpected usage %d %q", status, stderr)
+	}
+	if status, _, _ := runFbp(t, "", "unknown"); status != exitUsage {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if status, _, _ := runFbp(t, graph, "convert", "-to", "svg"); status != exitProblem {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if status, _, _ := runFbp(t, "", "stats", "missing.fbp"); status != exitUsage {
+		t.Fatalf("Unexpected status %d", status)
+	}
+}
+
+func TestParse(t *testing.T) {
+	status, stdout, _ := runFbp(t, graph, "parse")
+	if status != exitOK {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	var parsed struct {
+		Processes   map[string]interface{}
+		Connections []interface{}
+	}
+	if err := json.Unmarshal([]byte(stdout), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Processes) != 2 || len(parsed.Connections) != 3 {
+		t.Fatalf("Unexpected graph %s", stdout)
+	}
+
+	status, _, stderr := runFbp(t, "A(a) OUT -> IN\n", "parse")
+	if status != exitProblem || stderr != "<stdin>:1:15: error: syntax error: unexpected end of line\n" {
+		t.Fatalf("Unexpected error %d %q", status, stderr)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	src := "INPORT=X.IN:IN\nA(a) OUT -> IN B\n"
+	status, stdout, _ := runFbp(t, src, "validate")
+	expected := "<stdin>:1:8: error: INPORT of unknown process X\n" +
+		"<stdin>:2:16: warning: process B has no component\n"
+	if status != exitProblem || stdout != expected {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+
+	status, stdout, _ = runFbp(t, "A(a) OUT -> IN B\n", "validate", "-json")
+	var diagnostics []map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if status != exitOK || len(diagnostics) != 1 || diagnostics[0]["severity"] != "warning" || diagnostics[0]["file"] != "<stdin>" {
+		t.Fatalf("Unexpected output %d %s", status, stdout)
+	}
+	if status, _, _ := runFbp(t, "A(a) OUT -> IN B\n", "validate", "-strict"); status != exitProblem {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if status, stdout, _ := runFbp(t, graph, "validate"); status != exitOK || stdout != "" {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	_, noflo, _ := runFbp(t, graph, "convert", "-to", "json")
+	status, stdout, _ := runFbp(t, noflo, "convert", "-to", "fbp")
+	expected := `INPORT=Read.IN:FILE
+'x' -> IN Read(fs/read)
+Read OUT -> IN Display(core/out)
+Read ERROR -> IN Display
+`
+	if status != exitOK || stdout != expected {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+	for _, format := range []string{"dot", "mermaid"} {
+		if status, stdout, _ := runFbp(t, noflo, "convert", "-from", "json", "-to", format); status != exitOK || !strings.Contains(stdout, "Display") {
+			t.Fatalf("Unexpected %s output %d %q", format, status, stdout)
+		}
+	}
+}
+
+func TestFmt(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fbp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "graph.fbp")
+	if err := os.WriteFile(path, []byte(graph), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, stdout, _ := runFbp(t, "", "fmt", "-l", "-w", path); status != exitOK || stdout != path+"\n" {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+	formatted, _ := os.ReadFile(path)
+	if !strings.Contains(string(formatted), "'x' -> IN Read(fs/read) OUT -> IN Display(core/out)\n") {
+		t.Fatalf("Unexpected result %q", formatted)
+	}
+	if status, stdout, _ := runFbp(t, "", "fmt", "-l", path); status != exitOK || stdout != "" {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+	if _, stdout, _ := runFbp(t, graph, "fmt"); stdout != string(formatted) {
+		t.Fatalf("Unexpected output %q", stdout)
+	}
+}
+
+func TestStats(t *testing.T) {
+	status, stdout, _ := runFbp(t, graph, "stats")
+	expected := "<stdin>: processes=2 components=2 connections=2 iips=1 inports=1 outports=0\n"
+	if status != exitOK || stdout != expected {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	status, stdout, _ := runFbp(t, "A OUT -> IN B\n", "highlight", "-format", "json")
+	if status != exitOK || !strings.HasPrefix(stdout, `{"kind":"process","offset":0,"length":1,"line":1,"column":1}`+"\n") {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+	if status, _, _ := runFbp(t, "", "highlight", "-format", "svg"); status != exitUsage {
+		t.Fatalf("Unexpected status %d", status)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fbp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "graph.fbp")
+	changed := strings.Replace(graph, "'x'", "'y'", 1)
+	if err := os.WriteFile(path, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, stdout, _ := runFbp(t, graph, "diff", "-", path)
+	if status != exitProblem || stdout != "~ iip -> IN Read: 'x' -> 'y'\n" {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+	if status, stdout, _ := runFbp(t, changed, "diff", "-", path); status != exitOK || stdout != "" {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+	if status, _, _ := runFbp(t, "", "diff", path); status != exitUsage {
+		t.Fatalf("Unexpected status %d", status)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fbp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"ours.fbp":   strings.Replace(graph, "'x'", "'y'", 1),
+		"theirs.fbp": strings.Replace(graph, "core/out", "core/print", 1),
+		"other.fbp":  strings.Replace(graph, "'x'", "'z'", 1),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, stdout, _ := runFbp(t, graph, "merge", "-", filepath.Join(dir, "ours.fbp"), filepath.Join(dir, "theirs.fbp"))
+	if status != exitOK || !strings.Contains(stdout, "'y' -> IN Read") || !strings.Contains(stdout, "Display(core/print)") {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+	status, stdout, stderr := runFbp(t, graph, "merge", "-", filepath.Join(dir, "ours.fbp"), filepath.Join(dir, "other.fbp"))
+	if status != exitProblem || !strings.HasPrefix(stdout, "# conflict: iip IN Read: base 'x', ours 'y', theirs 'z'\n") || stderr == "" {
+		t.Fatalf("Unexpected output %d %q %q", status, stdout, stderr)
+	}
+
+	// each side removes one of the connections of X
+	files = map[string]string{
+		"base.fbp":   "A(a) OUT -> IN X(x)\nX OUT -> IN B(b)\nC(c) OUT -> IN D(d)\n",
+		"ours.fbp":   "X(x) OUT -> IN B(b)\nC(c) OUT -> IN D(d)\n",
+		"theirs.fbp": "A(a) OUT -> IN X(x)\nC(c) OUT -> IN D(d)\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, stdout, _ = runFbp(t, "", "merge", filepath.Join(dir, "base.fbp"), filepath.Join(dir, "ours.fbp"), filepath.Join(dir, "theirs.fbp"))
+	expected := "# unconnected: process X(x) has no connections left, it is left out\nC(c) OUT -> IN D(d)\n"
+	if status != exitProblem || stdout != expected {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+}
+
+func TestLint(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fbp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "lint.json")
+	if err := os.WriteFile(config, []byte(`{"rules": {"duplicate-iip": "error"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := graph + "'y' -> IN Read\n"
+
+	status, stdout, _ := runFbp(t, src, "lint")
+	if status != exitOK || stdout != "<stdin>:4:1: warning: IN of Read already gets an IIP on line 2 [duplicate-iip]\n" {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+	if status, _, _ := runFbp(t, src, "lint", "-strict"); status != exitProblem {
+		t.Fatalf("Unexpected status %d", status)
+	}
+	if status, stdout, _ := runFbp(t, src, "lint", "-config", config); status != exitProblem || !strings.Contains(stdout, "error: IN of Read") {
+		t.Fatalf("Unexpected output %d %q", status, stdout)
+	}
+	if status, _, _ := runFbp(t, src, "lint", "-config", filepath.Join(dir, "missing.json")); status != exitUsage {
+		t.Fatalf("Unexpected status %d", status)
+	}
+}
@@ -0,0 +1,84 @@
+// Command fbpgen generates Go code that wires the network of a .fbp (or
+// NoFlo JSON) graph, so that the graph doesn't have to be parsed at run
+// time. It is meant to be used from go:generate:
+//
+//	//go:generate fbpgen -o ticker_fbp.go ticker.fbp
+//
+// The generated file declares <Name>Graph variable with the parsed graph
+// and Build<Name>(fbp.Builder) function that creates the network.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oleksandr/fbp"
+)
+
+func main() {
+	var (
+		output  = flag.String("o", "", "output file (default <graph>_fbp.go, - for stdout)")
+		pkg     = flag.String("package", os.Getenv("GOPACKAGE"), "package name of the generated code")
+		name    = flag.String("name", "", "graph name used in the generated identifiers (default from the file name)")
+		isJSON  = flag.Bool("json", false, "read NoFlo JSON graph (default for .json files)")
+		subpath = flag.String("subgraph", "", "prefix of the process names")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: fbpgen [flags] graph.fbp\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	source := flag.Arg(0)
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		fatal(err)
+	}
+	var graph *fbp.BaseFbp
+	if *isJSON || strings.HasSuffix(source, ".json") {
+		graph, err = fbp.ParseJSON(data)
+		if err != nil {
+			fatal(fmt.Errorf("%s: %v", source, err))
+		}
+	} else {
+		parser := &fbp.Fbp{Buffer: string(data)}
+		parser.Subgraph = *subpath
+		parser.Init()
+		if err := parser.Parse(); err != nil {
+			fatal(fmt.Errorf("%s: %v", source, err))
+		}
+		parser.Execute()
+		if err := parser.Validate(); err != nil {
+			fatal(fmt.Errorf("%s: %v", source, err))
+		}
+		graph = &parser.BaseFbp
+	}
+
+	code, err := graph.MarshalGo(&fbp.GoOptions{Package: *pkg, Name: *name, Source: source})
+	if err != nil {
+		fatal(err)
+	}
+	if *output == "-" {
+		os.Stdout.Write(code)
+		return
+	}
+	if *output == "" {
+		base := filepath.Base(source)
+		*output = filepath.Join(filepath.Dir(source), strings.TrimSuffix(base, filepath.Ext(base))+"_fbp.go")
+	}
+	if err := os.WriteFile(*output, code, 0644); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "fbpgen:", err)
+	os.Exit(1)
+}
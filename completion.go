@@ -0,0 +1,323 @@
+package fbp
+
+import (
+	"sort"
+	"strings"
+)
+
+//
+// Components tells completion which components there are and what their
+// ports are (runtime.Registry implements it)
+//
+type Components interface {
+	Names() []string
+	Ports(component string) (inPorts, outPorts []string)
+}
+
+//
+// Kind of a completion
+//
+type CompletionKind int
+
+const (
+	CompletionProcess CompletionKind = iota + 1
+	CompletionComponent
+	CompletionInPort
+	CompletionOutPort
+)
+
+func (k CompletionKind) String() string {
+	switch k {
+	case CompletionProcess:
+		return "process"
+	case CompletionComponent:
+		return "component"
+	case CompletionInPort:
+		return "inport"
+	case CompletionOutPort:
+		return "outport"
+	}
+	return "unknown"
+}
+
+//
+// Completion replaces Begin:End of the source (the word at the cursor) with
+// Label. Detail is the component of a process or a port.
+//
+type Completion struct {
+	Label      string
+	Kind       CompletionKind
+	Detail     string
+	Begin, End int
+}
+
+// Complete returns the completions at offset (in bytes) of src, the lines
+// (or IIPs spanning lines) that don't parse, e.g. the one being typed, are
+// left out of the graph:
+//  - declared processes at the beginning of a line and after "->" or the
+//     in-port of a target,
+//  - components inside "(" of a process,
+//  - in-ports of the process following the cursor ("-> | Process"),
+//  - out-ports of the process preceding the cursor ("Process |"),
+//  - processes and their ports in INPORT/OUTPORT lines.
+//
+// The completions match the word typed so far (ignoring case) and are
+// sorted. Ports and components come from components, which may be nil.
+func Complete(src []byte, offset int, components Components) []*Completion {
+	return NewIncrementalParser(src).Complete(offset, components)
+}
+
+// Complete returns the completions at offset of the source as Complete()
+// does, with the symbols of the lines already parsed
+func (p *IncrementalParser) Complete(offset int, components Components) []*Completion {
+	buffer := string(p.Source())
+	if offset < 0 || offset > len(buffer) {
+		return nil
+	}
+	lineBegin := strings.LastIndexAny(buffer[:offset], "\n\r") + 1
+	// the line of an IIP spanning lines begins with the IIP
+	begin := 0
+	for _, l := range p.lines {
+		if lineBegin < begin+len(l.text) {
+			lineBegin = begin
+			break
+		}
+		begin += len(l.text)
+	}
+	lineEnd := len(buffer)
+	if i := strings.IndexAny(buffer[offset:], "\n\r"); i >= 0 {
+		lineEnd = offset + i
+	}
+	c := &completer{
+		index:      p.symbols(),
+		components: components,
+		end:        offset,
+	}
+	// the rest of the word at the cursor is replaced too
+	for c.end < lineEnd && isWordChar(buffer[c.end]) {
+		c.end++
+	}
+	c.complete(buffer[lineBegin:offset], lineBegin, buffer[c.end:lineEnd])
+	sort.SliceStable(c.completions, func(i, j int) bool {
+		return c.completions[i].Label < c.completions[j].Label
+	})
+	return c.completions
+}
+
+type completer struct {
+	index       *symbolIndex
+	components  Components
+	end         int
+	completions []*Completion
+}
+
+// lineToken is a word (process or port) or an arrow of the line being typed
+type lineToken struct {
+	arrow      bool
+	iip        bool
+	text       string
+	component  string
+	begin, end int
+}
+
+func (c *completer) complete(before string, lineBegin int, after string) {
+	trimmed := strings.TrimLeft(before, " \t")
+	for _, directive := range []string{"INPORT=", "OUTPORT="} {
+		if strings.HasPrefix(strings.ToUpper(trimmed), directive) {
+			c.completeExport(directive, trimmed[len(directive):], lineBegin+len(before)-len(trimmed)+len(directive))
+			return
+		}
+	}
+
+	var tokens []*lineToken
+	for i := 0; i < len(before); {
+		switch ch := before[i]; {
+		case ch == ' ' || ch == '\t' || ch == ',':
+			i++
+		case ch == '#':
+			return
+		case ch == '\'':
+			end := i + 1
+			for end < len(before) && (before[end] != '\'' || before[end-1] == '\\') {
+				end++
+			}
+			if end == len(before) {
+				return // inside IIP
+			}
+			tokens = append(tokens, &lineToken{iip: true, begin: i, end: end + 1})
+			i = end + 1
+		case strings.HasPrefix(before[i:], "->"):
+			tokens = append(tokens, &lineToken{arrow: true, begin: i, end: i + 2})
+			i += 2
+		case isWordChar(ch):
+			t := &lineToken{begin: i}
+			for i < len(before) && isWordChar(before[i]) {
+				i++
+			}
+			t.text = before[t.begin:i]
+			if i < len(before) && before[i] == '[' {
+				close := strings.IndexByte(before[i:], ']')
+				if close < 0 {
+					return // inside index
+				}
+				i += close + 1
+			}
+			if i < len(before) && before[i] == '(' {
+				close := strings.IndexByte(before[i:], ')')
+				if close < 0 {
+					c.completeComponent(before[i+1:], lineBegin+i+1)
+					return
+				}
+				t.component = before[i+1 : i+close]
+				if colon := strings.IndexByte(t.component, ':'); colon >= 0 {
+					t.component = t.component[:colon]
+				}
+				i += close + 1
+			}
+			t.end = i
+			tokens = append(tokens, t)
+		default:
+			return
+		}
+	}
+
+	// the word being typed
+	prefix, begin := "", lineBegin+len(before)
+	if n := len(tokens); n > 0 && !tokens[n-1].arrow && !tokens[n-1].iip && tokens[n-1].end == len(before) && tokens[n-1].component == "" {
+		prefix, begin = tokens[n-1].text, lineBegin+tokens[n-1].begin
+		tokens = tokens[:n-1]
+	}
+	arrow := false
+	var segment []*lineToken
+	for _, t := range tokens {
+		if t.arrow {
+			arrow, segment = true, nil
+		} else {
+			segment = append(segment, t)
+		}
+	}
+	if len(segment) > 0 && segment[0].iip {
+		return
+	}
+
+	switch {
+	case !arrow && len(segment) == 0:
+		c.completeProcesses(prefix, begin)
+	case !arrow && len(segment) == 1:
+		c.completePorts(CompletionOutPort, c.componentOf(segment[0]), prefix, begin)
+	case arrow && len(segment) == 0:
+		if node := leadingWord(after); node != "" {
+			c.completePorts(CompletionInPort, c.componentOf(&lineToken{text: node}), prefix, begin)
+		} else {
+			c.completeProcesses(prefix, begin)
+		}
+	case arrow && len(segment) == 1:
+		c.completeProcesses(prefix, begin)
+	case arrow && len(segment) == 2:
+		c.completePorts(CompletionOutPort, c.componentOf(segment[1]), prefix, begin)
+	}
+}
+
+// completeExport completes Process.PORT of an INPORT/OUTPORT line
+func (c *completer) completeExport(directive, text string, begin int) {
+	dot := strings.IndexByte(text, '.')
+	if dot < 0 {
+		c.completeProcesses(text, begin)
+		return
+	}
+	if strings.ContainsAny(text[dot+1:], ": \t") {
+		return
+	}
+	kind := CompletionInPort
+	if directive == "OUTPORT=" {
+		kind = CompletionOutPort
+	}
+	c.completePorts(kind, c.componentOf(&lineToken{text: text[:dot]}), text[dot+1:], begin+dot+1)
+}
+
+func (c *completer) completeProcesses(prefix string, begin int) {
+	for _, name := range c.index.names {
+		if c.typed(name, begin) {
+			continue
+		}
+		detail := ""
+		if d := c.index.declaration(name); d != nil {
+			detail = d.Component
+		}
+		c.add(CompletionProcess, name, detail, prefix, begin)
+	}
+}
+
+func (c *completer) completeComponent(prefix string, begin int) {
+	if c.components == nil || strings.IndexByte(prefix, ':') >= 0 {
+		return
+	}
+	for _, name := range c.components.Names() {
+		c.add(CompletionComponent, name, "", prefix, begin)
+	}
+}
+
+func (c *completer) completePorts(kind CompletionKind, component, prefix string, begin int) {
+	if c.components == nil || component == "" {
+		return
+	}
+	inPorts, outPorts := c.components.Ports(component)
+	ports := inPorts
+	if kind == CompletionOutPort {
+		ports = outPorts
+	}
+	for _, port := range ports {
+		c.add(kind, port, component, prefix, begin)
+	}
+}
+
+func (c *completer) add(kind CompletionKind, label, detail, prefix string, begin int) {
+	if !strings.HasPrefix(strings.ToLower(label), strings.ToLower(prefix)) {
+		return
+	}
+	c.completions = append(c.completions, &Completion{
+		Label:  label,
+		Kind:   kind,
+		Detail: detail,
+		Begin:  begin,
+		End:    c.end,
+	})
+}
+
+// typed reports whether the only occurrence of a process is the word being
+// typed, which parses as a process of its own
+func (c *completer) typed(name string, begin int) bool {
+	for _, s := range c.index.processes[name] {
+		if s.Begin != begin {
+			return false
+		}
+	}
+	return true
+}
+
+// componentOf returns the component of a process written on the line being
+// typed or declared elsewhere
+func (c *completer) componentOf(t *lineToken) string {
+	if t.component != "" {
+		return t.component
+	}
+	if d := c.index.declaration(t.text); d != nil {
+		return d.Component
+	}
+	return ""
+}
+
+// leadingWord returns the process name after the cursor, " Process" or
+// " Process(component)"
+func leadingWord(after string) string {
+	after = strings.TrimLeft(after, " \t")
+	end := 0
+	for end < len(after) && isWordChar(after[end]) && after[end] != '.' {
+		end++
+	}
+	return after[:end]
+}
+
+func isWordChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_' || ch == '.'
+}
@@ -0,0 +1,90 @@
+package fbp
+
+import (
+	"strings"
+	"testing"
+)
+
+type testComponents map[string][2][]string
+
+func (c testComponents) Names() []string {
+	return []string{"core/out", "fs/read", "fs/write"}
+}
+
+func (c testComponents) Ports(component string) ([]string, []string) {
+	return c[component][0], c[component][1]
+}
+
+var components = testComponents{
+	"fs/read":  {{"IN", "ENCODING"}, {"OUT", "ERROR"}},
+	"core/out": {{"IN"}, {"OUT"}},
+}
+
+func TestComplete(t *testing.T) {
+	graph := "INPORT=Read.IN:FILE\n'x' -> IN Read(fs/read) OUT -> IN Display(core/out)\n"
+	for _, test := range []struct {
+		src        string // | is the cursor
+		expected   string
+		begin, end int // of the replaced word, relative to the cursor
+	}{
+		{"|", "process:Display:core/out process:Read:fs/read", 0, 0},
+		{"R|", "process:Read:fs/read", -1, 0},
+		{"Read |", "outport:ERROR:fs/read outport:OUT:fs/read", 0, 0},
+		{"Read E|", "outport:ERROR:fs/read", -1, 0},
+		{"Read OUT -> |", "process:Display:core/out process:Read:fs/read", 0, 0},
+		{"Read OUT -> IN D|", "process:Display:core/out", -1, 0},
+		{"Read OUT -> | Display", "inport:IN:core/out", 0, 0},
+		{"Read OUT -> |IN Display", "inport:IN:core/out", 0, 2},
+		{"Read OUT -> IN Display |", "outport:OUT:core/out", 0, 0},
+		{"Read ERROR -> IN Log(|", "component:core/out: component:fs/read: component:fs/write:", 0, 0},
+		{"Read ERROR -> IN Log(fs/w|", "component:fs/write:", -4, 0},
+		{"Read ERROR -> IN Log(fs/write:a=|", "", 0, 0},
+		{"Writer(fs/write) |", "", 0, 0},
+		{"New(fs/read) |", "outport:ERROR:fs/read outport:OUT:fs/read", 0, 0},
+		{"'Read |", "", 0, 0},
+		{"# Read |", "", 0, 0},
+		{"'x' |", "", 0, 0},
+		{"Read OUT |", "", 0, 0},
+		{"OUTPORT=|", "process:Display:core/out process:Read:fs/read", 0, 0},
+		{"OUTPORT=Display.|", "outport:OUT:core/out", 0, 0},
+		{"INPORT=Read.E|", "inport:ENCODING:fs/read", -1, 0},
+		{"outport=Display.|", "outport:OUT:core/out", 0, 0},
+		{"inport=Read.E|", "inport:ENCODING:fs/read", -1, 0},
+	} {
+		src := graph + test.src + "\nRead ERROR -> IN Display\n"
+		cursor := strings.Index(src, "|")
+		src = src[:cursor] + src[cursor+1:]
+		var labels []string
+		for _, c := range Complete([]byte(src), cursor, components) {
+			labels = append(labels, c.Kind.String()+":"+c.Label+":"+c.Detail)
+			if c.Begin != cursor+test.begin || c.End != cursor+test.end {
+				t.Errorf("%q: unexpected range %d:%d of %s", test.src, c.Begin-cursor, c.End-cursor, c.Label)
+			}
+		}
+		if strings.Join(labels, " ") != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.src, test.expected, strings.Join(labels, " "))
+		}
+	}
+
+	if completions := Complete([]byte("A(x) OUT -> IN B\n"), 17, nil); len(completions) != 2 {
+		t.Fatalf("Unexpected completions without components %v", completions)
+	}
+	if completions := Complete([]byte("A"), 5, nil); completions != nil {
+		t.Fatalf("Unexpected completions %v", completions)
+	}
+}
+
+func TestCompleteMultilineIIP(t *testing.T) {
+	src := "'one\ntwo' -> IN Read(fs/read)\nRead OUT -> IN Display(core/out)\nRead OUT -> IN D"
+	p := NewIncrementalParser([]byte(src))
+	completions := p.Complete(len(src), components)
+	if len(completions) != 1 || completions[0].Label != "Display" {
+		t.Fatalf("Unexpected completions %v", completions)
+	}
+
+	// the line being typed is the end of an IIP
+	src = "Read(fs/read) OUT -> IN Display(core/out)\n'x\ny' -> IN R"
+	if completions := Complete([]byte(src), len(src), components); len(completions) != 1 || completions[0].Detail != "fs/read" {
+		t.Fatalf("Unexpected completions %v", completions)
+	}
+}
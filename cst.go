@@ -0,0 +1,272 @@
+package fbp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//
+// Kind of a node of the concrete syntax tree
+//
+type CSTKind int
+
+const (
+	CSTFile CSTKind = iota + 1
+	CSTLine
+	CSTConnection
+	CSTBridge
+	CSTNode
+	CSTComponent
+	CSTPort
+	CSTIIP
+	CSTComment
+	// Process.PORT:NAME of INPORT, OUTPORT and EXPORT lines
+	CSTExport
+	// INPORT=, OUTPORT= or EXPORT=
+	CSTDirective
+	// process, component or port name
+	CSTName
+	CSTIndex
+	CSTMetadata
+	// whitespace, line terminators and punctuation
+	CSTTrivia
+)
+
+var cstKindNames = map[CSTKind]string{
+	CSTFile:       "file",
+	CSTLine:       "line",
+	CSTConnection: "connection",
+	CSTBridge:     "bridge",
+	CSTNode:       "node",
+	CSTComponent:  "component",
+	CSTPort:       "port",
+	CSTIIP:        "iip",
+	CSTComment:    "comment",
+	CSTExport:     "export",
+	CSTDirective:  "directive",
+	CSTName:       "name",
+	CSTIndex:      "index",
+	CSTMetadata:   "metadata",
+	CSTTrivia:     "trivia",
+}
+
+func (k CSTKind) String() string {
+	if name, ok := cstKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("CSTKind(%d)", int(k))
+}
+
+//
+// CST is a node of the concrete syntax tree of a .fbp source. The nodes
+// follow the rules of the grammar (connection -> bridge -> node, port and
+// so on), Begin and End are byte offsets. Leaves (names, IIPs, comments,
+// trivia...) have Text, the children of the other nodes cover them with
+// no gaps, so that String() of the tree is exactly the parsed source.
+//
+type CST struct {
+	Kind       CSTKind
+	Begin, End int
+	Text       string
+	Children   []*CST
+}
+
+// ParseCST parses src into its concrete syntax tree (of CSTFile kind)
+func ParseCST(src []byte) (*CST, error) {
+	parser := &Fbp{Buffer: string(src)}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		return nil, err
+	}
+	b := &cstBuilder{buffer: parser.Buffer}
+	root := parser.syntaxTree()
+	return b.node(CSTFile, 0, len(b.buffer), b.children(root)), nil
+}
+
+// String returns the source text of the node
+func (c *CST) String() string {
+	var buf bytes.Buffer
+	c.WriteTo(&buf)
+	return buf.String()
+}
+
+// WriteTo writes the source text of the node to w
+func (c *CST) WriteTo(w io.Writer) (int64, error) {
+	if len(c.Children) == 0 {
+		n, err := io.WriteString(w, c.Text)
+		return int64(n), err
+	}
+	var total int64
+	for _, child := range c.Children {
+		n, err := child.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Walk calls f for the node and its descendants depth first, the children
+// of a node are skipped if f returns false for it
+func (c *CST) Walk(f func(*CST) bool) {
+	if !f(c) {
+		return
+	}
+	for _, child := range c.Children {
+		child.Walk(f)
+	}
+}
+
+// Find returns the descendants of the given kind in the order of the source
+func (c *CST) Find(kind CSTKind) []*CST {
+	var found []*CST
+	c.Walk(func(n *CST) bool {
+		if n.Kind == kind && n != c {
+			found = append(found, n)
+		}
+		return true
+	})
+	return found
+}
+
+// Child returns the first child of the given kind or nil
+func (c *CST) Child(kind CSTKind) *CST {
+	for _, child := range c.Children {
+		if child.Kind == kind {
+			return child
+		}
+	}
+	return nil
+}
+
+type cstBuilder struct {
+	buffer string
+}
+
+// node returns a node with children and trivia in the gaps between them
+func (b *cstBuilder) node(kind CSTKind, begin, end int, children []*CST) *CST {
+	c := &CST{Kind: kind, Begin: begin, End: end}
+	offset := begin
+	for _, child := range children {
+		if child.Begin > offset {
+			c.Children = append(c.Children, b.leaf(CSTTrivia, offset, child.Begin))
+		}
+		c.Children = append(c.Children, child)
+		offset = child.End
+	}
+	if end > offset {
+		c.Children = append(c.Children, b.leaf(CSTTrivia, offset, end))
+	}
+	return c
+}
+
+func (b *cstBuilder) leaf(kind CSTKind, begin, end int) *CST {
+	return &CST{Kind: kind, Begin: begin, End: end, Text: b.buffer[begin:end]}
+}
+
+func (b *cstBuilder) children(n *syntaxNode) []*CST {
+	var children []*CST
+	for _, c := range n.children {
+		children = append(children, b.convert(c)...)
+	}
+	return children
+}
+
+// convert returns the nodes of a grammar rule, the rules with no node of
+// their own (leftlet, rightlet, LineTerminator) give their children and
+// whitespace rules give nothing (they become trivia)
+func (b *cstBuilder) convert(n *syntaxNode) []*CST {
+	switch n.rule {
+	case ruleline:
+		return []*CST{b.line(n)}
+	case ruleLineTerminator, ruleleftlet, rulerightlet:
+		return b.children(n)
+	case ruleconnection:
+		return []*CST{b.node(CSTConnection, n.begin, n.end, b.children(n))}
+	case rulebridge:
+		// without the whitespace after the out-port
+		children := b.children(n)
+		end := n.end
+		if len(children) > 0 {
+			end = children[len(children)-1].End
+		}
+		return []*CST{b.node(CSTBridge, n.begin, end, children)}
+	case rulenode:
+		return []*CST{b.node(CSTNode, n.begin, n.end, b.children(n))}
+	case rulecomponent:
+		return []*CST{b.node(CSTComponent, n.begin, n.end, b.children(n))}
+	case rulecompMeta:
+		if meta := n.child(rulePegText); meta != nil {
+			return []*CST{b.leaf(CSTMetadata, meta.begin, meta.end)}
+		}
+	case ruleport, ruleportWithIndex:
+		// without the whitespace after the port
+		var children []*CST
+		end := n.begin
+		for i, c := range n.children {
+			if c.rule != rulePegText {
+				continue
+			}
+			if i == 0 {
+				children = append(children, b.leaf(CSTName, c.begin, c.end))
+				end = c.end
+			} else {
+				children = append(children, b.leaf(CSTIndex, c.begin, c.end))
+				end = c.end + 1 // "]"
+			}
+		}
+		return []*CST{b.node(CSTPort, n.begin, end, children)}
+	case rulePegText:
+		// process and component names, other captures are handled above
+		return []*CST{b.leaf(CSTName, n.begin, n.end)}
+	case ruleiip:
+		return []*CST{b.leaf(CSTIIP, n.begin, n.end)}
+	case rulecomment:
+		return []*CST{b.leaf(CSTComment, n.begin+strings.IndexByte(n.text(b.buffer), '#'), n.end)}
+	}
+	return nil
+}
+
+// line returns a line, INPORT/OUTPORT/EXPORT lines get a directive and an
+// export node
+func (b *cstBuilder) line(n *syntaxNode) *CST {
+	text := n.text(b.buffer)
+	trimmed := strings.TrimLeft(text, " \t")
+	begin := n.begin + len(text) - len(trimmed)
+	for _, directive := range []string{"INPORT=", "OUTPORT=", "EXPORT="} {
+		if !strings.HasPrefix(strings.ToUpper(trimmed), directive) {
+			continue
+		}
+		children := []*CST{b.leaf(CSTDirective, begin, begin+len(directive))}
+		if export := b.export(begin+len(directive), trimmed[len(directive):]); export != nil {
+			children = append(children, export)
+		}
+		if terminator := n.child(ruleLineTerminator); terminator != nil {
+			children = append(children, b.children(terminator)...)
+		}
+		return b.node(CSTLine, n.begin, n.end, children)
+	}
+	return b.node(CSTLine, n.begin, n.end, b.children(n))
+}
+
+// export returns the node of Process.PORT:NAME at offset
+func (b *cstBuilder) export(offset int, text string) *CST {
+	parts, ok := splitExport(text)
+	if !ok {
+		return nil
+	}
+	portBegin, portEnd := offset+parts.dot+1, offset+parts.colon
+	ports := []*CST{b.leaf(CSTName, portBegin, offset+parts.portEnd)}
+	if parts.indexBegin >= 0 {
+		ports = append(ports, b.leaf(CSTIndex, offset+parts.indexBegin, offset+parts.indexEnd))
+	}
+	children := []*CST{
+		b.leaf(CSTName, offset, offset+parts.dot),
+		b.node(CSTPort, portBegin, portEnd, ports),
+		b.leaf(CSTName, offset+parts.colon+1, offset+parts.end),
+	}
+	return b.node(CSTExport, offset, offset+parts.end, children)
+}
@@ -0,0 +1,103 @@
+package fbp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCSTLossless(t *testing.T) {
+	for _, src := range []string{
+		graphIIP, graphTickLogger, graphOneLiner, graphDemo, graphExportedInPort,
+		graphArrayPorts, graphExportedArrayPort, graphUnformatted, graphFormatted,
+		"",
+		"\n\n   \n",
+		"  # comment only",
+		"'ü -> 𝄞' -> IN A(core/a:x=1,y=2) OUT -> IN B()\r\nA ERROR -> IN[3] C(c), # trailing\r",
+		"\tINPORT=A.IN[2]:IN # exported\nEXPORT=A.OUT:OUT,\nA(a) OUT -> IN B(b)",
+	} {
+		cst, err := ParseCST([]byte(src))
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		if cst.String() != src {
+			t.Fatalf("Expected %q, got %q", src, cst.String())
+		}
+		// every node is covered by its children
+		cst.Walk(func(n *CST) bool {
+			if len(n.Children) == 0 && src[n.Begin:n.End] != n.Text {
+				t.Fatalf("Leaf %s %d:%d has text %q", n.Kind, n.Begin, n.End, n.Text)
+			}
+			offset := n.Begin
+			for _, c := range n.Children {
+				if c.Begin != offset {
+					t.Fatalf("Gap before %s at %d in %q", c.Kind, offset, src)
+				}
+				offset = c.End
+			}
+			if len(n.Children) > 0 && offset != n.End {
+				t.Fatalf("Gap at the end of %s in %q", n.Kind, src)
+			}
+			return true
+		})
+	}
+}
+
+func TestCSTStructure(t *testing.T) {
+	src := "INPORT=Read.IN[0]:FILE\n'x' -> IN Read(fs/read:a=1) OUT -> IN Display(core/out) # show\n"
+	cst, err := ParseCST([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := cst.Find(CSTLine)
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected lines %v", lines)
+	}
+
+	export := lines[0].Child(CSTExport)
+	if lines[0].Child(CSTDirective).Text != "INPORT=" || export == nil || export.String() != "Read.IN[0]:FILE" {
+		t.Fatalf("Unexpected export %+v", lines[0])
+	}
+	port := export.Child(CSTPort)
+	if port.Child(CSTName).Text != "IN" || port.Child(CSTIndex).Text != "0" {
+		t.Fatalf("Unexpected port %v", port)
+	}
+
+	connection := lines[1].Child(CSTConnection)
+	var bridges []string
+	for c := connection; c != nil; c = c.Child(CSTConnection) {
+		bridges = append(bridges, c.Child(CSTBridge).String())
+	}
+	if strings.Join(bridges, "|") != "'x'|IN Read(fs/read:a=1) OUT|IN Display(core/out)" {
+		t.Fatalf("Unexpected bridges %q", bridges)
+	}
+	nodes := lines[1].Find(CSTNode)
+	if len(nodes) != 2 || nodes[0].Child(CSTName).Text != "Read" {
+		t.Fatalf("Unexpected nodes %v", nodes)
+	}
+	component := nodes[0].Child(CSTComponent)
+	if component.Child(CSTName).Text != "fs/read" || component.Child(CSTMetadata).Text != "a=1" {
+		t.Fatalf("Unexpected component %v", component)
+	}
+	if comments := cst.Find(CSTComment); len(comments) != 1 || comments[0].Text != "# show" {
+		t.Fatalf("Unexpected comments %v", comments)
+	}
+	if iips := cst.Find(CSTIIP); len(iips) != 1 || iips[0].Text != "'x'" {
+		t.Fatalf("Unexpected IIPs %v", iips)
+	}
+
+	if _, err := ParseCST([]byte("A OUT ->\n")); err == nil {
+		t.Fatal("Expected syntax error")
+	}
+}
+
+func TestCSTLowerCaseDirective(t *testing.T) {
+	cst, err := ParseCST([]byte("outport=Display.OUT:OUT\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := cst.Find(CSTLine)[0]
+	export := line.Child(CSTExport)
+	if line.Child(CSTDirective).Text != "outport=" || export == nil || export.String() != "Display.OUT:OUT" {
+		t.Fatalf("Unexpected export %+v", line)
+	}
+}
@@ -0,0 +1,183 @@
+package fbp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//
+// Severity of a diagnostic
+//
+type Severity int
+
+const (
+	SeverityError Severity = iota + 1
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	for _, severity := range []Severity{SeverityError, SeverityWarning, SeverityInfo} {
+		if severity.String() == string(text) {
+			*s = severity
+			return nil
+		}
+	}
+	return fmt.Errorf("fbp: unknown severity %q", text)
+}
+
+//
+// Diagnostic is a problem found in a .fbp source. Begin and End are byte
+// offsets, Line and Column (1-based, column in characters) locate Begin.
+// Rule is the ID of the lint rule reporting it.
+//
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Begin    int      `json:"begin"`
+	End      int      `json:"end"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Message  string   `json:"message"`
+	Rule     string   `json:"rule,omitempty"`
+}
+
+func (d *Diagnostic) String() string {
+	if d.Rule != "" {
+		return fmt.Sprintf("%d:%d: %s: %s [%s]", d.Line, d.Column, d.Severity, d.Message, d.Rule)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Message)
+}
+
+// Check parses src and reports the syntax error or, if there is none, the
+// problems of the graph, in the order of the source:
+//  - processes declared twice with different components (error),
+//  - exported ports of unknown processes and exported twice (error),
+//  - processes without a component (warning).
+func Check(src []byte) []*Diagnostic {
+	buffer := string(src)
+	parser := &Fbp{Buffer: buffer}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		return []*Diagnostic{parser.syntaxError()}
+	}
+	return checkSymbols(buffer, newSymbolIndex(parser.syntaxTree(), buffer))
+}
+
+// checkSymbols returns the diagnostics of a source that parses
+func checkSymbols(buffer string, index *symbolIndex) []*Diagnostic {
+	var diagnostics []*Diagnostic
+	report := func(severity Severity, begin, end int, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, newDiagnostic(buffer, severity, begin, end, fmt.Sprintf(format, args...)))
+	}
+	for _, name := range index.names {
+		var declaration *Symbol
+		for _, s := range index.processes[name] {
+			if s.Component == "" {
+				continue
+			}
+			if declaration == nil {
+				declaration = s
+			} else if s.Component != declaration.Component {
+				report(SeverityError, s.Begin, s.End, "process %s is already declared as %s(%s) on line %d",
+					name, name, declaration.Component, lineOf(buffer, declaration.Begin))
+			}
+		}
+		if declaration == nil {
+			s := index.processes[name][0]
+			report(SeverityWarning, s.Begin, s.End, "process %s has no component", name)
+		}
+	}
+	exported := make(map[string]*export)
+	for _, e := range index.exports {
+		if _, ok := index.processes[e.process.Name]; !ok {
+			report(SeverityError, e.process.Begin, e.process.End, "%s of unknown process %s", e.kind, e.process.Name)
+		}
+		key := e.kind + ":" + e.name
+		if first, ok := exported[key]; ok {
+			report(SeverityError, e.nameBegin, e.nameBegin+len(e.name), "%s %s is already exported on line %d",
+				e.kind, e.name, lineOf(buffer, first.begin))
+		} else {
+			exported[key] = e
+		}
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Begin < diagnostics[j].Begin
+	})
+	return diagnostics
+}
+
+// syntaxError returns the diagnostic of a failed Parse()
+func (p *Fbp) syntaxError() *Diagnostic {
+	begin, end, message := p.syntaxErrorRange()
+	return newDiagnostic(p.Buffer, SeverityError, begin, end, message)
+}
+
+// syntaxErrorRange returns the range and message of the error of a failed
+// Parse(). The parser gives no position, the error is where the longest
+// match ended.
+func (p *Fbp) syntaxErrorRange() (int, int, string) {
+	farthest := 0
+	for token := range p.tokenTree.Tokens() {
+		if int(token.end) > farthest {
+			farthest = int(token.end)
+		}
+	}
+	// token offsets are rune indexes
+	begin := len(p.Buffer)
+	if farthest < utf8.RuneCountInString(p.Buffer) {
+		begin = len(string([]rune(p.Buffer)[:farthest]))
+	}
+	end := begin
+	for end < len(p.Buffer) && p.Buffer[end] != '\n' && p.Buffer[end] != '\r' {
+		end++
+	}
+	message := "syntax error: unexpected end of line"
+	if near := strings.TrimSpace(p.Buffer[begin:end]); near != "" {
+		if fields := strings.Fields(near); len(fields) > 0 {
+			near = fields[0]
+		}
+		message = fmt.Sprintf("syntax error near %q", near)
+	}
+	return begin, end, message
+}
+
+func newDiagnostic(buffer string, severity Severity, begin, end int, message string) *Diagnostic {
+	line, column := position(buffer, begin)
+	return &Diagnostic{
+		Severity: severity,
+		Begin:    begin,
+		End:      end,
+		Line:     line,
+		Column:   column,
+		Message:  message,
+	}
+}
+
+// position returns the line and column (1-based, in characters) of offset
+func position(buffer string, offset int) (int, int) {
+	start := strings.LastIndex(buffer[:offset], "\n") + 1
+	return strings.Count(buffer[:offset], "\n") + 1, utf8.RuneCountInString(buffer[start:offset]) + 1
+}
+
+func lineOf(buffer string, offset int) int {
+	line, _ := position(buffer, offset)
+	return line
+}
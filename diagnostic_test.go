@@ -0,0 +1,40 @@
+package fbp
+
+import (
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	for _, test := range []struct {
+		src      string
+		expected []string
+	}{
+		{"A(core/a) OUT -> IN B(core/b)\n", nil},
+		{"A(core/a) OUT -> IN B(core/b)\nB OUT -> \n", []string{
+			`2:7: error: syntax error near "->"`,
+		}},
+		{"A(core/a) OUT -> IN B(core/b)\nB OUT -> IN A(core/c)\n", []string{
+			"2:13: error: process A is already declared as A(core/a) on line 1",
+		}},
+		{"INPORT=C.IN:IN\nINPORT=A.IN:IN\nA(core/a) OUT -> IN B(core/b)\n", []string{
+			"1:8: error: INPORT of unknown process C",
+			"2:13: error: INPORT IN is already exported on line 1",
+		}},
+		{"# ü\nA(core/a) OUT -> IN B\n", []string{
+			"2:21: warning: process B has no component",
+		}},
+	} {
+		var messages []string
+		for _, d := range Check([]byte(test.src)) {
+			messages = append(messages, d.String())
+		}
+		if len(messages) != len(test.expected) {
+			t.Fatalf("Unexpected diagnostics of %q: %q", test.src, messages)
+		}
+		for i := range messages {
+			if messages[i] != test.expected[i] {
+				t.Errorf("Expected %q, got %q", test.expected[i], messages[i])
+			}
+		}
+	}
+}
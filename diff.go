@@ -0,0 +1,249 @@
+package fbp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+//
+// Process added (Old is nil), removed (New is nil) or with a different
+// component or metadata
+//
+type ProcessChange struct {
+	Name     string
+	Old, New *Process
+}
+
+//
+// Connection or IIP added (Old is nil) or removed (New is nil), or IIP
+// with a different value
+//
+type ConnectionChange struct {
+	Old, New *Connection
+}
+
+//
+// Exported port added (Old is nil), removed (New is nil) or remapped to
+// another process or port
+//
+type PortChange struct {
+	Name     string
+	Old, New *Endpoint
+}
+
+//
+// GraphDiff is the structural difference between two graphs. Connections
+// are told apart by their endpoints and IIPs by their target, the order of
+// the connections and the metadata of connections don't count.
+//
+type GraphDiff struct {
+	Processes   []*ProcessChange
+	Connections []*ConnectionChange
+	IIPs        []*ConnectionChange
+	Inports     []*PortChange
+	Outports    []*PortChange
+}
+
+// Diff returns what changed from graph a to graph b. The changes of a kind
+// are in the order of a, followed by what b adds in its order.
+func Diff(a, b *BaseFbp) *GraphDiff {
+	d := &GraphDiff{}
+	d.diffProcesses(a.Processes, b.Processes)
+	d.Connections = diffConnections(connections(a, false), connections(b, false), false)
+	d.IIPs = diffConnections(connections(a, true), connections(b, true), true)
+	d.Inports = diffPorts(a.Inports, b.Inports)
+	d.Outports = diffPorts(a.Outports, b.Outports)
+	return d
+}
+
+// Empty reports whether the graphs are the same
+func (d *GraphDiff) Empty() bool {
+	return len(d.Processes) == 0 && len(d.Connections) == 0 && len(d.IIPs) == 0 &&
+		len(d.Inports) == 0 && len(d.Outports) == 0
+}
+
+// String returns the changes one per line (see WriteTo)
+func (d *GraphDiff) String() string {
+	var buf bytes.Buffer
+	d.WriteTo(&buf)
+	return buf.String()
+}
+
+// WriteTo writes the changes to w one per line, "+" for additions, "-" for
+// removals and "~" for changes:
+//
+//	~ process Split(strings/split) -> Split(strings/join)
+//	- connection Count ERROR -> IN Log
+//	~ iip -> IN Split: '\n' -> ','
+//	+ inport FILENAME: Read.SOURCE
+func (d *GraphDiff) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, c := range d.Processes {
+		switch {
+		case c.Old == nil:
+			fmt.Fprintf(&buf, "+ process %s\n", formatProcess(c.New))
+		case c.New == nil:
+			fmt.Fprintf(&buf, "- process %s\n", formatProcess(c.Old))
+		default:
+			fmt.Fprintf(&buf, "~ process %s -> %s\n", formatProcess(c.Old), formatProcess(c.New))
+		}
+	}
+	for _, c := range d.Connections {
+		if c.Old == nil {
+			fmt.Fprintf(&buf, "+ connection %s\n", formatConnection(c.New))
+		} else {
+			fmt.Fprintf(&buf, "- connection %s\n", formatConnection(c.Old))
+		}
+	}
+	for _, c := range d.IIPs {
+		switch {
+		case c.Old == nil:
+			fmt.Fprintf(&buf, "+ iip %s\n", formatConnection(c.New))
+		case c.New == nil:
+			fmt.Fprintf(&buf, "- iip %s\n", formatConnection(c.Old))
+		default:
+			fmt.Fprintf(&buf, "~ iip -> %s %s: %s -> %s\n", formatPort(c.New.Target), c.New.Target.Process,
+				formatIIP(c.Old.Data), formatIIP(c.New.Data))
+		}
+	}
+	writePortChanges(&buf, "inport", d.Inports)
+	writePortChanges(&buf, "outport", d.Outports)
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func writePortChanges(buf *bytes.Buffer, kind string, changes []*PortChange) {
+	for _, c := range changes {
+		switch {
+		case c.Old == nil:
+			fmt.Fprintf(buf, "+ %s %s: %s\n", kind, c.Name, endpointKey(c.New))
+		case c.New == nil:
+			fmt.Fprintf(buf, "- %s %s: %s\n", kind, c.Name, endpointKey(c.Old))
+		default:
+			fmt.Fprintf(buf, "~ %s %s: %s -> %s\n", kind, c.Name, endpointKey(c.Old), endpointKey(c.New))
+		}
+	}
+}
+
+func (d *GraphDiff) diffProcesses(a, b []*Process) {
+	processes := make(map[string]*Process, len(b))
+	for _, p := range b {
+		processes[p.Name] = p
+	}
+	old := make(map[string]bool, len(a))
+	for _, p := range a {
+		old[p.Name] = true
+		q, ok := processes[p.Name]
+		if !ok {
+			d.Processes = append(d.Processes, &ProcessChange{Name: p.Name, Old: p})
+		} else if !sameProcess(p, q) {
+			d.Processes = append(d.Processes, &ProcessChange{Name: p.Name, Old: p, New: q})
+		}
+	}
+	for _, p := range b {
+		if !old[p.Name] {
+			d.Processes = append(d.Processes, &ProcessChange{Name: p.Name, New: p})
+		}
+	}
+}
+
+func sameProcess(p, q *Process) bool {
+	if p.Component != q.Component {
+		return false
+	}
+	return len(p.Metadata) == 0 && len(q.Metadata) == 0 || reflect.DeepEqual(p.Metadata, q.Metadata)
+}
+
+// connections returns the IIPs or the other connections of a graph
+func connections(graph *BaseFbp, iips bool) []*Connection {
+	var found []*Connection
+	for _, c := range graph.Connections {
+		if c.Target != nil && (c.Source == nil) == iips {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// diffConnections pairs the connections with the same endpoints in order,
+// the IIPs with the same target
+func diffConnections(a, b []*Connection, iips bool) []*ConnectionChange {
+	key := func(c *Connection) string {
+		if iips {
+			return endpointKey(c.Target)
+		}
+		return endpointKey(c.Source) + " -> " + endpointKey(c.Target)
+	}
+	unpaired := make(map[string][]*Connection)
+	for _, c := range b {
+		unpaired[key(c)] = append(unpaired[key(c)], c)
+	}
+	paired := make(map[*Connection]bool)
+	var changes []*ConnectionChange
+	for _, c := range a {
+		k := key(c)
+		if len(unpaired[k]) == 0 {
+			changes = append(changes, &ConnectionChange{Old: c})
+			continue
+		}
+		other := unpaired[k][0]
+		unpaired[k] = unpaired[k][1:]
+		paired[other] = true
+		if c.Data != other.Data {
+			changes = append(changes, &ConnectionChange{Old: c, New: other})
+		}
+	}
+	for _, c := range b {
+		if !paired[c] {
+			changes = append(changes, &ConnectionChange{New: c})
+		}
+	}
+	return changes
+}
+
+func diffPorts(a, b map[string]*Endpoint) []*PortChange {
+	names := sortedPorts(a)
+	for _, name := range sortedPorts(b) {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var changes []*PortChange
+	for _, name := range names {
+		from, to := a[name], b[name]
+		if from != nil && to != nil && endpointKey(from) == endpointKey(to) {
+			continue
+		}
+		changes = append(changes, &PortChange{Name: name, Old: from, New: to})
+	}
+	return changes
+}
+
+func endpointKey(e *Endpoint) string {
+	return e.Process + "." + formatPort(e)
+}
+
+func formatProcess(p *Process) string {
+	return p.Name + "(" + p.Component + formatMetadata(p.Metadata) + ")"
+}
+
+// formatConnection returns a connection as a .fbp line would have it
+func formatConnection(c *Connection) string {
+	target := formatPort(c.Target) + " " + c.Target.Process
+	if c.Source == nil {
+		return formatIIP(c.Data) + " -> " + target
+	}
+	return c.Source.Process + " " + formatPort(c.Source) + " -> " + target
+}
+
+// formatIIP quotes data as .fbp does, or as Go does if it can't be written
+func formatIIP(data string) string {
+	if quoted, err := quoteIIP(data); err == nil {
+		return quoted
+	}
+	return fmt.Sprintf("%q", data)
+}
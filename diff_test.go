@@ -0,0 +1,56 @@
+package fbp
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := &parseGraph(t, `INPORT=Read.SOURCE:FILENAME
+OUTPORT=Display.OUT:OUT
+Read(core/read) OUT -> IN Split(strings/split:main), Split OUT -> IN Count(core/count)
+'\n' -> DELIMITER Split
+'x' -> OPTIONS Count
+Count OUT -> IN Display(core/output)
+Count ERROR[0] -> IN Log(core/log)
+`).BaseFbp
+	b := &parseGraph(t, `INPORT=Reader.SOURCE:FILENAME
+INPORT=Split.DELIMITER:DELIMITER
+Reader(core/read) OUT -> IN Split(strings/split:main=1), Split OUT -> IN Count(core/count)
+',' -> DELIMITER Split
+Count OUT -> IN Display(core/output)
+Count ERROR[1] -> IN Display
+'y' -> FORMAT Display
+`).BaseFbp
+	d := Diff(a, b)
+	expected := `- process Read(core/read)
+~ process Split(strings/split:main=) -> Split(strings/split:main=1)
+- process Log(core/log)
++ process Reader(core/read)
+- connection Read OUT -> IN Split
+- connection Count ERROR[0] -> IN Log
++ connection Reader OUT -> IN Split
++ connection Count ERROR[1] -> IN Display
+~ iip -> DELIMITER Split: '\n' -> ','
+- iip 'x' -> OPTIONS Count
++ iip 'y' -> FORMAT Display
++ inport DELIMITER: Split.DELIMITER
+~ inport FILENAME: Read.SOURCE -> Reader.SOURCE
+- outport OUT: Display.OUT
+`
+	if d.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, d)
+	}
+	if d.Empty() {
+		t.Error("Expected changes")
+	}
+	if c := d.IIPs[0]; c.Old.Data != "\\n" || c.New.Data != "," {
+		t.Errorf("Unexpected IIP change %v -> %v", c.Old, c.New)
+	}
+	if c := d.Processes[1]; c.Name != "Split" || c.New.Metadata["main"] != "1" {
+		t.Errorf("Unexpected process change %+v", c)
+	}
+
+	if d := Diff(a, a); !d.Empty() || d.String() != "" {
+		t.Errorf("Expected no changes, got %q", d)
+	}
+}
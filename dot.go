@@ -0,0 +1,227 @@
+package fbp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Process metadata keys copied to the DOT node attributes
+var dotAttributes = map[string]string{
+	"color":     "color",
+	"colour":    "color",
+	"fillcolor": "fillcolor",
+	"fontcolor": "fontcolor",
+	"fontname":  "fontname",
+	"fontsize":  "fontsize",
+	"penwidth":  "penwidth",
+	"style":     "style",
+	"tooltip":   "tooltip",
+	"url":       "URL",
+}
+
+// MarshalDot returns the graph in Graphviz DOT format
+func (self *BaseFbp) MarshalDot() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := self.WriteDot(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteDot writes the graph to w in Graphviz DOT format. Every process is
+// a record node with its in-ports on the left and out-ports on the right,
+// connections go from port to port with array indexes as edge labels. IIPs
+// and exported ports get nodes of their own.
+func (self *BaseFbp) WriteDot(w io.Writer) error {
+	var buf bytes.Buffer
+	name := self.Properties["name"]
+	if name == "" {
+		name = self.Subgraph
+	}
+	if name == "" {
+		name = "fbp"
+	}
+	fmt.Fprintf(&buf, "digraph %s {\n", dotQuote(name))
+	buf.WriteString("\trankdir=LR;\n")
+	buf.WriteString("\tnode [shape=record];\n")
+
+	// collect the used ports of every process in order of appearance
+	var names []string
+	processes := make(map[string]*Process)
+	inPorts := make(map[string][]string)
+	outPorts := make(map[string][]string)
+	addProcess := func(name string) {
+		if _, ok := inPorts[name]; !ok {
+			names = append(names, name)
+			inPorts[name] = []string{}
+			outPorts[name] = []string{}
+		}
+	}
+	addPort := func(ports map[string][]string, e *Endpoint) {
+		addProcess(e.Process)
+		for _, port := range ports[e.Process] {
+			if port == e.Port {
+				return
+			}
+		}
+		ports[e.Process] = append(ports[e.Process], e.Port)
+	}
+	for _, p := range self.Processes {
+		processes[p.Name] = p
+		addProcess(p.Name)
+	}
+	for _, c := range self.Connections {
+		if c.Source != nil {
+			addPort(outPorts, c.Source)
+		}
+		addPort(inPorts, c.Target)
+	}
+	inports, outports := sortedPorts(self.Inports), sortedPorts(self.Outports)
+	for _, port := range inports {
+		addPort(inPorts, self.Inports[port])
+	}
+	for _, port := range outports {
+		addPort(outPorts, self.Outports[port])
+	}
+
+	for _, name := range names {
+		fields := []string{}
+		if ports := inPorts[name]; len(ports) > 0 {
+			fields = append(fields, "{"+dotPortFields("in", ports)+"}")
+		}
+		title := dotEscapeRecord(name)
+		attributes := []string{}
+		if p, ok := processes[name]; ok {
+			title += `\n(` + dotEscapeRecord(p.Component) + ")"
+			attributes = dotMetadata(p.Metadata)
+		}
+		fields = append(fields, title)
+		if ports := outPorts[name]; len(ports) > 0 {
+			fields = append(fields, "{"+dotPortFields("out", ports)+"}")
+		}
+		attributes = append([]string{"label=" + dotRecordLabel("{"+strings.Join(fields, "|")+"}")}, attributes...)
+		fmt.Fprintf(&buf, "\t%s [%s];\n", dotQuote(name), strings.Join(attributes, ", "))
+	}
+
+	for i, c := range self.Connections {
+		target := dotQuote(c.Target.Process) + ":" + dotQuote("in_"+c.Target.Port)
+		if c.Source == nil {
+			iip := dotQuote(fmt.Sprintf("iip:%d", i))
+			fmt.Fprintf(&buf, "\t%s [shape=note, style=dashed, label=%s];\n", iip, dotQuote("'"+c.Data+"'"))
+			fmt.Fprintf(&buf, "\t%s -> %s%s;\n", iip, target, dotEdgeLabels(nil, c.Target))
+			continue
+		}
+		source := dotQuote(c.Source.Process) + ":" + dotQuote("out_"+c.Source.Port)
+		fmt.Fprintf(&buf, "\t%s -> %s%s;\n", source, target, dotEdgeLabels(c.Source, c.Target))
+	}
+
+	if len(inports) > 0 {
+		buf.WriteString("\tsubgraph inports {\n\t\trank=source;\n")
+		for _, port := range inports {
+			fmt.Fprintf(&buf, "\t\t%s [shape=cds, label=%s];\n", dotQuote("INPORT:"+port), dotQuote(port))
+		}
+		buf.WriteString("\t}\n")
+		for _, port := range inports {
+			e := self.Inports[port]
+			fmt.Fprintf(&buf, "\t%s -> %s:%s%s;\n", dotQuote("INPORT:"+port),
+				dotQuote(e.Process), dotQuote("in_"+e.Port), dotEdgeLabels(nil, e))
+		}
+	}
+	if len(outports) > 0 {
+		buf.WriteString("\tsubgraph outports {\n\t\trank=sink;\n")
+		for _, port := range outports {
+			fmt.Fprintf(&buf, "\t\t%s [shape=cds, label=%s];\n", dotQuote("OUTPORT:"+port), dotQuote(port))
+		}
+		buf.WriteString("\t}\n")
+		for _, port := range outports {
+			e := self.Outports[port]
+			fmt.Fprintf(&buf, "\t%s:%s -> %s%s;\n", dotQuote(e.Process), dotQuote("out_"+e.Port),
+				dotQuote("OUTPORT:"+port), dotEdgeLabels(e, nil))
+		}
+	}
+
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func sortedPorts(ports map[string]*Endpoint) []string {
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func dotPortFields(prefix string, ports []string) string {
+	fields := make([]string, len(ports))
+	for i, port := range ports {
+		fields[i] = "<" + dotEscapeRecord(prefix+"_"+port) + "> " + dotEscapeRecord(port)
+	}
+	return strings.Join(fields, "|")
+}
+
+func dotEdgeLabels(source, target *Endpoint) string {
+	var labels []string
+	if source != nil && source.Index != nil {
+		labels = append(labels, fmt.Sprintf("taillabel=\"[%d]\"", *source.Index))
+	}
+	if target != nil && target.Index != nil {
+		labels = append(labels, fmt.Sprintf("headlabel=\"[%d]\"", *target.Index))
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(labels, ", ") + "]"
+}
+
+func dotMetadata(metadata map[string]string) []string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		if _, ok := dotAttributes[strings.ToLower(k)]; ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var attributes []string
+	style, filled := false, false
+	for _, k := range keys {
+		attribute := dotAttributes[strings.ToLower(k)]
+		style = style || attribute == "style"
+		filled = filled || attribute == "fillcolor"
+		attributes = append(attributes, attribute+"="+dotQuote(metadata[k]))
+	}
+	if filled && !style {
+		attributes = append(attributes, "style=filled")
+	}
+	return attributes
+}
+
+// dotQuote returns s as a double-quoted DOT identifier
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+// dotRecordLabel quotes a record label built with dotEscapeRecord
+func dotRecordLabel(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// dotEscapeRecord escapes the characters with a special meaning in record labels
+func dotEscapeRecord(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		if strings.ContainsRune(`{}|<> \`, r) {
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
@@ -0,0 +1,52 @@
+package fbp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarshalDot(t *testing.T) {
+	parser := parseGraph(t, graphExportedArrayPort+"\t'5s' -> OPTIONS Read\n")
+	parser.Processes[0].Metadata = map[string]string{"colour": "red", "fillcolor": "yellow", "x": "10"}
+	data, err := parser.MarshalDot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := string(data)
+	t.Log(dot)
+	for _, expected := range []string{
+		`digraph "fbp" {`,
+		`"Read" [label="{{<in_OPTIONS> OPTIONS|<in_IN> IN}|Read\n(ReadFile)|{<out_OUT> OUT}}", color="red", fillcolor="yellow", style=filled];`,
+		`"Process" [label="{{<in_IN> IN}|Process\n(Output)|{<out_OUT> OUT}}"];`,
+		`"Log" [label="{{<in_IN> IN}|Log\n(Console)}"];`,
+		`"Read":"out_OUT" -> "Process":"in_IN" [headlabel="[0]"];`,
+		`"Process":"out_OUT" -> "Log":"in_IN" [taillabel="[0]"];`,
+		`"iip:2" [shape=note, style=dashed, label="'5s'"];`,
+		`"iip:2" -> "Read":"in_OPTIONS";`,
+		`"INPORT:EXTRA" [shape=cds, label="EXTRA"];`,
+		`"INPORT:EXTRA" -> "Process":"in_IN" [headlabel="[0]"];`,
+		`"Process":"out_OUT" -> "OUTPORT:RESULT" [taillabel="[1]"];`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Fatalf("Missing %s", expected)
+		}
+	}
+}
+
+func TestDotEscaping(t *testing.T) {
+	g := &BaseFbp{
+		Processes:   []*Process{{Name: "A", Component: "my <comp>|x"}},
+		Connections: []*Connection{{Data: `say "hi"`, Target: &Endpoint{Process: "A", Port: "IN"}}},
+	}
+	data, err := g.MarshalDot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := string(data)
+	if !strings.Contains(dot, `label="{{<in_IN> IN}|A\n(my\ \<comp\>\|x)}"`) {
+		t.Fatalf("Record label is not escaped:\n%s", dot)
+	}
+	if !strings.Contains(dot, `label="'say \"hi\"'"`) {
+		t.Fatalf("IIP label is not escaped:\n%s", dot)
+	}
+}
@@ -0,0 +1,201 @@
+package fbp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Flatten returns the graph with the processes of composite components (as
+// LoadComponent finds them) replaced by the processes of their graphs, recursively.
+// The processes of a composite get its name as prefix, as Subgraph gives
+// them (Process_Inner), and the connections to and from its exported ports
+// go to the internal endpoints. A composite made of itself, directly or not,
+// is an error, so is a process of a composite named as another process of
+// the graph. The processes of a composite take its place, its internal
+// connections follow those of the graph. Metadata and properties are copies.
+func Flatten(graph *BaseFbp, loader Loader) (*BaseFbp, error) {
+	return flatten(graph, loader, nil)
+}
+
+// flatten flattens graph, a composite of the components of stack
+func flatten(graph *BaseFbp, loader Loader, stack []string) (*BaseFbp, error) {
+	flat := &BaseFbp{Subgraph: graph.Subgraph, Properties: copyMetadata(graph.Properties)}
+	// exported ports of the composite processes by process
+	inports := make(map[string]map[string]*Endpoint)
+	outports := make(map[string]map[string]*Endpoint)
+	var internal []*Connection
+	members := make(map[string][]string)
+	// processes of the flat graph, by the composite they come from ("" for
+	// those of the graph)
+	owners := make(map[string]string)
+	claim := func(name, composite string) error {
+		if owner, ok := owners[name]; ok {
+			if composite == "" {
+				composite = owner
+			}
+			return fmt.Errorf("fbp: composite %s makes process %s, already in the graph", composite, name)
+		}
+		owners[name] = composite
+		return nil
+	}
+	for name := range graph.named {
+		if !graph.processExists(name) {
+			owners[name] = ""
+		}
+	}
+
+	for _, p := range graph.Processes {
+		sub, _, err := LoadComponent(loader, p.Component)
+		if err != nil {
+			return nil, fmt.Errorf("fbp: component %s of %s: %v", p.Component, p.Name, err)
+		}
+		if sub == nil {
+			if err := claim(p.Name, ""); err != nil {
+				return nil, err
+			}
+			flat.Processes = append(flat.Processes, &Process{Name: p.Name, Component: p.Component, Metadata: copyMetadata(p.Metadata)})
+			continue
+		}
+		for i, component := range stack {
+			if component == p.Component {
+				cycle := append(append([]string(nil), stack[i:]...), p.Component)
+				return nil, fmt.Errorf("fbp: recursive composite %s", strings.Join(cycle, " -> "))
+			}
+		}
+		sub, err = flatten(sub, loader, append(stack, p.Component))
+		if err != nil {
+			return nil, err
+		}
+
+		prefixed := &BaseFbp{Subgraph: p.Name}
+		for _, q := range sub.Processes {
+			name := prefixed.createProcessName(q.Name)
+			if err := claim(name, p.Name); err != nil {
+				return nil, err
+			}
+			flat.Processes = append(flat.Processes, &Process{Name: name, Component: q.Component, Metadata: copyMetadata(q.Metadata)})
+			members[p.Name] = append(members[p.Name], name)
+		}
+		for name := range sub.named {
+			if !sub.processExists(name) {
+				if err := claim(prefixed.createProcessName(name), p.Name); err != nil {
+					return nil, err
+				}
+			}
+			flat.name(prefixed.createProcessName(name))
+		}
+		for _, c := range sub.Connections {
+			internal = append(internal, &Connection{
+				Data:     c.Data,
+				Source:   prefixed.prefixEndpoint(c.Source),
+				Target:   prefixed.prefixEndpoint(c.Target),
+				Metadata: copyMetadata(c.Metadata),
+			})
+		}
+		inports[p.Name] = make(map[string]*Endpoint)
+		for name, e := range sub.Inports {
+			inports[p.Name][strings.ToUpper(name)] = prefixed.prefixEndpoint(e)
+		}
+		outports[p.Name] = make(map[string]*Endpoint)
+		for name, e := range sub.Outports {
+			outports[p.Name][strings.ToUpper(name)] = prefixed.prefixEndpoint(e)
+		}
+	}
+
+	for _, c := range graph.Connections {
+		source, err := rewireEndpoint(c.Source, outports, "OUTPORT")
+		if err != nil {
+			return nil, err
+		}
+		target, err := rewireEndpoint(c.Target, inports, "INPORT")
+		if err != nil {
+			return nil, err
+		}
+		flat.Connections = append(flat.Connections, &Connection{
+			Data:     c.Data,
+			Source:   source,
+			Target:   target,
+			Metadata: copyMetadata(c.Metadata),
+		})
+	}
+	flat.Connections = append(flat.Connections, internal...)
+	for name := range graph.named {
+		flat.name(name)
+	}
+
+	var err error
+	if flat.Inports, err = rewireExports(graph.Inports, inports, "INPORT"); err != nil {
+		return nil, err
+	}
+	if flat.Outports, err = rewireExports(graph.Outports, outports, "OUTPORT"); err != nil {
+		return nil, err
+	}
+
+	for _, g := range graph.Groups {
+		group := &Group{Name: g.Name, Metadata: copyMetadata(g.Metadata)}
+		for _, node := range g.Nodes {
+			if names, ok := members[node]; ok {
+				group.Nodes = append(group.Nodes, names...)
+			} else {
+				group.Nodes = append(group.Nodes, node)
+			}
+		}
+		flat.Groups = append(flat.Groups, group)
+	}
+	return flat, nil
+}
+
+// rewireEndpoint returns the internal endpoint of an exported port of a
+// composite process in ports (by port name in upper case, as UnmarshalJSON
+// has them) or e if it isn't one. The index of e goes to the internal port,
+// it is an error if the port is exported with an index already.
+func rewireEndpoint(e *Endpoint, ports map[string]map[string]*Endpoint, kind string) (*Endpoint, error) {
+	if e == nil || ports[e.Process] == nil {
+		return e, nil
+	}
+	inner, ok := ports[e.Process][strings.ToUpper(e.Port)]
+	if !ok {
+		return nil, fmt.Errorf("fbp: composite %s has no %s %s", e.Process, kind, e.Port)
+	}
+	if e.Index != nil {
+		if inner.Index != nil {
+			return nil, fmt.Errorf("fbp: %s %s of composite %s is %s, it has no index %d", kind, e.Port, e.Process, endpointKey(inner), *e.Index)
+		}
+		inner = &Endpoint{Process: inner.Process, Port: inner.Port, Index: e.Index}
+	}
+	return inner, nil
+}
+
+func rewireExports(exports map[string]*Endpoint, ports map[string]map[string]*Endpoint, kind string) (map[string]*Endpoint, error) {
+	if exports == nil {
+		return nil, nil
+	}
+	rewired := make(map[string]*Endpoint, len(exports))
+	for name, e := range exports {
+		inner, err := rewireEndpoint(e, ports, kind)
+		if err != nil {
+			return nil, err
+		}
+		rewired[name] = inner
+	}
+	return rewired, nil
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// prefixEndpoint returns the endpoint with the process name of the subgraph
+func (self *BaseFbp) prefixEndpoint(e *Endpoint) *Endpoint {
+	if e == nil {
+		return nil
+	}
+	return &Endpoint{Process: self.createProcessName(e.Process), Port: e.Port, Index: e.Index}
+}
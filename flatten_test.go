@@ -0,0 +1,138 @@
+package fbp
+
+import (
+	"strings"
+	"testing"
+)
+
+var compositeSources = map[string]string{
+	// reads lines of a file
+	"app/lines.fbp": `INPORT=Read.IN:FILE
+OUTPORT=Split.OUT:LINES
+Read(fs/read) OUT -> IN Split(strings/split)
+'\n' -> DELIMITER Split
+`,
+	// counts the lines of a file
+	"app/count.fbp": `INPORT=Lines.FILE:FILE
+OUTPORT=Count.OUT:COUNT
+Lines(app/lines) LINES -> IN Count(core/count)
+`,
+	"app/loop.fbp":  "INPORT=Inner.IN:IN\n'x' -> IN Inner(app/loop2)\n",
+	"app/last.fbp":  "INPORT=Pick.IN[2]:ITEM\nOUTPORT=Pick.OUT:OUT\n'x' -> IN[0] Pick(core/pick)\n",
+	"app/loop2.fbp": "INPORT=Again.IN:IN\n'x' -> IN Again(app/loop)\n",
+	"app/meta.json": `{"inports": {"file": {"process": "Read", "port": "in"}},
+  "processes": {"Read": {"component": "fs/read", "metadata": {"label": "Read"}}, "Log": {"component": "core/log", "metadata": {"label": "Log"}}},
+  "connections": [{"src": {"process": "Read", "port": "out"}, "tgt": {"process": "Log", "port": "in"}, "metadata": {"route": 5}}]}`,
+}
+
+var composites = NewMapLoader(compositeSources)
+
+func TestFlatten(t *testing.T) {
+	graph := &parseGraph(t, `INPORT=Counter.FILE:FILE
+'a.txt' -> FILE Counter(app/count) COUNT -> IN Display(core/out)
+Counter COUNT -> IN[1] Log(core/log)
+`).BaseFbp
+	flat, err := Flatten(graph, composites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `INPORT=Counter_Lines_Read.IN:FILE
+'a.txt' -> IN Counter_Lines_Read(fs/read)
+Counter_Count(core/count) OUT -> IN Display(core/out)
+Counter_Count OUT -> IN[1] Log(core/log)
+Counter_Lines_Split(strings/split) OUT -> IN Counter_Count
+Counter_Lines_Read OUT -> IN Counter_Lines_Split
+'\n' -> DELIMITER Counter_Lines_Split
+`
+	data, err := flat.MarshalFbp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, data)
+	}
+
+	// the port of a graph read from case sensitive JSON, with an index
+	index := 1
+	graph = &BaseFbp{
+		Processes:   []*Process{{Name: "Lines", Component: "app/lines"}},
+		Connections: []*Connection{{Data: "b.txt", Target: &Endpoint{Process: "Lines", Port: "file", Index: &index}}},
+	}
+	if flat, err = Flatten(graph, composites); err != nil {
+		t.Fatal(err)
+	}
+	if target := endpointKey(flat.Connections[0].Target); target != "Lines_Read.IN[1]" {
+		t.Errorf("Unexpected target %s", target)
+	}
+
+	// the same as parsing the composite as a subgraph
+	parser := &Fbp{Buffer: compositeSources["app/lines.fbp"], BaseFbp: BaseFbp{Subgraph: "Lines"}}
+	parser.Init()
+	parser.Parse()
+	parser.Execute()
+	lines, err := Flatten(&parseGraph(t, "'a.txt' -> FILE Lines(app/lines)\n").BaseFbp, composites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range parser.Processes {
+		if lines.Processes[i].Name != p.Name {
+			t.Errorf("Expected process %s, got %s", p.Name, lines.Processes[i].Name)
+		}
+	}
+
+	// the properties are a copy
+	graph.Properties = map[string]string{"name": "counter"}
+	if flat, err = Flatten(graph, composites); err != nil {
+		t.Fatal(err)
+	}
+	flat.Properties["name"] = "flat"
+	if graph.Properties["name"] != "counter" {
+		t.Errorf("Unexpected properties %v", graph.Properties)
+	}
+
+	// so is the metadata, the graphs of the loader are shared
+	if flat, err = Flatten(&parseGraph(t, "'a.txt' -> FILE Meta(app/meta)\n").BaseFbp, composites); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range flat.Processes {
+		p.Metadata["label"] = "changed"
+	}
+	for _, c := range flat.Connections {
+		if c.Metadata != nil {
+			c.Metadata["route"] = "changed"
+		}
+	}
+	meta, _, err := LoadComponent(composites, "app/meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Processes[0].Metadata["label"] != "Read" || meta.Connections[0].Metadata["route"] != "5" {
+		t.Errorf("Metadata of the loader changed: %v %v", meta.Processes[0].Metadata, meta.Connections[0].Metadata)
+	}
+}
+
+func TestFlattenErrors(t *testing.T) {
+	for _, test := range []struct {
+		src, expected string
+	}{
+		{"'x' -> IN Loop(app/loop)\n", "fbp: recursive composite app/loop -> app/loop2 -> app/loop"},
+		{"'x' -> IN Again(app/loop2)\n", "fbp: recursive composite app/loop2 -> app/loop -> app/loop2"},
+		{"'x' -> PATH Lines(app/lines)\n", "fbp: composite Lines has no INPORT PATH"},
+		{"Lines(app/lines) OUT -> IN Display(core/out)\n", "fbp: composite Lines has no OUTPORT OUT"},
+		{"'x' -> ITEM[1] Last(app/last)\n", "fbp: INPORT ITEM of composite Last is Last_Pick.IN[2], it has no index 1"},
+		{"Lines_Read(core/read) OUT -> FILE Lines(app/lines)\n", "fbp: composite Lines makes process Lines_Read, already in the graph"},
+		{"'x' -> FILE Lines(app/lines) LINES -> IN Lines_Split(core/split)\n", "fbp: composite Lines makes process Lines_Split, already in the graph"},
+		{"'x' -> FILE Lines(app/lines) LINES -> IN Lines_Split\n", "fbp: composite Lines makes process Lines_Split, already in the graph"},
+	} {
+		_, err := Flatten(&parseGraph(t, test.src).BaseFbp, composites)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected %q, got %v", test.expected, err)
+		}
+	}
+
+	broken := NewMapLoader(map[string]string{"app/broken.fbp": "A OUT ->\n"})
+	_, err := Flatten(&parseGraph(t, "'x' -> IN B(app/broken)\n").BaseFbp, broken)
+	if err == nil || !strings.HasPrefix(err.Error(), "fbp: component app/broken of B:") {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
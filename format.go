@@ -0,0 +1,312 @@
+package fbp
+
+import (
+	"bytes"
+	"strings"
+)
+
+// FormatLineWidth is the width after which Format wraps a chain of connections
+const FormatLineWidth = 80
+
+// Format returns src in the canonical .fbp layout:
+//  - the leading comment block, then INPORT, OUTPORT and EXPORT lines,
+//  - one space around "->" and between ports and processes,
+//  - a component is declared at the first occurrence of its process only,
+//  - chains longer than FormatLineWidth are split at the middle processes,
+//  - at most one blank line between blocks and a newline at the end.
+// Comments stay attached to the line they are on or directly above.
+func Format(src []byte) ([]byte, error) {
+	parser := &Fbp{Buffer: string(src)}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		return nil, err
+	}
+	f := &formatter{
+		buffer:   parser.Buffer,
+		decls:    make(map[string]string),
+		declared: make(map[string]bool),
+	}
+	f.collect(parser.syntaxTree())
+	return f.format(), nil
+}
+
+// IsFormatted reports whether src is already in the layout produced by Format
+func IsFormatted(src []byte) (bool, error) {
+	formatted, err := Format(src)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(src, formatted), nil
+}
+
+const (
+	fmtBlank = iota
+	fmtComment
+	fmtInport
+	fmtOutport
+	fmtExport
+	fmtConnection
+)
+
+type fmtBridge struct {
+	rule    pegRule // ruleiip, ruleleftlet, rulerightlet or rulebridge for a middle process
+	iip     string
+	inPort  string
+	node    string
+	outPort string
+}
+
+type fmtLine struct {
+	kind    int
+	text    string
+	chain   []fmtBridge
+	comment string
+	leading []string
+}
+
+type formatter struct {
+	buffer   string
+	lines    []*fmtLine
+	decls    map[string]string // process name -> "(component:meta)"
+	declared map[string]bool
+}
+
+// collect turns the syntax tree into the list of lines and remembers the
+// component of each process the same way createNode() does: the first
+// non-empty declaration wins.
+func (f *formatter) collect(root *syntaxNode) {
+	skipLF := false
+	for _, n := range root.children {
+		if n.rule != ruleline {
+			continue
+		}
+		text := n.text(f.buffer)
+		if skipLF && text == "\n" {
+			// second half of a CRLF line break
+			skipLF = false
+			continue
+		}
+		skipLF = strings.HasSuffix(text, "\r")
+
+		line := &fmtLine{}
+		if t := n.child(ruleLineTerminator); t != nil {
+			if c := t.child(rulecomment); c != nil {
+				line.comment = strings.TrimSpace(c.text(f.buffer))
+			}
+		}
+		switch {
+		case n.child(ruleconnection) != nil:
+			line.kind = fmtConnection
+			line.chain = f.chain(n.child(ruleconnection))
+		case n.child(rulecomment) != nil:
+			line.kind = fmtComment
+			line.text = strings.TrimSpace(n.child(rulecomment).text(f.buffer))
+		default:
+			directive := strings.ToUpper(strings.TrimSpace(text))
+			switch {
+			case strings.HasPrefix(directive, "INPORT="):
+				line.kind = fmtInport
+				line.text = "INPORT=" + n.captures(f.buffer)[0]
+			case strings.HasPrefix(directive, "OUTPORT="):
+				line.kind = fmtOutport
+				line.text = "OUTPORT=" + n.captures(f.buffer)[0]
+			case strings.HasPrefix(directive, "EXPORT="):
+				line.kind = fmtExport
+				end := len(text)
+				if t := n.child(ruleLineTerminator); t != nil {
+					end = t.begin - n.begin
+				}
+				line.text = "EXPORT=" + strings.TrimSpace(text[strings.Index(text, "=")+1:end])
+			default:
+				line.kind = fmtBlank
+			}
+		}
+		f.lines = append(f.lines, line)
+	}
+}
+
+func (f *formatter) chain(connection *syntaxNode) []fmtBridge {
+	var chain []fmtBridge
+	for c := connection; c != nil; c = c.child(ruleconnection) {
+		b := c.child(rulebridge)
+		if b == nil {
+			break
+		}
+		var fb fmtBridge
+		switch {
+		case b.child(ruleiip) != nil:
+			fb.rule = ruleiip
+			fb.iip = b.child(ruleiip).text(f.buffer)
+		case b.child(ruleleftlet) != nil:
+			fb.rule = ruleleftlet
+			fb.node = f.node(b.child(ruleleftlet).child(rulenode))
+			fb.outPort = f.port(b.child(ruleleftlet))
+		case b.child(rulerightlet) != nil:
+			fb.rule = rulerightlet
+			fb.inPort = f.port(b.child(rulerightlet))
+			fb.node = f.node(b.child(rulerightlet).child(rulenode))
+		default:
+			fb.rule = rulebridge
+			var ports []string
+			for _, p := range b.children {
+				if p.rule == ruleport {
+					ports = append(ports, p.captures(f.buffer)[0])
+				}
+			}
+			fb.inPort, fb.outPort = ports[0], ports[1]
+			fb.node = f.node(b.child(rulenode))
+		}
+		chain = append(chain, fb)
+	}
+	return chain
+}
+
+func (f *formatter) node(n *syntaxNode) string {
+	name := n.captures(f.buffer)[0]
+	if c := n.child(rulecomponent); c != nil {
+		component := c.captures(f.buffer)
+		if _, ok := f.decls[name]; !ok && len(component) > 0 && component[0] != "" {
+			decl := "(" + component[0]
+			if meta := c.child(rulecompMeta); meta != nil {
+				decl += ":" + meta.captures(f.buffer)[0]
+			}
+			f.decls[name] = decl + ")"
+		}
+	}
+	return name
+}
+
+func (f *formatter) port(n *syntaxNode) string {
+	if p := n.child(ruleportWithIndex); p != nil {
+		captures := p.captures(f.buffer)
+		return captures[0] + "[" + captures[1] + "]"
+	}
+	return n.child(ruleport).captures(f.buffer)[0]
+}
+
+func (f *formatter) format() []byte {
+	var header, exports, body []*fmtLine
+
+	// comments are attached to the line right below them, comment blocks
+	// followed by a blank line stay where they are
+	var pending []string
+	started := false
+	for _, line := range f.lines {
+		switch line.kind {
+		case fmtComment:
+			pending = append(pending, line.text)
+			continue
+		case fmtBlank:
+			lines := []*fmtLine{line}
+			if len(pending) > 0 {
+				lines = []*fmtLine{{kind: fmtComment, leading: pending}, line}
+				pending = nil
+			}
+			if started {
+				body = append(body, lines...)
+			} else {
+				header = append(header, lines...)
+			}
+			continue
+		}
+		started = true
+		line.leading, pending = pending, nil
+		if line.kind == fmtConnection {
+			body = append(body, line)
+		} else {
+			exports = append(exports, line)
+		}
+	}
+	if len(pending) > 0 {
+		body = append(body, &fmtLine{kind: fmtComment, leading: pending})
+	}
+
+	var ordered []*fmtLine
+	for _, kind := range []int{fmtInport, fmtOutport, fmtExport} {
+		for _, line := range exports {
+			if line.kind == kind {
+				ordered = append(ordered, line)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, section := range [][]*fmtLine{header, ordered, body} {
+		first, blank := true, false
+		for _, line := range section {
+			if line.kind == fmtBlank {
+				blank = true
+				continue
+			}
+			if (first && buf.Len() > 0) || (!first && blank) {
+				buf.WriteString("\n")
+			}
+			first, blank = false, false
+			f.writeLine(&buf, line)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (f *formatter) writeLine(buf *bytes.Buffer, line *fmtLine) {
+	for _, c := range line.leading {
+		buf.WriteString(c + "\n")
+	}
+	var texts []string
+	switch line.kind {
+	case fmtComment:
+		return
+	case fmtConnection:
+		texts = f.wrap(line.chain)
+	default:
+		texts = []string{line.text}
+	}
+	for i, text := range texts {
+		buf.WriteString(text)
+		if i == len(texts)-1 && line.comment != "" {
+			buf.WriteString(" " + line.comment)
+		}
+		buf.WriteString("\n")
+	}
+}
+
+// wrap renders a chain of bridges, splitting it at middle processes when
+// it gets longer than FormatLineWidth
+func (f *formatter) wrap(chain []fmtBridge) []string {
+	var lines []string
+	line := ""
+	for i, b := range chain {
+		var piece string
+		switch b.rule {
+		case ruleiip:
+			piece = b.iip
+		case ruleleftlet:
+			piece = f.declare(b.node) + " " + b.outPort
+		case rulerightlet:
+			piece = b.inPort + " " + f.declare(b.node)
+		default:
+			piece = b.inPort + " " + f.declare(b.node) + " " + b.outPort
+		}
+		if i > 0 && len(line)+len(" -> ")+len(piece) > FormatLineWidth && chain[i-1].rule == rulebridge {
+			prev := chain[i-1]
+			lines = append(lines, strings.TrimSuffix(line, " "+prev.outPort))
+			line = prev.node + " " + prev.outPort
+		}
+		if line != "" {
+			line += " -> "
+		}
+		line += piece
+	}
+	return append(lines, line)
+}
+
+// declare returns the process name with its component if it has not been
+// declared in the output yet
+func (f *formatter) declare(name string) string {
+	decl, ok := f.decls[name]
+	if !ok || f.declared[name] {
+		return name
+	}
+	f.declared[name] = true
+	return name + decl
+}
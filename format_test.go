@@ -0,0 +1,86 @@
+package fbp
+
+import (
+	"testing"
+)
+
+const (
+	graphUnformatted = `# Ticker example
+
+
+'5s'->INTERVAL   Ticker(core/ticker)   OUT ->IN Forward(core/passthru) # every 5s
+
+# log everything
+Forward OUT -> IN Log(core/console),
+OUTPORT=Log.OUT:RESULT
+inport=Ticker.INTERVAL:INTERVAL # exported
+
+
+
+Log() ERROR ->   IN Forward
+# the end
+`
+	graphFormatted = `# Ticker example
+
+INPORT=Ticker.INTERVAL:INTERVAL # exported
+OUTPORT=Log.OUT:RESULT
+
+'5s' -> INTERVAL Ticker(core/ticker) OUT -> IN Forward(core/passthru) # every 5s
+
+# log everything
+Forward OUT -> IN Log(core/console)
+
+Log ERROR -> IN Forward
+# the end
+`
+	graphLongChain = `'some quite long initial information packet' -> IN First(core/passthru) OUT -> IN Second(core/passthru) OUT -> IN Third(core/console) # long
+`
+	graphLongChainFormatted = `'some quite long initial information packet' -> IN First(core/passthru)
+First OUT -> IN Second(core/passthru) OUT -> IN Third(core/console) # long
+`
+)
+
+func TestFormat(t *testing.T) {
+	for src, expected := range map[string]string{
+		graphUnformatted: graphFormatted,
+		graphLongChain:   graphLongChainFormatted,
+	} {
+		formatted, err := Format([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(formatted) != expected {
+			t.Fatalf("Unexpected format:\n%s\nexpected:\n%s", formatted, expected)
+		}
+		if ok, err := IsFormatted(formatted); err != nil || !ok {
+			t.Fatalf("Format is not idempotent:\n%s", formatted)
+		}
+		if ok, _ := IsFormatted([]byte(src)); ok {
+			t.Fatal("Source should not be reported as formatted")
+		}
+		a, b := parseGraph(t, src), parseGraph(t, string(formatted))
+		if !equivalentGraphs(&a.BaseFbp, &b.BaseFbp) {
+			t.Fatalf("Graph changed after formatting:\n%s", formatted)
+		}
+	}
+}
+
+func TestFormatTestGraphs(t *testing.T) {
+	for _, graph := range []string{graphIIP, graphTickLogger, graphOneLiner, graphDemo, graphExportedInPort, graphArrayPorts, graphExportedArrayPort} {
+		formatted, err := Format([]byte(graph))
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := Format(formatted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(formatted) {
+			t.Fatalf("Format is not idempotent:\n%s\n%s", formatted, again)
+		}
+		a, b := parseGraph(t, graph), parseGraph(t, string(formatted))
+		if !equivalentGraphs(&a.BaseFbp, &b.BaseFbp) {
+			t.Fatalf("Graph changed after formatting:\n%s", formatted)
+		}
+	}
+}
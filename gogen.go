@@ -0,0 +1,219 @@
+package fbp
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+//
+// Options of the Go code generated by WriteGo
+//
+type GoOptions struct {
+	// Package name of the generated file
+	Package string
+	// Name of the graph, the generated code declares <Name>Graph variable
+	// and Build<Name> function. Derived from Source when empty.
+	Name string
+	// Source file of the graph, mentioned in the generated comments
+	Source string
+}
+
+// MarshalGo returns Go source of the graph (see WriteGo)
+func (self *BaseFbp) MarshalGo(options *GoOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := self.WriteGo(&buf, options); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteGo writes Go source that declares the graph as a BaseFbp literal and
+// a function that wires the network with a Builder. The function makes the
+// same calls as Build() does, so the graph doesn't have to be parsed (or
+// even loaded) at run time.
+func (self *BaseFbp) WriteGo(w io.Writer, options *GoOptions) error {
+	pkg := options.Package
+	if pkg == "" {
+		pkg = "main"
+	}
+	name := options.Name
+	if name == "" && options.Source != "" {
+		base := filepath.Base(options.Source)
+		name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	name = goIdentifier(name)
+	source := "graph " + name
+	if options.Source != "" {
+		source = filepath.Base(options.Source)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by fbpgen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+	buf.WriteString("import \"github.com/oleksandr/fbp\"\n\n")
+
+	fmt.Fprintf(&buf, "// %sGraph is the graph of %s\n", name, source)
+	named := make([]string, 0, len(self.named))
+	for process := range self.named {
+		if !self.processExists(process) {
+			named = append(named, fmt.Sprintf("%q", process))
+		}
+	}
+	sort.Strings(named)
+	if len(named) > 0 {
+		fmt.Fprintf(&buf, "var %sGraph = fbp.NameProcesses(&fbp.BaseFbp{\n", name)
+	} else {
+		fmt.Fprintf(&buf, "var %sGraph = &fbp.BaseFbp{\n", name)
+	}
+	if self.Subgraph != "" {
+		fmt.Fprintf(&buf, "Subgraph: %q,\n", self.Subgraph)
+	}
+	buf.WriteString("Processes: []*fbp.Process{\n")
+	for _, p := range self.Processes {
+		fmt.Fprintf(&buf, "{Name: %q, Component: %q", p.Name, p.Component)
+		if len(p.Metadata) > 0 {
+			fmt.Fprintf(&buf, ", Metadata: %s", goStringMap(p.Metadata))
+		}
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("},\n")
+	buf.WriteString("Connections: []*fbp.Connection{\n")
+	for i, c := range self.Connections {
+		if c.Target == nil {
+			return fmt.Errorf("fbp: connection #%d has no target", i)
+		}
+		buf.WriteString("{")
+		if c.Source != nil {
+			fmt.Fprintf(&buf, "Source: %s, ", goEndpoint(c.Source))
+		} else {
+			fmt.Fprintf(&buf, "Data: %q, ", c.Data)
+		}
+		fmt.Fprintf(&buf, "Target: %s", goEndpoint(c.Target))
+		if len(c.Metadata) > 0 {
+			fmt.Fprintf(&buf, ", Metadata: %s", goStringMap(c.Metadata))
+		}
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("},\n")
+	for _, exports := range []struct {
+		field string
+		ports map[string]*Endpoint
+	}{{"Inports", self.Inports}, {"Outports", self.Outports}} {
+		if len(exports.ports) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s: map[string]*fbp.Endpoint{\n", exports.field)
+		for _, port := range sortedPorts(exports.ports) {
+			fmt.Fprintf(&buf, "%q: %s,\n", port, goEndpoint(exports.ports[port]))
+		}
+		buf.WriteString("},\n")
+	}
+	if len(self.Properties) > 0 {
+		fmt.Fprintf(&buf, "Properties: %s,\n", goStringMap(self.Properties))
+	}
+	if len(self.Groups) > 0 {
+		buf.WriteString("Groups: []*fbp.Group{\n")
+		for _, g := range self.Groups {
+			fmt.Fprintf(&buf, "{Name: %q, Nodes: %#v", g.Name, g.Nodes)
+			if len(g.Metadata) > 0 {
+				fmt.Fprintf(&buf, ", Metadata: %s", goStringMap(g.Metadata))
+			}
+			buf.WriteString("},\n")
+		}
+		buf.WriteString("},\n")
+	}
+	if len(named) > 0 {
+		fmt.Fprintf(&buf, "}, %s)\n\n", strings.Join(named, ", "))
+	} else {
+		buf.WriteString("}\n\n")
+	}
+
+	// Build<Name> is Build() unrolled
+	fmt.Fprintf(&buf, "// Build%s creates the network of %s with b\n", name, source)
+	fmt.Fprintf(&buf, "func Build%s(b fbp.Builder) error {\n", name)
+	check := "); err != nil {\nreturn err\n}\n"
+	for _, p := range self.Processes {
+		metadata := "nil"
+		if len(p.Metadata) > 0 {
+			metadata = goStringMap(p.Metadata)
+		}
+		fmt.Fprintf(&buf, "if err := b.AddProcess(%q, %q, %s"+check, p.Name, p.Component, metadata)
+	}
+	for _, c := range self.Connections {
+		if c.Source != nil {
+			metadata := "nil"
+			if len(c.Metadata) > 0 {
+				metadata = goStringMap(c.Metadata)
+			}
+			fmt.Fprintf(&buf, "if err := b.Connect(%s, %s, %s"+check, goEndpoint(c.Source), goEndpoint(c.Target), metadata)
+		}
+	}
+	for _, c := range self.Connections {
+		if c.Source == nil {
+			fmt.Fprintf(&buf, "if err := b.AddInitial(%q, %s"+check, c.Data, goEndpoint(c.Target))
+		}
+	}
+	for _, port := range sortedPorts(self.Inports) {
+		fmt.Fprintf(&buf, "if err := b.ExportInport(%q, %s"+check, port, goEndpoint(self.Inports[port]))
+	}
+	for _, port := range sortedPorts(self.Outports) {
+		fmt.Fprintf(&buf, "if err := b.ExportOutport(%q, %s"+check, port, goEndpoint(self.Outports[port]))
+	}
+	buf.WriteString("return nil\n}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func goEndpoint(e *Endpoint) string {
+	if e.Index != nil {
+		return fmt.Sprintf("&fbp.Endpoint{Process: %q, Port: %q, Index: fbp.Index(%d)}", e.Process, e.Port, *e.Index)
+	}
+	return fmt.Sprintf("&fbp.Endpoint{Process: %q, Port: %q}", e.Process, e.Port)
+}
+
+func goStringMap(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%q: %q", k, m[k])
+	}
+	return "map[string]string{" + strings.Join(pairs, ", ") + "}"
+}
+
+// goIdentifier turns a file or graph name into an exported Go identifier,
+// e.g. "ticker-logger" into "TickerLogger"
+func goIdentifier(name string) string {
+	var buf bytes.Buffer
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		buf.WriteRune(r)
+	}
+	id := buf.String()
+	if id == "" || !unicode.IsLetter([]rune(id)[0]) {
+		id = "Graph" + id
+	}
+	return id
+}
@@ -0,0 +1,119 @@
+package fbp
+
+import (
+	"fmt"
+	goparser "go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//
+// Builder that records the calls
+//
+type recordingBuilder struct {
+	calls []string
+}
+
+func (b *recordingBuilder) AddProcess(name, component string, metadata map[string]string) error {
+	b.calls = append(b.calls, fmt.Sprintf("AddProcess %s %s %v", name, component, metadata))
+	return nil
+}
+
+func (b *recordingBuilder) Connect(src, tgt *Endpoint, metadata map[string]string) error {
+	b.calls = append(b.calls, fmt.Sprintf("Connect %s %s %v", src, tgt, metadata))
+	return nil
+}
+
+func (b *recordingBuilder) AddInitial(data string, tgt *Endpoint) error {
+	b.calls = append(b.calls, fmt.Sprintf("AddInitial %q %s", data, tgt))
+	return nil
+}
+
+func (b *recordingBuilder) ExportInport(name string, e *Endpoint) error {
+	b.calls = append(b.calls, fmt.Sprintf("ExportInport %s %s", name, e))
+	return nil
+}
+
+func (b *recordingBuilder) ExportOutport(name string, e *Endpoint) error {
+	b.calls = append(b.calls, fmt.Sprintf("ExportOutport %s %s", name, e))
+	return nil
+}
+
+func TestBuild(t *testing.T) {
+	parser := parseGraph(t, graphExportedArrayPort+"\t'5s' -> OPTIONS Read\n")
+	b := &recordingBuilder{}
+	if err := parser.Build(b); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"AddProcess Read ReadFile map[]",
+		"AddProcess Process Output map[]",
+		"AddProcess Log Console map[]",
+		"Connect (Read, OUT) (Process, IN[0]) map[]",
+		"Connect (Process, OUT[0]) (Log, IN) map[]",
+		`AddInitial "5s" (Read, OPTIONS)`,
+		"ExportInport CONFIG (Read, OPTIONS)",
+		"ExportInport EXTRA (Process, IN[0])",
+		"ExportInport FILENAME (Read, IN)",
+		"ExportOutport RESULT (Process, OUT[1])",
+	}
+	if !reflect.DeepEqual(b.calls, expected) {
+		t.Fatalf("Unexpected calls:\n%s", strings.Join(b.calls, "\n"))
+	}
+}
+
+func TestMarshalGo(t *testing.T) {
+	parser := parseGraph(t, graphExportedArrayPort+"\t'it\\'s' -> OPTIONS Read\n")
+	parser.Processes[0].Metadata = map[string]string{"colour": "red"}
+	code, err := parser.MarshalGo(&GoOptions{Package: "graphs", Source: "testdata/read-file.fbp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := string(code)
+	t.Log(src)
+	if _, err := goparser.ParseFile(token.NewFileSet(), "read_file_fbp.go", code, goparser.ParseComments); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"// Code generated by fbpgen from read-file.fbp. DO NOT EDIT.",
+		"package graphs",
+		"var ReadFileGraph = &fbp.BaseFbp{",
+		`{Name: "Read", Component: "ReadFile", Metadata: map[string]string{"colour": "red"}},`,
+		`{Data: "it's", Target: &fbp.Endpoint{Process: "Read", Port: "OPTIONS"}},`,
+		`"EXTRA":    &fbp.Endpoint{Process: "Process", Port: "IN", Index: fbp.Index(0)},`,
+		"func BuildReadFile(b fbp.Builder) error {",
+		`if err := b.Connect(&fbp.Endpoint{Process: "Read", Port: "OUT"}, &fbp.Endpoint{Process: "Process", Port: "IN", Index: fbp.Index(0)}, nil); err != nil {`,
+		`if err := b.AddInitial("it's", &fbp.Endpoint{Process: "Read", Port: "OPTIONS"}); err != nil {`,
+	} {
+		if !strings.Contains(src, expected) {
+			t.Fatalf("Missing %s", expected)
+		}
+	}
+}
+
+func TestMarshalGoNamedProcesses(t *testing.T) {
+	parser := parseGraph(t, "'x' -> IN Read(fs/read) OUT -> IN Display\n")
+	code, err := parser.MarshalGo(&GoOptions{Name: "part"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"var PartGraph = fbp.NameProcesses(&fbp.BaseFbp{", `}, "Display")`} {
+		if !strings.Contains(string(code), expected) {
+			t.Fatalf("Missing %s in\n%s", expected, code)
+		}
+	}
+	graph := NameProcesses(&BaseFbp{
+		Processes:   []*Process{{Name: "Read", Component: "fs/read"}},
+		Connections: []*Connection{{Source: &Endpoint{Process: "Read", Port: "OUT"}, Target: &Endpoint{Process: "Display", Port: "IN"}}},
+	}, "Display")
+	if err := graph.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	graph = &BaseFbp{Connections: []*Connection{{Data: "x"}}}
+	if _, err := graph.MarshalGo(&GoOptions{}); err == nil || err.Error() != "fbp: connection #0 has no target" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
@@ -719,10 +719,14 @@ func (e *parseError) Error() string {
 	return error
 }
 
+// Deprecated: PrintSyntaxTree prints the rules of the parser's tokens, use
+// Tokenize for the tokens of a source.
 func (p *Fbp) PrintSyntaxTree() {
 	p.tokenTree.PrintSyntaxTree(p.Buffer)
 }
 
+// Deprecated: Highlighter prints the parser's tokens, use HighlightANSI or
+// HighlightHTML to highlight a source.
 func (p *Fbp) Highlighter() {
 	p.tokenTree.PrintSyntax()
 }
@@ -0,0 +1,99 @@
+package fbp
+
+import (
+	"bufio"
+	"encoding/json"
+	"html"
+	"io"
+	"unicode/utf8"
+)
+
+// ANSI escape sequences of the token kinds used by HighlightANSI
+var ANSIColors = map[TokenKind]string{
+	TokenProcess:   "\x1b[1;34m",
+	TokenComponent: "\x1b[32m",
+	TokenMetadata:  "\x1b[90m",
+	TokenPort:      "\x1b[33m",
+	TokenIndex:     "\x1b[33m",
+	TokenIIP:       "\x1b[35m",
+	TokenComment:   "\x1b[90m",
+	TokenArrow:     "\x1b[36m",
+	TokenDirective: "\x1b[1;35m",
+	TokenInvalid:   "\x1b[4;31m",
+}
+
+// HighlightHTML writes src to w as HTML with every token in a span of class
+// "fbp-<kind>", e.g. <span class="fbp-process">Read</span>. The output is
+// meant to go inside <pre>.
+func HighlightHTML(w io.Writer, src []byte) error {
+	return highlight(w, src, func(b *bufio.Writer, kind TokenKind, text string) {
+		if kind == 0 {
+			b.WriteString(html.EscapeString(text))
+			return
+		}
+		b.WriteString(`<span class="fbp-` + kind.String() + `">`)
+		b.WriteString(html.EscapeString(text))
+		b.WriteString("</span>")
+	})
+}
+
+// HighlightANSI writes src to w coloured for terminals with ANSIColors
+func HighlightANSI(w io.Writer, src []byte) error {
+	return highlight(w, src, func(b *bufio.Writer, kind TokenKind, text string) {
+		color, ok := ANSIColors[kind]
+		if !ok {
+			b.WriteString(text)
+			return
+		}
+		b.WriteString(color)
+		b.WriteString(text)
+		b.WriteString("\x1b[0m")
+	})
+}
+
+// highlight calls write for the tokens of src and the text between them
+// (with kind 0)
+func highlight(w io.Writer, src []byte, write func(*bufio.Writer, TokenKind, string)) error {
+	b := bufio.NewWriter(w)
+	last := 0
+	for _, t := range Tokenize(src) {
+		if t.Offset < last {
+			continue
+		}
+		write(b, 0, string(src[last:t.Offset]))
+		write(b, t.Kind, t.Text(src))
+		last = t.Offset + t.Length
+	}
+	write(b, 0, string(src[last:]))
+	return b.Flush()
+}
+
+//
+// Token of WriteTokensJSON output, Line and Column (1-based, column in
+// characters) locate Offset
+//
+type jsonToken struct {
+	Token
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// WriteTokensJSON writes the tokens of src to w as a stream of JSON objects,
+// one per line, e.g. {"kind":"process","offset":4,"length":4,"line":2,"column":1}
+func WriteTokensJSON(w io.Writer, src []byte) error {
+	b := bufio.NewWriter(w)
+	encoder := json.NewEncoder(b)
+	line, lineBegin, scanned := 1, 0, 0
+	for _, t := range Tokenize(src) {
+		for ; scanned < t.Offset; scanned++ {
+			if src[scanned] == '\n' || (src[scanned] == '\r' && (scanned+1 == len(src) || src[scanned+1] != '\n')) {
+				line, lineBegin = line+1, scanned+1
+			}
+		}
+		column := utf8.RuneCount(src[lineBegin:t.Offset]) + 1
+		if err := encoder.Encode(&jsonToken{Token: t, Line: line, Column: column}); err != nil {
+			return err
+		}
+	}
+	return b.Flush()
+}
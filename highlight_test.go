@@ -0,0 +1,44 @@
+package fbp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	src := []byte("'<x>' -> IN A(core/a)\r\n# ü\nA OUT -> IN B\n")
+
+	var buf bytes.Buffer
+	if err := HighlightHTML(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<span class="fbp-iip">&#39;&lt;x&gt;&#39;</span> <span class="fbp-arrow">-&gt;</span> ` +
+		`<span class="fbp-port">IN</span> <span class="fbp-process">A</span>(<span class="fbp-component">core/a</span>)` + "\r\n" +
+		`<span class="fbp-comment"># ü</span>` + "\n" +
+		`<span class="fbp-process">A</span> <span class="fbp-port">OUT</span> <span class="fbp-arrow">-&gt;</span> ` +
+		`<span class="fbp-port">IN</span> <span class="fbp-process">B</span>` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("Unexpected HTML %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := HighlightANSI(&buf, []byte("A OUT -> IN B")); err != nil {
+		t.Fatal(err)
+	}
+	expected = "\x1b[1;34mA\x1b[0m \x1b[33mOUT\x1b[0m \x1b[36m->\x1b[0m \x1b[33mIN\x1b[0m \x1b[1;34mB\x1b[0m"
+	if buf.String() != expected {
+		t.Fatalf("Unexpected ANSI %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteTokensJSON(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 11 ||
+		string(lines[0]) != `{"kind":"iip","offset":0,"length":5,"line":1,"column":1}` ||
+		string(lines[5]) != `{"kind":"comment","offset":23,"length":4,"line":2,"column":1}` ||
+		string(lines[10]) != `{"kind":"process","offset":40,"length":1,"line":3,"column":13}` {
+		t.Fatalf("Unexpected JSON %s", buf.String())
+	}
+}
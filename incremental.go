@@ -0,0 +1,391 @@
+package fbp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//
+// Edit replaces Begin:End (byte offsets) of a source with Text
+//
+type Edit struct {
+	Begin, End int
+	Text       string
+}
+
+//
+// IncrementalParser keeps a .fbp source parsed while it is edited. A text
+// line (or the lines of an IIP spanning them) parses and executes on its
+// own the same as within the source, so an edit only re-parses the lines it
+// touches and the graph is put together from the graphs of the lines.
+// Graph() and Diagnostics() are the same as those of a full Parse(),
+// Execute() and Check() of Source(). (Execute() of a whole source is off
+// after non-ASCII characters as it slices the buffer with rune offsets,
+// here only the characters of the same line count.)
+//
+type IncrementalParser struct {
+	lines       []*parsedLine
+	graph       *BaseFbp
+	diagnostics []*Diagnostic
+	// symbols of the lines, nil once they change
+	index *symbolIndex
+	// whether the symbols changed since Diagnostics() were checked
+	changed bool
+	// number of lines parsed by the last edit
+	parsed int
+}
+
+//
+// Text line with its line terminator (or lines of an IIP), graph and
+// symbols are nil if it doesn't parse
+//
+type parsedLine struct {
+	text    string
+	graph   *BaseFbp
+	symbols *symbolIndex
+	// the syntax error once asked for
+	err *Diagnostic
+}
+
+// NewIncrementalParser parses src
+func NewIncrementalParser(src []byte) *IncrementalParser {
+	p := &IncrementalParser{}
+	p.lines, _ = p.parseLines(string(src), nil)
+	p.changed = true
+	p.update()
+	return p
+}
+
+// Apply applies the edits one after another, the offsets of an edit are in
+// the source with the preceding edits applied (as in LSP didChange)
+func (p *IncrementalParser) Apply(edits ...Edit) error {
+	p.parsed = 0
+	for _, e := range edits {
+		if err := p.apply(e); err != nil {
+			p.update()
+			return err
+		}
+	}
+	p.update()
+	return nil
+}
+
+// Source returns the current source
+func (p *IncrementalParser) Source() []byte {
+	var b strings.Builder
+	for _, l := range p.lines {
+		b.WriteString(l.text)
+	}
+	return []byte(b.String())
+}
+
+// Graph returns the graph of the source or nil if it doesn't parse. The
+// graph shares its processes and connections with the graphs of later
+// edits, it must not be modified.
+func (p *IncrementalParser) Graph() *BaseFbp {
+	return p.graph
+}
+
+// Diagnostics returns the diagnostics of the source as Check() gives them
+func (p *IncrementalParser) Diagnostics() []*Diagnostic {
+	return p.diagnostics
+}
+
+func (p *IncrementalParser) apply(e Edit) error {
+	size := 0
+	for _, l := range p.lines {
+		size += len(l.text)
+	}
+	if e.Begin < 0 || e.Begin > e.End || e.End > size {
+		return fmt.Errorf("fbp: edit %d:%d out of range 0:%d", e.Begin, e.End, size)
+	}
+
+	// the lines containing Begin and End, the one before if it may end
+	// with "\r" of "\r\n"
+	first, last := p.lineAt(e.Begin), p.lineAt(e.End)+1
+	if last > len(p.lines) {
+		last = len(p.lines)
+	}
+	if first > 0 && strings.HasSuffix(p.lines[first-1].text, "\r") {
+		first--
+	}
+	begin := 0
+	for _, l := range p.lines[:first] {
+		begin += len(l.text)
+	}
+	var b strings.Builder
+	for _, l := range p.lines[first:last] {
+		b.WriteString(l.text)
+	}
+	text := b.String()
+	text = text[:e.Begin-begin] + e.Text + text[e.End-begin:]
+
+	// a line left without its terminator joins the next one
+	for last < len(p.lines) && !strings.HasSuffix(text, "\n") {
+		text += p.lines[last].text
+		last++
+	}
+
+	lines, consumed := p.parseLines(text, p.lines[last:])
+	if !sameSymbols(p.lines[first:last+consumed], lines) {
+		p.changed = true
+	}
+	p.lines = append(p.lines[:first], append(lines, p.lines[last+consumed:]...)...)
+	p.index = nil
+	return nil
+}
+
+// lineAt returns the index of the line containing offset, the end of the
+// source is in the last line
+func (p *IncrementalParser) lineAt(offset int) int {
+	for i, l := range p.lines {
+		if offset < len(l.text) {
+			return i
+		}
+		offset -= len(l.text)
+	}
+	if len(p.lines) == 0 {
+		return 0
+	}
+	return len(p.lines) - 1
+}
+
+// parseLines splits text into lines and parses them. An IIP may span text
+// lines, so a line that leaves an IIP open is parsed with the following
+// ones up to the one closing it, taking lines of rest if needed. It returns
+// the lines and the number of lines of rest they took.
+func (p *IncrementalParser) parseLines(text string, rest []*parsedLine) ([]*parsedLine, int) {
+	queue, consumed := splitLines(text), 0
+	next := func() (string, bool) {
+		if len(queue) == 0 {
+			if consumed == len(rest) {
+				return "", false
+			}
+			queue = splitLines(rest[consumed].text)
+			consumed++
+		}
+		line := queue[0]
+		queue = queue[1:]
+		return line, true
+	}
+
+	var lines []*parsedLine
+	for len(queue) > 0 {
+		text, _ := next()
+		if openIIP(text, false) {
+			// the lines up to the end if none closes the IIP
+			var b strings.Builder
+			b.WriteString(text)
+			for more, ok := next(); ok; more, ok = next() {
+				b.WriteString(more)
+				if !openIIP(more, true) {
+					break
+				}
+			}
+			text = b.String()
+		}
+		lines = append(lines, p.parseLine(text))
+	}
+	return lines, consumed
+}
+
+// openIIP reports whether an IIP is left open at the end of a text line,
+// open tells if one is open at its beginning
+func openIIP(line string, open bool) bool {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case !open && line[i] == '#':
+			// a comment up to the end of the line
+			return false
+		case line[i] == '\\' && open && i+1 < len(line) && line[i+1] == '\'':
+			i++
+		case line[i] == '\'':
+			open = !open
+		}
+	}
+	return open
+}
+
+// splitStatements splits text into lines, the lines of an IIP spanning
+// them together (up to the end if none closes it)
+func splitStatements(text string) []string {
+	var statements []string
+	open := false
+	for _, line := range splitLines(text) {
+		if open {
+			statements[len(statements)-1] += line
+		} else {
+			statements = append(statements, line)
+		}
+		open = openIIP(line, open)
+	}
+	return statements
+}
+
+// splitLines splits text after "\n", "\r\n" and "\r"
+func splitLines(text string) []string {
+	var lines []string
+	for len(text) > 0 {
+		end := strings.IndexAny(text, "\n\r")
+		switch {
+		case end < 0:
+			end = len(text)
+		case text[end] == '\r' && end+1 < len(text) && text[end+1] == '\n':
+			end += 2
+		default:
+			end++
+		}
+		lines = append(lines, text[:end])
+		text = text[end:]
+	}
+	return lines
+}
+
+func (p *IncrementalParser) parseLine(text string) *parsedLine {
+	p.parsed++
+	l := &parsedLine{text: text}
+	parser := &Fbp{Buffer: text}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		return l
+	}
+	l.symbols = newSymbolIndex(parser.syntaxTree(), text)
+	parser.Execute()
+	l.graph = &parser.BaseFbp
+	return l
+}
+
+// update puts the graph together from the graphs of the lines, as Execute()
+// of the source would, and checks the symbols if they changed or moved
+// diagnostics
+func (p *IncrementalParser) update() {
+	p.graph = nil
+	for i, l := range p.lines {
+		if l.graph == nil {
+			var b strings.Builder
+			for _, l := range p.lines[:i] {
+				b.WriteString(l.text)
+			}
+			offset := b.Len()
+			b.WriteString(l.text)
+			begin, end, message := l.syntaxError()
+			p.diagnostics = []*Diagnostic{newDiagnostic(b.String(), SeverityError, offset+begin, offset+end, message)}
+			p.changed = true
+			return
+		}
+	}
+
+	graph := &BaseFbp{}
+	declared := make(map[string]bool)
+	for _, l := range p.lines {
+		for _, process := range l.graph.Processes {
+			if !declared[process.Name] {
+				declared[process.Name] = true
+				graph.Processes = append(graph.Processes, process)
+			}
+		}
+		graph.Connections = append(graph.Connections, l.graph.Connections...)
+		for name, e := range l.graph.Inports {
+			if graph.Inports == nil {
+				graph.Inports = make(map[string]*Endpoint)
+			}
+			graph.Inports[name] = e
+		}
+		for name, e := range l.graph.Outports {
+			if graph.Outports == nil {
+				graph.Outports = make(map[string]*Endpoint)
+			}
+			graph.Outports[name] = e
+		}
+		for name := range l.graph.named {
+			graph.name(name)
+		}
+	}
+	p.graph = graph
+
+	// with no diagnostics and the same symbols there are none still
+	if p.changed || len(p.diagnostics) > 0 {
+		p.diagnostics = checkSymbols(string(p.Source()), p.symbols())
+		p.changed = false
+	}
+}
+
+// symbols returns the symbols of the lines that parse
+func (p *IncrementalParser) symbols() *symbolIndex {
+	if p.index == nil {
+		p.index = &symbolIndex{processes: make(map[string][]*Symbol)}
+		offset := 0
+		for _, l := range p.lines {
+			if l.symbols != nil {
+				p.index.merge(l.symbols, offset)
+			}
+			offset += len(l.text)
+		}
+	}
+	return p.index
+}
+
+// sameSymbols reports whether two runs of lines have the same processes,
+// components and exported ports, wherever they are
+func sameSymbols(a, b []*parsedLine) bool {
+	keys := func(lines []*parsedLine) []string {
+		var keys []string
+		for _, l := range lines {
+			if l.symbols == nil {
+				keys = append(keys, "")
+				continue
+			}
+			for name, symbols := range l.symbols.processes {
+				for _, s := range symbols {
+					keys = append(keys, name+"("+s.Component+")")
+				}
+			}
+			for _, e := range l.symbols.exports {
+				keys = append(keys, e.kind+"="+e.process.Name+":"+e.name)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	return reflect.DeepEqual(keys(a), keys(b))
+}
+
+// syntaxError returns the range and message of the error of a line that
+// doesn't parse, it is parsed again as the parser isn't kept
+func (l *parsedLine) syntaxError() (int, int, string) {
+	if l.err == nil {
+		parser := &Fbp{Buffer: l.text}
+		parser.Init()
+		parser.Parse()
+		begin, end, message := parser.syntaxErrorRange()
+		l.err = &Diagnostic{Begin: begin, End: end, Message: message}
+	}
+	return l.err.Begin, l.err.End, l.err.Message
+}
+
+// merge adds the symbols of other, which are offset in the source
+func (index *symbolIndex) merge(other *symbolIndex, offset int) {
+	shift := func(s *Symbol) *Symbol {
+		shifted := *s
+		shifted.Begin += offset
+		shifted.End += offset
+		return &shifted
+	}
+	for _, name := range other.names {
+		if _, ok := index.processes[name]; !ok {
+			index.names = append(index.names, name)
+		}
+		for _, s := range other.processes[name] {
+			index.processes[name] = append(index.processes[name], shift(s))
+		}
+	}
+	for _, e := range other.exports {
+		shifted := *e
+		shifted.process = shift(e.process)
+		shifted.begin += offset
+		shifted.nameBegin += offset
+		index.exports = append(index.exports, &shifted)
+	}
+}
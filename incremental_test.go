@@ -0,0 +1,189 @@
+package fbp
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const incrementalSource = `# Reads and counts lines
+INPORT=Read.SOURCE:FILENAME
+OUTPORT=Display.OUT:OUT
+Read(core/read) OUT -> IN Split(strings/split:main), Split OUT -> IN Count(core/count)
+'\n' -> DELIMITER Split
+Count OUT -> IN Display(core/output) # shows the count
+Count ERROR[0] -> IN Log(core/log)
+`
+
+// fullParse returns the graph and the diagnostics of a full parse of src
+func fullParse(src string) (*BaseFbp, []*Diagnostic) {
+	parser := &Fbp{Buffer: src}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		return nil, Check([]byte(src))
+	}
+	parser.Execute()
+	return &parser.BaseFbp, Check([]byte(src))
+}
+
+func compareIncremental(t *testing.T, p *IncrementalParser, src string) {
+	if string(p.Source()) != src {
+		t.Fatalf("Expected source %q, got %q", src, p.Source())
+	}
+	graph, diagnostics := fullParse(src)
+	actual := p.Graph()
+	if (graph == nil) != (actual == nil) {
+		t.Fatalf("Expected graph %v, got %v for %q", graph, actual, src)
+	}
+	if graph != nil {
+		if !reflect.DeepEqual(graph.Processes, actual.Processes) ||
+			!reflect.DeepEqual(graph.Connections, actual.Connections) ||
+			!reflect.DeepEqual(graph.Inports, actual.Inports) ||
+			!reflect.DeepEqual(graph.Outports, actual.Outports) {
+			t.Fatalf("Graphs differ for %q", src)
+		}
+	}
+	if !reflect.DeepEqual(diagnostics, p.Diagnostics()) {
+		t.Fatalf("Expected diagnostics %v, got %v for %q", diagnostics, p.Diagnostics(), src)
+	}
+}
+
+func TestIncrementalParser(t *testing.T) {
+	src := incrementalSource
+	p := NewIncrementalParser([]byte(src))
+	compareIncremental(t, p, src)
+	if len(p.Graph().Processes) != 5 {
+		t.Fatalf("Expected 5 processes, got %d", len(p.Graph().Processes))
+	}
+
+	// the edit at the first occurrence of a text
+	at := func(text string, length int, replacement string) func(string) Edit {
+		return func(src string) Edit {
+			begin := strings.Index(src, text)
+			return Edit{Begin: begin, End: begin + length, Text: replacement}
+		}
+	}
+	for _, test := range []struct {
+		edit   func(string) Edit
+		parsed int
+	}{
+		// rename a process in one line
+		{at("Log(", 3, "Logger"), 1},
+		// break a line
+		{at("Count OUT", 5, "Count OUT ->"), 1},
+		// fix it
+		{at("Count OUT ->", 12, "Count"), 1},
+		// join two lines
+		{at("\n'\\n'", 1, ", "), 1},
+		// split them again
+		{at(", '\\n'", 2, "\n"), 2},
+		// insert lines
+		{at("# Reads", 0, "A(core/a) OUT -> IN Read\r\nB(core/b) OUT -> IN A\r"), 3},
+		// declare a process twice
+		{func(src string) Edit {
+			return Edit{Begin: len(src), End: len(src), Text: "B OUT -> IN Count(core/other)"}
+		}, 2},
+		// delete everything
+		{func(src string) Edit { return Edit{Begin: 0, End: len(src)} }, 0},
+	} {
+		e := test.edit(src)
+		src = src[:e.Begin] + e.Text + src[e.End:]
+		if err := p.Apply(e); err != nil {
+			t.Fatal(err)
+		}
+		compareIncremental(t, p, src)
+		if p.parsed != test.parsed {
+			t.Errorf("Expected %d lines parsed by %+v, got %d", test.parsed, e, p.parsed)
+		}
+	}
+
+	if err := p.Apply(Edit{Begin: 1, End: 1, Text: "x"}); err == nil {
+		t.Error("Expected an error of an edit out of range")
+	}
+}
+
+func TestIncrementalParserBrokenLine(t *testing.T) {
+	src := "A(core/a) OUT -> IN B(core/b)\nB OUT -> \nB OUT -> IN C(core/c)\n"
+	p := NewIncrementalParser([]byte(src))
+	compareIncremental(t, p, src)
+	if p.Graph() != nil || len(p.Diagnostics()) != 1 {
+		t.Fatalf("Expected a syntax error, got %v", p.Diagnostics())
+	}
+	if d := p.Diagnostics()[0]; d.Line != 2 || d.Column != 7 {
+		t.Errorf("Expected the error at 2:7, got %v", d)
+	}
+
+	offset := strings.Index(src, "-> \n") + 3
+	p.Apply(Edit{Begin: offset, End: offset, Text: "IN C"})
+	compareIncremental(t, p, src[:offset]+"IN C"+src[offset:])
+}
+
+func TestIncrementalParserRandomEdits(t *testing.T) {
+	fragments := []string{"", "A", "Count", " ", " -> ", "IN ", "OUT ", "\n", "\r\n", "\r", ",", "'x'", "(core/a)", "#", "[1]", "INPORT=", "Split.IN:IN"}
+	random := rand.New(rand.NewSource(1))
+	src := incrementalSource
+	p := NewIncrementalParser([]byte(src))
+	for i := 0; i < 500; i++ {
+		var edits []Edit
+		for n := random.Intn(3) + 1; n > 0; n-- {
+			begin := random.Intn(len(src) + 1)
+			end := begin + random.Intn(10)
+			if end > len(src) {
+				end = len(src)
+			}
+			e := Edit{Begin: begin, End: end, Text: fragments[random.Intn(len(fragments))]}
+			src = src[:e.Begin] + e.Text + src[e.End:]
+			edits = append(edits, e)
+		}
+		if err := p.Apply(edits...); err != nil {
+			t.Fatal(err)
+		}
+		compareIncremental(t, p, src)
+		if len(src) < 100 {
+			// start again before it's all gone
+			p.Apply(Edit{Begin: 0, End: len(src), Text: incrementalSource})
+			src = incrementalSource
+		}
+	}
+}
+
+func TestIncrementalParserMultilineIIP(t *testing.T) {
+	src := "A(core/a) OUT -> IN B(core/b)\n'one\ntwo' -> IN A\nB OUT -> IN C(core/c)\n"
+	p := NewIncrementalParser([]byte(src))
+	compareIncremental(t, p, src)
+	if iip := p.Graph().Connections[1].Data; iip != "one\ntwo" {
+		t.Fatalf("Expected a multiline IIP, got %q", iip)
+	}
+
+	// opening and closing the IIP again
+	offset := strings.Index(src, "two'") + 3
+	for _, e := range []Edit{{offset, offset + 1, ""}, {offset, offset, "'"}} {
+		src = src[:e.Begin] + e.Text + src[e.End:]
+		p.Apply(e)
+		compareIncremental(t, p, src)
+	}
+}
+
+func TestIncrementalParserOpenIIP(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&b, "P%d(core/p) OUT -> IN Q%d(core/q) # it's line %d\n", i, i, i)
+	}
+	src := b.String()
+	p := NewIncrementalParser([]byte(src))
+
+	// the quote opens an IIP up to the one of the first comment
+	for _, e := range []Edit{{0, 0, "'"}, {0, 1, ""}} {
+		src = src[:e.Begin] + e.Text + src[e.End:]
+		p.Apply(e)
+		compareIncremental(t, p, src)
+		if p.parsed > 2000 {
+			t.Errorf("Expected at most a parse per line, got %d", p.parsed)
+		}
+	}
+	if p.Apply(Edit{0, 0, "'"}); p.parsed != 1 {
+		t.Errorf("Expected the lines up to the closing quote parsed once, got %d parses", p.parsed)
+	}
+}
@@ -0,0 +1,320 @@
+package fbp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//
+// NoFlo graph.json representation of a graph. Port names are written in
+// lower case with "caseSensitive": false, same as NoFlo's own .fbp parser.
+// Metadata and properties read from graph.json values other than strings are
+// written back as such, other values as strings.
+//
+type noFloGraph struct {
+	CaseSensitive bool                       `json:"caseSensitive"`
+	Properties    map[string]json.RawMessage `json:"properties"`
+	Inports       map[string]*Endpoint       `json:"inports"`
+	Outports      map[string]*Endpoint       `json:"outports"`
+	Groups        []*noFloGroup              `json:"groups"`
+	Processes     map[string]*noFloProcess   `json:"processes"`
+	Connections   []*noFloConnection         `json:"connections"`
+}
+
+type noFloConnection struct {
+	Data     *string                    `json:"data,omitempty"`
+	Source   *Endpoint                  `json:"src,omitempty"`
+	Target   *Endpoint                  `json:"tgt"`
+	Metadata map[string]json.RawMessage `json:"metadata,omitempty"`
+}
+
+type noFloGroup struct {
+	Name     string                     `json:"name"`
+	Nodes    []string                   `json:"nodes"`
+	Metadata map[string]json.RawMessage `json:"metadata,omitempty"`
+}
+
+type noFloProcess struct {
+	Component string                     `json:"component"`
+	Metadata  map[string]json.RawMessage `json:"metadata,omitempty"`
+}
+
+// MarshalJSON returns the graph in NoFlo graph.json format. The processes
+// only named by connections and exported ports (without a component in
+// .fbp) are written with an empty component.
+func (self *BaseFbp) MarshalJSON() ([]byte, error) {
+	graph := &noFloGraph{
+		Properties:  make(map[string]json.RawMessage),
+		Inports:     make(map[string]*Endpoint),
+		Outports:    make(map[string]*Endpoint),
+		Groups:      make([]*noFloGroup, 0, len(self.Groups)),
+		Processes:   make(map[string]*noFloProcess),
+		Connections: make([]*noFloConnection, 0, len(self.Connections)),
+	}
+	for k, v := range self.Properties {
+		graph.Properties[k] = self.jsonValue(self, k, v)
+	}
+	if _, ok := graph.Properties["name"]; !ok && self.Subgraph != "" {
+		graph.Properties["name"], _ = json.Marshal(self.Subgraph)
+	}
+	reference := func(e *Endpoint) {
+		if _, ok := graph.Processes[e.Process]; !ok {
+			graph.Processes[e.Process] = &noFloProcess{}
+		}
+	}
+	for _, p := range self.Processes {
+		graph.Processes[p.Name] = &noFloProcess{Component: p.Component, Metadata: self.jsonValues(p, p.Metadata)}
+	}
+	for name, e := range self.Inports {
+		graph.Inports[strings.ToLower(name)] = noFloEndpoint(e)
+		reference(e)
+	}
+	for name, e := range self.Outports {
+		graph.Outports[strings.ToLower(name)] = noFloEndpoint(e)
+		reference(e)
+	}
+	for _, g := range self.Groups {
+		group := &noFloGroup{Name: g.Name, Nodes: g.Nodes, Metadata: self.jsonValues(g, g.Metadata)}
+		if group.Nodes == nil {
+			group.Nodes = []string{}
+		}
+		graph.Groups = append(graph.Groups, group)
+	}
+	for _, c := range self.Connections {
+		connection := &noFloConnection{
+			Target:   noFloEndpoint(c.Target),
+			Metadata: self.jsonValues(c, c.Metadata),
+		}
+		if c.Target != nil {
+			reference(c.Target)
+		}
+		if c.Source != nil {
+			connection.Source = noFloEndpoint(c.Source)
+			reference(c.Source)
+		} else {
+			data := c.Data
+			connection.Data = &data
+		}
+		graph.Connections = append(graph.Connections, connection)
+	}
+	return json.Marshal(graph)
+}
+
+func noFloEndpoint(e *Endpoint) *Endpoint {
+	if e == nil {
+		return nil
+	}
+	return &Endpoint{
+		Process: e.Process,
+		Port:    strings.ToLower(e.Port),
+		Index:   e.Index,
+	}
+}
+
+//
+// NoFlo graph.json as it is read. Metadata, properties and IIP data can hold
+// any JSON value, they are kept as strings (non-string values as JSON text).
+//
+type noFloInput struct {
+	CaseSensitive bool                       `json:"caseSensitive"`
+	Properties    map[string]json.RawMessage `json:"properties"`
+	Inports       map[string]*Endpoint       `json:"inports"`
+	Outports      map[string]*Endpoint       `json:"outports"`
+	Groups        []*noFloGroup              `json:"groups"`
+	Processes     json.RawMessage            `json:"processes"`
+	Connections   []struct {
+		Data     json.RawMessage            `json:"data"`
+		Source   *Endpoint                  `json:"src"`
+		Target   *Endpoint                  `json:"tgt"`
+		Metadata map[string]json.RawMessage `json:"metadata"`
+	} `json:"connections"`
+}
+
+// ParseJSON reads a graph from NoFlo graph.json format
+func ParseJSON(data []byte) (*BaseFbp, error) {
+	graph := &BaseFbp{}
+	if err := graph.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// UnmarshalJSON reads the graph from NoFlo graph.json format. Unless the
+// graph is case sensitive, port names are converted to upper case as
+// required by .fbp DSL. Processes with an empty component are only named,
+// as those of .fbp without a component, they aren't in self.Processes.
+func (self *BaseFbp) UnmarshalJSON(data []byte) error {
+	var input noFloInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return err
+	}
+	port := func(name string) string {
+		if input.CaseSensitive {
+			return name
+		}
+		return strings.ToUpper(name)
+	}
+	endpoint := func(e *Endpoint) *Endpoint {
+		return &Endpoint{Process: e.Process, Port: port(e.Port), Index: e.Index}
+	}
+
+	self.Processes = nil
+	self.Connections = nil
+	self.Inports = nil
+	self.Outports = nil
+	self.Groups = nil
+	self.named = nil
+	self.nonStrings = nil
+	self.Properties = self.jsonStrings(self, input.Properties)
+
+	names, err := jsonObjectKeys(input.Processes)
+	if err != nil {
+		return fmt.Errorf("fbp: processes: %v", err)
+	}
+	if len(names) > 0 {
+		var processes map[string]*noFloProcess
+		if err := json.Unmarshal(input.Processes, &processes); err != nil {
+			return err
+		}
+		for _, name := range names {
+			p := processes[name]
+			if p == nil {
+				return fmt.Errorf("fbp: process %q has no definition", name)
+			}
+			if p.Component == "" {
+				self.name(name)
+				continue
+			}
+			process := &Process{Name: name, Component: p.Component}
+			process.Metadata = self.jsonStrings(process, p.Metadata)
+			self.Processes = append(self.Processes, process)
+		}
+	}
+
+	for i, c := range input.Connections {
+		if c.Target == nil {
+			return fmt.Errorf("fbp: connection #%d has no target", i)
+		}
+		connection := &Connection{Target: endpoint(c.Target)}
+		connection.Metadata = self.jsonStrings(connection, c.Metadata)
+		if c.Source != nil {
+			connection.Source = endpoint(c.Source)
+		} else if c.Data != nil {
+			connection.Data, _ = jsonString(c.Data)
+		} else {
+			return fmt.Errorf("fbp: connection #%d has neither source nor data", i)
+		}
+		self.Connections = append(self.Connections, connection)
+	}
+
+	for name, e := range input.Inports {
+		if e == nil {
+			return fmt.Errorf("fbp: inport %q has no endpoint", name)
+		}
+		if self.Inports == nil {
+			self.Inports = make(map[string]*Endpoint)
+		}
+		self.Inports[port(name)] = endpoint(e)
+	}
+	for name, e := range input.Outports {
+		if e == nil {
+			return fmt.Errorf("fbp: outport %q has no endpoint", name)
+		}
+		if self.Outports == nil {
+			self.Outports = make(map[string]*Endpoint)
+		}
+		self.Outports[port(name)] = endpoint(e)
+	}
+
+	for i, g := range input.Groups {
+		if g == nil {
+			return fmt.Errorf("fbp: group #%d is null", i)
+		}
+		group := &Group{Name: g.Name, Nodes: g.Nodes}
+		group.Metadata = self.jsonStrings(group, g.Metadata)
+		self.Groups = append(self.Groups, group)
+	}
+	return nil
+}
+
+// jsonString returns a JSON string value as is and any other value as JSON
+// text, false if the value isn't a string
+func jsonString(raw json.RawMessage) (string, bool) {
+	var s string
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte(`"`)) && json.Unmarshal(raw, &s) == nil {
+		return s, true
+	}
+	return string(raw), false
+}
+
+// jsonStrings returns values as strings and records the keys of those that
+// aren't strings for owner
+func (self *BaseFbp) jsonStrings(owner interface{}, values map[string]json.RawMessage) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(values))
+	for k, v := range values {
+		s, ok := jsonString(v)
+		if !ok {
+			if self.nonStrings == nil {
+				self.nonStrings = make(map[interface{}]map[string]bool)
+			}
+			if self.nonStrings[owner] == nil {
+				self.nonStrings[owner] = make(map[string]bool)
+			}
+			self.nonStrings[owner][k] = true
+		}
+		m[k] = s
+	}
+	return m
+}
+
+// jsonValue returns the value of key of owner as a JSON string, or as the
+// JSON it holds if it was read from a value other than a string
+func (self *BaseFbp) jsonValue(owner interface{}, key, value string) json.RawMessage {
+	if self.nonStrings[owner][key] && json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	data, _ := json.Marshal(value)
+	return data
+}
+
+func (self *BaseFbp) jsonValues(owner interface{}, values map[string]string) map[string]json.RawMessage {
+	if len(values) == 0 {
+		return nil
+	}
+	m := make(map[string]json.RawMessage, len(values))
+	for k, v := range values {
+		m[k] = self.jsonValue(owner, k, v)
+	}
+	return m
+}
+
+// jsonObjectKeys returns the keys of a JSON object in the document order
+func jsonObjectKeys(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if t, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, fmt.Errorf("object expected")
+	}
+	var keys []string
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, t.(string))
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,250 @@
+package fbp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	parser := parseGraph(t, graphExportedArrayPort+"\t'5s' -> OPTIONS Read\n")
+	data, err := json.Marshal(parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(data))
+
+	var graph map[string]interface{}
+	if err := json.Unmarshal(data, &graph); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"caseSensitive", "properties", "inports", "outports", "groups", "processes", "connections"} {
+		if _, ok := graph[key]; !ok {
+			t.Fatalf("Missing %q in %s", key, data)
+		}
+	}
+	expected := map[string]interface{}{
+		"component": "Output",
+	}
+	if p := graph["processes"].(map[string]interface{})["Process"]; !reflect.DeepEqual(p, expected) {
+		t.Fatalf("Unexpected process %v", p)
+	}
+	expected = map[string]interface{}{
+		"process": "Process",
+		"port":    "in",
+		"index":   float64(0),
+	}
+	if p := graph["inports"].(map[string]interface{})["extra"]; !reflect.DeepEqual(p, expected) {
+		t.Fatalf("Unexpected inport %v", p)
+	}
+	connections := graph["connections"].([]interface{})
+	if len(connections) != 3 {
+		t.Fatalf("Unexpected connections %v", connections)
+	}
+	expected = map[string]interface{}{
+		"src": map[string]interface{}{"process": "Read", "port": "out"},
+		"tgt": map[string]interface{}{"process": "Process", "port": "in", "index": float64(0)},
+	}
+	if !reflect.DeepEqual(connections[0], expected) {
+		t.Fatalf("Unexpected connection %v", connections[0])
+	}
+	expected = map[string]interface{}{
+		"data": "5s",
+		"tgt":  map[string]interface{}{"process": "Read", "port": "options"},
+	}
+	if !reflect.DeepEqual(connections[2], expected) {
+		t.Fatalf("Unexpected IIP %v", connections[2])
+	}
+}
+
+func TestMarshalJSONValues(t *testing.T) {
+	graph, err := ParseJSON([]byte(noFloGraphJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph.Connections = append(graph.Connections, &Connection{
+		Source: &Endpoint{Process: "Display", Port: "OUT"},
+		Target: &Endpoint{Process: "Log", Port: "IN"},
+	})
+	data, err := json.Marshal(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output, input map[string]interface{}
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(noFloGraphJSON), &input); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"properties", "groups"} {
+		if !reflect.DeepEqual(output[key], input[key]) {
+			t.Errorf("Expected %s %v, got %v", key, input[key], output[key])
+		}
+	}
+	processes := output["processes"].(map[string]interface{})
+	for _, name := range []string{"Read", "Display"} {
+		expected := input["processes"].(map[string]interface{})[name]
+		if !reflect.DeepEqual(processes[name], expected) {
+			t.Errorf("Expected process %v, got %v", expected, processes[name])
+		}
+	}
+	expected := map[string]interface{}{"component": ""}
+	if !reflect.DeepEqual(processes["Log"], expected) {
+		t.Errorf("Expected process Log %v, got %v", expected, processes["Log"])
+	}
+	edge := output["connections"].([]interface{})[2].(map[string]interface{})
+	if m := edge["metadata"]; !reflect.DeepEqual(m, map[string]interface{}{"route": float64(5), "secure": false}) {
+		t.Errorf("Unexpected edge metadata %v", m)
+	}
+
+	imported, err := ParseJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := json.Marshal(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("Graph changed after JSON round trip:\n%s\n%s", data, again)
+	}
+}
+
+func TestMarshalJSONStrings(t *testing.T) {
+	parser := parseGraph(t, "Read(fs/read:x=1,hidden=true) OUT -> IN Display(core/out)\n")
+	data, err := json.Marshal(parser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output struct {
+		Properties map[string]interface{}
+		Processes  map[string]struct{ Metadata map[string]interface{} }
+	}
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"x": "1", "hidden": "true"}
+	if m := output.Processes["Read"].Metadata; !reflect.DeepEqual(m, expected) {
+		t.Fatalf("Unexpected metadata %v", m)
+	}
+
+	src := `{"properties": {"a": "1", "b": 1}, "processes": {"A": {"component": "a", "metadata": {"c": "null", "d": null}}}}`
+	graph, err := ParseJSON([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = json.Marshal(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+	if p := output.Properties; p["a"] != "1" || p["b"] != float64(1) {
+		t.Fatalf("Unexpected properties %v", p)
+	}
+	expected = map[string]interface{}{"c": "null", "d": nil}
+	if m := output.Processes["A"].Metadata; !reflect.DeepEqual(m, expected) {
+		t.Fatalf("Unexpected metadata %v", m)
+	}
+}
+
+const noFloGraphJSON = `{
+  "properties": {"name": "Count lines", "environment": {"type": "noflo-nodejs"}},
+  "inports": {"filename": {"process": "Read", "port": "in", "metadata": {"x": 10}}},
+  "outports": {"count": {"process": "Display", "port": "out"}},
+  "groups": [{"name": "Input", "nodes": ["Read", "Split"], "metadata": {"description": "Reading", "color": 2}}],
+  "processes": {
+    "Read": {"component": "ReadFile", "metadata": {"label": "Read", "x": 36, "y": 72.5}},
+    "Split": {"component": "SplitStr"},
+    "Display": {"component": "Output", "metadata": {"hidden": true, "tags": ["a", "b"]}}
+  },
+  "connections": [
+    {"data": "package.json", "tgt": {"process": "Read", "port": "in"}},
+    {"data": 42, "tgt": {"process": "Split", "port": "limit"}},
+    {"src": {"process": "Read", "port": "out"}, "tgt": {"process": "Split", "port": "in", "index": 1}, "metadata": {"route": 5, "secure": false}},
+    {"src": {"process": "Split", "port": "out", "index": 0}, "tgt": {"process": "Display", "port": "in"}}
+  ]
+}`
+
+func TestParseJSON(t *testing.T) {
+	graph, err := ParseJSON([]byte(noFloGraphJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, p := range graph.Processes {
+		names = append(names, p.String())
+	}
+	if !reflect.DeepEqual(names, []string{"Read(ReadFile)", "Split(SplitStr)", "Display(Output)"}) {
+		t.Fatalf("Unexpected processes %v", names)
+	}
+	expected := map[string]string{"label": "Read", "x": "36", "y": "72.5"}
+	if !reflect.DeepEqual(graph.Processes[0].Metadata, expected) {
+		t.Fatalf("Unexpected metadata %v", graph.Processes[0].Metadata)
+	}
+	if m := graph.Processes[2].Metadata; m["hidden"] != "true" || m["tags"] != `["a", "b"]` {
+		t.Fatalf("Unexpected metadata %v", m)
+	}
+	if graph.Properties["name"] != "Count lines" || graph.Properties["environment"] != `{"type": "noflo-nodejs"}` {
+		t.Fatalf("Unexpected properties %v", graph.Properties)
+	}
+	if len(graph.Groups) != 1 || graph.Groups[0].Metadata["color"] != "2" || len(graph.Groups[0].Nodes) != 2 {
+		t.Fatalf("Unexpected groups %v", graph.Groups)
+	}
+
+	var connections []string
+	for _, c := range graph.Connections {
+		connections = append(connections, c.String())
+	}
+	if !reflect.DeepEqual(connections, []string{
+		"(package.json -> (Read, IN) )",
+		"(42 -> (Split, LIMIT) )",
+		"((Read, OUT) -> (Split, IN[1]))",
+		"((Split, OUT[0]) -> (Display, IN))",
+	}) {
+		t.Fatalf("Unexpected connections %v", connections)
+	}
+	if m := graph.Connections[2].Metadata; m["route"] != "5" || m["secure"] != "false" {
+		t.Fatalf("Unexpected edge metadata %v", m)
+	}
+	if e := graph.Inports["FILENAME"]; e == nil || e.Process != "Read" || e.Port != "IN" {
+		t.Fatalf("Unexpected inports %v", graph.Inports)
+	}
+	if e := graph.Outports["COUNT"]; e == nil || e.Process != "Display" || e.Port != "OUT" {
+		t.Fatalf("Unexpected outports %v", graph.Outports)
+	}
+}
+
+func TestParseJSONNull(t *testing.T) {
+	for src, expected := range map[string]string{
+		`{"inports": {"x": null}}`:   `fbp: inport "x" has no endpoint`,
+		`{"outports": {"y": null}}`:  `fbp: outport "y" has no endpoint`,
+		`{"groups": [null]}`:         "fbp: group #0 is null",
+		`{"processes": {"A": null}}`: `fbp: process "A" has no definition`,
+		`{"connections": [{"src": null, "tgt": {"process": "A", "port": "in"}}]}`: "fbp: connection #0 has neither source nor data",
+	} {
+		if _, err := ParseJSON([]byte(src)); err == nil || err.Error() != expected {
+			t.Errorf("Expected error %q for %s, got %v", expected, src, err)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, graph := range []string{graphTickLogger, graphDemo, graphExportedInPort, graphArrayPorts, graphExportedArrayPort} {
+		parser := parseGraph(t, graph)
+		data, err := json.Marshal(parser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, err := ParseJSON(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equivalentGraphs(&parser.BaseFbp, imported) {
+			t.Fatalf("Graph changed after JSON round trip:\n%s", data)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package fbp
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// LintRule is a style or smell check. Check reports the problems it finds
+// through the context, with Severity unless the config changes it.
+//
+type LintRule struct {
+	ID       string
+	Doc      string
+	Severity Severity
+	Check    func(*LintContext)
+}
+
+// LintRules are the rules of a Linter without Rules:
+//  - unconnected-process: processes without connections,
+//  - unconnected-outport: out-ports of the component of a process connected
+//     nowhere (with Linter.Components only),
+//  - iip-only-process: processes getting IIPs and connected to nothing else,
+//  - duplicate-iip: IIPs sent to a port already getting one,
+//  - export-shadows-process: exported ports named as a process,
+//  - process-name: process names not matching the "pattern" option
+//     (CamelCase by default).
+var LintRules = []*LintRule{
+	{"unconnected-process", "process without connections", SeverityWarning, lintUnconnectedProcess},
+	{"unconnected-outport", "out-port connected nowhere", SeverityInfo, lintUnconnectedOutport},
+	{"iip-only-process", "process getting only IIPs", SeverityWarning, lintIIPOnlyProcess},
+	{"duplicate-iip", "IIP sent to a port getting one already", SeverityWarning, lintDuplicateIIP},
+	{"export-shadows-process", "exported port named as a process", SeverityWarning, lintExportShadowsProcess},
+	{"process-name", "process name against the naming convention", SeverityInfo, lintProcessName},
+}
+
+// LintConfig selects the rules and their severities, e.g.
+//
+//	{
+//		"rules": {"duplicate-iip": "error", "unconnected-outport": "off"},
+//		"options": {"process-name": {"pattern": "^[a-z][a-zA-Z0-9]*$"}}
+//	}
+//
+// Rules is "error", "warning", "info" or "off" by rule ID, the rules not in
+// it are on with their severity.
+type LintConfig struct {
+	Rules   map[string]string            `json:"rules,omitempty"`
+	Options map[string]map[string]string `json:"options,omitempty"`
+}
+
+// ParseLintConfig parses a JSON config
+func ParseLintConfig(data []byte) (*LintConfig, error) {
+	config := &LintConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("fbp: lint config: %v", err)
+	}
+	for id, severity := range config.Rules {
+		if severity == "off" {
+			continue
+		}
+		var s Severity
+		if err := s.UnmarshalText([]byte(severity)); err != nil {
+			return nil, fmt.Errorf("fbp: lint config: rule %s: %v", id, err)
+		}
+	}
+	return config, nil
+}
+
+//
+// Linter checks graphs with rules. Components (optional) gives the ports
+// of the components. Diagnostics of a rule are left out on the lines of a
+// "# fbp:ignore RULE" comment and on the line after it if the comment is
+// alone on its line; several rules are separated by commas or spaces and
+// "# fbp:ignore" alone ignores all of them.
+//
+type Linter struct {
+	Rules      []*LintRule // LintRules if nil
+	Config     *LintConfig
+	Components Components
+}
+
+// Lint parses src and returns the diagnostics of the rules in the order of
+// the source, the error is that of a source that doesn't parse
+func (l *Linter) Lint(src []byte) ([]*Diagnostic, error) {
+	parser := &Fbp{Buffer: string(src)}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		return nil, err
+	}
+	tree := parser.syntaxTree()
+	parser.Execute()
+	b := &cstBuilder{buffer: parser.Buffer}
+	cst := b.node(CSTFile, 0, len(b.buffer), b.children(tree))
+	c := &LintContext{
+		Graph:     &parser.BaseFbp,
+		source:    parser.Buffer,
+		positions: newLintPositions(cst, newSymbolIndex(tree, parser.Buffer)),
+	}
+	diagnostics, err := l.run(c)
+	if err != nil {
+		return nil, err
+	}
+	ignored := ignoredRules(cst, parser.Buffer)
+	kept := diagnostics[:0]
+	for _, d := range diagnostics {
+		if rules, ok := ignored[d.Line]; !ok || !(rules[""] || rules[d.Rule]) {
+			kept = append(kept, d)
+		}
+	}
+	return kept, nil
+}
+
+// LintGraph returns the diagnostics of the rules for a graph without
+// source (e.g. from NoFlo JSON), they are all at 1:1
+func (l *Linter) LintGraph(graph *BaseFbp) ([]*Diagnostic, error) {
+	return l.run(&LintContext{Graph: graph})
+}
+
+func (l *Linter) run(c *LintContext) ([]*Diagnostic, error) {
+	rules := l.Rules
+	if rules == nil {
+		rules = LintRules
+	}
+	config := l.Config
+	if config == nil {
+		config = &LintConfig{}
+	}
+	known := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		known[rule.ID] = true
+	}
+	for id := range config.Rules {
+		if !known[id] {
+			return nil, fmt.Errorf("fbp: lint config: unknown rule %s", id)
+		}
+	}
+	for id := range config.Options {
+		if !known[id] {
+			return nil, fmt.Errorf("fbp: lint config: unknown rule %s", id)
+		}
+	}
+
+	c.Components = l.Components
+	for _, rule := range rules {
+		c.rule, c.severity = rule, rule.Severity
+		if severity, ok := config.Rules[rule.ID]; ok {
+			if severity == "off" {
+				continue
+			}
+			if err := c.severity.UnmarshalText([]byte(severity)); err != nil {
+				return nil, fmt.Errorf("fbp: lint config: rule %s: %v", rule.ID, err)
+			}
+		}
+		c.Options = config.Options[rule.ID]
+		rule.Check(c)
+	}
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		return c.diagnostics[i].Begin < c.diagnostics[j].Begin
+	})
+	return c.diagnostics, nil
+}
+
+//
+// LintContext is what a rule checks: the graph, the ports of components
+// (nil if unknown) and the options of the rule. The ranges of the graph
+// elements in the source are all 0 for a graph without source.
+//
+type LintContext struct {
+	Graph      *BaseFbp
+	Components Components
+	Options    map[string]string
+
+	source      string
+	positions   *lintPositions
+	rule        *LintRule
+	severity    Severity
+	diagnostics []*Diagnostic
+}
+
+// Report adds a diagnostic of the rule at begin:end
+func (c *LintContext) Report(begin, end int, format string, args ...interface{}) {
+	d := &Diagnostic{Severity: c.severity, Line: 1, Column: 1, Message: fmt.Sprintf(format, args...)}
+	if c.positions != nil {
+		d = newDiagnostic(c.source, c.severity, begin, end, d.Message)
+	}
+	d.Rule = c.rule.ID
+	c.diagnostics = append(c.diagnostics, d)
+}
+
+// ProcessRange returns the range of the name of a process where it is
+// declared (or first referred to)
+func (c *LintContext) ProcessRange(name string) (int, int) {
+	if c.positions == nil {
+		return 0, 0
+	}
+	s := c.positions.symbols.declaration(name)
+	if s == nil {
+		references := c.positions.symbols.references(name)
+		if len(references) == 0 {
+			return 0, 0
+		}
+		s = references[0]
+	}
+	return s.Begin, s.End
+}
+
+// IIPRange returns the range of an IIP connection of the graph
+func (c *LintContext) IIPRange(connection *Connection) (int, int) {
+	if c.positions == nil {
+		return 0, 0
+	}
+	// the n-th IIP of the same target and data
+	key := iipKey(connection.Target, connection.Data)
+	n := 0
+	for _, other := range c.Graph.Connections {
+		if other == connection {
+			break
+		}
+		if other.Source == nil && other.Target != nil && iipKey(other.Target, other.Data) == key {
+			n++
+		}
+	}
+	ranges := c.positions.iips[key]
+	if n >= len(ranges) {
+		return 0, 0
+	}
+	return ranges[n][0], ranges[n][1]
+}
+
+// ExportRange returns the range of the name of an exported port, kind is
+// "INPORT" or "OUTPORT"
+func (c *LintContext) ExportRange(kind, name string) (int, int) {
+	if c.positions == nil {
+		return 0, 0
+	}
+	r := c.positions.exports[kind+"="+name]
+	return r[0], r[1]
+}
+
+//
+// Ranges of the graph elements in the source, the IIPs by their target and
+// data in the order of the source
+//
+type lintPositions struct {
+	symbols *symbolIndex
+	iips    map[string][][2]int
+	exports map[string][2]int
+}
+
+func newLintPositions(cst *CST, symbols *symbolIndex) *lintPositions {
+	positions := &lintPositions{
+		symbols: symbols,
+		iips:    make(map[string][][2]int),
+		exports: make(map[string][2]int),
+	}
+	for _, line := range cst.Children {
+		if directive := line.Child(CSTDirective); directive != nil {
+			export := line.Child(CSTExport)
+			if export == nil {
+				continue
+			}
+			names := export.Find(CSTName)
+			name := names[len(names)-1]
+			key := strings.ToUpper(directive.Text) + name.Text
+			if _, ok := positions.exports[key]; !ok {
+				positions.exports[key] = [2]int{name.Begin, name.End}
+			}
+			continue
+		}
+		if connection := line.Child(CSTConnection); connection != nil {
+			positions.addIIPs(connection)
+		}
+	}
+	return positions
+}
+
+// addIIPs adds the IIPs of a chain of bridges with the target of the
+// bridge following them, an IIP after a source doesn't make a connection
+func (positions *lintPositions) addIIPs(connection *CST) {
+	var bridges []*CST
+	for c := connection; c != nil; c = c.Child(CSTConnection) {
+		if b := c.Child(CSTBridge); b != nil {
+			bridges = append(bridges, b)
+		}
+	}
+	source := false
+	for i, b := range bridges {
+		ports := len(b.Find(CSTPort))
+		switch {
+		case b.Child(CSTIIP) != nil:
+			if source || i+1 == len(bridges) {
+				continue
+			}
+			port, node := bridges[i+1].Child(CSTPort), bridges[i+1].Child(CSTNode)
+			if port == nil || node == nil {
+				continue
+			}
+			target := &Endpoint{Process: node.Child(CSTName).Text, Port: port.Child(CSTName).Text}
+			if index := port.Child(CSTIndex); index != nil {
+				if n, err := strconv.Atoi(index.Text); err == nil {
+					target.Index = &n
+				}
+			}
+			iip := b.Child(CSTIIP)
+			key := iipKey(target, unescapeIIP(iip.Text[1:len(iip.Text)-1]))
+			positions.iips[key] = append(positions.iips[key], [2]int{iip.Begin, iip.End})
+		case ports == 2 || b.Children[0].Kind == CSTNode:
+			source = true // middlet or leftlet
+		default:
+			source = false
+		}
+	}
+}
+
+func iipKey(target *Endpoint, data string) string {
+	return endpointKey(target) + " " + formatIIP(data)
+}
+
+// ignoredRules returns the rules of "# fbp:ignore" comments by line, ""
+// for all of them
+func ignoredRules(cst *CST, buffer string) map[int]map[string]bool {
+	ignored := make(map[int]map[string]bool)
+	for _, comment := range cst.Find(CSTComment) {
+		text := strings.TrimSpace(strings.TrimPrefix(comment.Text, "#"))
+		if !strings.HasPrefix(text, "fbp:ignore") {
+			continue
+		}
+		text = text[len("fbp:ignore"):]
+		if text != "" && text[0] != ' ' && text[0] != '\t' {
+			continue
+		}
+		rules := map[string]bool{}
+		for _, rule := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			rules[rule] = true
+		}
+		if len(rules) == 0 {
+			rules[""] = true
+		}
+		line := lineOf(buffer, comment.Begin)
+		lines := []int{line}
+		lineBegin := strings.LastIndexAny(buffer[:comment.Begin], "\n\r") + 1
+		if strings.TrimSpace(buffer[lineBegin:comment.Begin]) == "" {
+			lines = append(lines, line+1)
+		}
+		for _, l := range lines {
+			if ignored[l] == nil {
+				ignored[l] = map[string]bool{}
+			}
+			for rule := range rules {
+				ignored[l][rule] = true
+			}
+		}
+	}
+	return ignored
+}
+
+// connectionsOf returns the connections from and to a process
+func connectionsOf(graph *BaseFbp, name string) (in, out, iips []*Connection) {
+	for _, c := range graph.Connections {
+		switch {
+		case c.Target == nil:
+		case c.Source == nil && c.Target.Process == name:
+			iips = append(iips, c)
+		case c.Source == nil:
+		case c.Target.Process == name:
+			in = append(in, c)
+		}
+		if c.Source != nil && c.Source.Process == name {
+			out = append(out, c)
+		}
+	}
+	return in, out, iips
+}
+
+// exported reports whether a port of a process is exported
+func exported(graph *BaseFbp, name string) bool {
+	for _, ports := range []map[string]*Endpoint{graph.Inports, graph.Outports} {
+		for _, e := range ports {
+			if e.Process == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func lintUnconnectedProcess(c *LintContext) {
+	for _, p := range c.Graph.Processes {
+		in, out, iips := connectionsOf(c.Graph, p.Name)
+		if len(in)+len(out)+len(iips) == 0 && !exported(c.Graph, p.Name) {
+			begin, end := c.ProcessRange(p.Name)
+			c.Report(begin, end, "process %s has no connections", p.Name)
+		}
+	}
+}
+
+func lintUnconnectedOutport(c *LintContext) {
+	if c.Components == nil {
+		return
+	}
+	for _, p := range c.Graph.Processes {
+		_, outPorts := c.Components.Ports(p.Component)
+		_, out, _ := connectionsOf(c.Graph, p.Name)
+		used := make(map[string]bool)
+		for _, connection := range out {
+			used[strings.ToUpper(connection.Source.Port)] = true
+		}
+		for _, e := range c.Graph.Outports {
+			if e.Process == p.Name {
+				used[strings.ToUpper(e.Port)] = true
+			}
+		}
+		for _, port := range outPorts {
+			if !used[strings.ToUpper(port)] {
+				begin, end := c.ProcessRange(p.Name)
+				c.Report(begin, end, "out-port %s of %s is never connected", port, p)
+			}
+		}
+	}
+}
+
+func lintIIPOnlyProcess(c *LintContext) {
+	for _, p := range c.Graph.Processes {
+		in, out, iips := connectionsOf(c.Graph, p.Name)
+		if len(iips) > 0 && len(in)+len(out) == 0 && !exported(c.Graph, p.Name) {
+			begin, end := c.ProcessRange(p.Name)
+			c.Report(begin, end, "process %s gets only IIPs and is connected to nothing", p.Name)
+		}
+	}
+}
+
+func lintDuplicateIIP(c *LintContext) {
+	first := make(map[string]*Connection)
+	for _, connection := range c.Graph.Connections {
+		if connection.Source != nil || connection.Target == nil {
+			continue
+		}
+		key := endpointKey(connection.Target)
+		other, ok := first[key]
+		if !ok {
+			first[key] = connection
+			continue
+		}
+		begin, end := c.IIPRange(connection)
+		if c.positions != nil {
+			otherBegin, _ := c.IIPRange(other)
+			c.Report(begin, end, "%s of %s already gets an IIP on line %d", formatPort(connection.Target), connection.Target.Process, lineOf(c.source, otherBegin))
+		} else {
+			c.Report(begin, end, "%s of %s already gets an IIP", formatPort(connection.Target), connection.Target.Process)
+		}
+	}
+}
+
+func lintExportShadowsProcess(c *LintContext) {
+	for _, kind := range []string{"INPORT", "OUTPORT"} {
+		ports := c.Graph.Inports
+		if kind == "OUTPORT" {
+			ports = c.Graph.Outports
+		}
+		for _, name := range sortedPorts(ports) {
+			for _, p := range c.Graph.Processes {
+				if strings.EqualFold(name, p.Name) {
+					begin, end := c.ExportRange(kind, name)
+					c.Report(begin, end, "%s %s shadows process %s", kind, name, p.Name)
+				}
+			}
+		}
+	}
+}
+
+func lintProcessName(c *LintContext) {
+	pattern := `^[A-Z][a-zA-Z0-9]*$`
+	if p, ok := c.Options["pattern"]; ok {
+		pattern = p
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		c.Report(0, 0, "invalid pattern of process names: %v", err)
+		return
+	}
+	for _, p := range c.Graph.Processes {
+		if !re.MatchString(p.Name) {
+			begin, end := c.ProcessRange(p.Name)
+			c.Report(begin, end, "process name %s doesn't match %s", p.Name, pattern)
+		}
+	}
+}
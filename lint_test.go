@@ -0,0 +1,125 @@
+package fbp
+
+import (
+	"strings"
+	"testing"
+)
+
+const lintSource = `INPORT=Read.IN:READ
+OUTPORT=Display.OUT:OUT
+'a.txt' -> IN Read(fs/read) OUT -> IN Display(core/out)
+'utf8' -> ENCODING Read
+'utf16' -> ENCODING Read
+'x' -> IN Lonely(core/out)
+'y' -> IN Read OUT -> IN display(core/out)
+`
+
+func lintMessages(t *testing.T, l *Linter, src string) []string {
+	diagnostics, err := l.Lint([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, d := range diagnostics {
+		messages = append(messages, d.String())
+	}
+	return messages
+}
+
+func TestLint(t *testing.T) {
+	for _, test := range []struct {
+		linter   *Linter
+		src      string
+		expected []string
+	}{
+		{&Linter{Components: components}, lintSource, []string{
+			"1:16: warning: INPORT READ shadows process Read [export-shadows-process]",
+			"3:15: info: out-port ERROR of Read(fs/read) is never connected [unconnected-outport]",
+			"5:1: warning: ENCODING of Read already gets an IIP on line 4 [duplicate-iip]",
+			"6:11: info: out-port OUT of Lonely(core/out) is never connected [unconnected-outport]",
+			"6:11: warning: process Lonely gets only IIPs and is connected to nothing [iip-only-process]",
+			"7:1: warning: IN of Read already gets an IIP on line 3 [duplicate-iip]",
+			"7:26: info: out-port OUT of display(core/out) is never connected [unconnected-outport]",
+			"7:26: info: process name display doesn't match ^[A-Z][a-zA-Z0-9]*$ [process-name]",
+		}},
+		// rules and options from the config, without components
+		{&Linter{Config: &LintConfig{
+			Rules:   map[string]string{"duplicate-iip": "error", "export-shadows-process": "off", "iip-only-process": "off"},
+			Options: map[string]map[string]string{"process-name": {"pattern": "^[a-zA-Z]+$"}},
+		}}, lintSource, []string{
+			"5:1: error: ENCODING of Read already gets an IIP on line 4 [duplicate-iip]",
+			"7:1: error: IN of Read already gets an IIP on line 3 [duplicate-iip]",
+		}},
+		// ignored on the line and after a comment alone on its line
+		{&Linter{}, strings.NewReplacer(
+			"INPORT=Read.IN:READ\n", "INPORT=Read.IN:READ # fbp:ignore export-shadows-process\n",
+			"'utf16'", "# fbp:ignore duplicate-iip, process-name\n'utf16'",
+			"'x'", "# fbp:ignore\n'x'",
+		).Replace(lintSource), []string{
+			"9:1: warning: IN of Read already gets an IIP on line 3 [duplicate-iip]",
+			"9:26: info: process name display doesn't match ^[A-Z][a-zA-Z0-9]*$ [process-name]",
+		}},
+		// IIPs dropped by Execute() don't count
+		{&Linter{}, "A(core/a) OUT -> 'x' -> IN B(core/b)\n'y' -> IN B\n", nil},
+		// the ranges of IIPs are those with the same target and data
+		{&Linter{}, "A(core/a) OUT -> 'y' -> IN B(core/b)\n'y' -> IN B\n'x' -> IN B\n", []string{
+			"3:1: warning: IN of B already gets an IIP on line 2 [duplicate-iip]",
+		}},
+		// lower case directives
+		{&Linter{}, "inport=A.IN:A\nA(core/a) OUT -> IN B(core/b)\n", []string{
+			"1:13: warning: INPORT A shadows process A [export-shadows-process]",
+		}},
+		{&Linter{}, "inport=A.IN:A # fbp:ignore\nA(core/a) OUT -> IN B(core/b)\n", nil},
+		// ports of components in lower case
+		{&Linter{Components: testComponents{"core/lower": {{"in"}, {"out", "error"}}}}, "A(core/lower) OUT -> IN B(core/lower)\nB ERROR -> IN A\n", []string{
+			"1:1: info: out-port error of A(core/lower) is never connected [unconnected-outport]",
+			"1:25: info: out-port out of B(core/lower) is never connected [unconnected-outport]",
+		}},
+	} {
+		messages := lintMessages(t, test.linter, test.src)
+		if strings.Join(messages, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("Expected\n%s\ngot\n%s", strings.Join(test.expected, "\n"), strings.Join(messages, "\n"))
+		}
+	}
+}
+
+func TestLintGraph(t *testing.T) {
+	graph := &BaseFbp{
+		Processes: []*Process{{Name: "Read", Component: "fs/read"}, {Name: "Idle", Component: "core/out"}},
+		Connections: []*Connection{
+			{Data: "a.txt", Target: &Endpoint{Process: "Read", Port: "IN"}},
+			{Data: "b.txt", Target: &Endpoint{Process: "Read", Port: "IN"}},
+		},
+	}
+	diagnostics, err := (&Linter{}).LintGraph(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, d := range diagnostics {
+		messages = append(messages, d.String())
+	}
+	expected := "1:1: warning: process Idle has no connections [unconnected-process]\n" +
+		"1:1: warning: process Read gets only IIPs and is connected to nothing [iip-only-process]\n" +
+		"1:1: warning: IN of Read already gets an IIP [duplicate-iip]"
+	if strings.Join(messages, "\n") != expected {
+		t.Errorf("Unexpected diagnostics\n%s", strings.Join(messages, "\n"))
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	config, err := ParseLintConfig([]byte(`{"rules": {"duplicate-iip": "error", "process-name": "off"}}`))
+	if err != nil || config.Rules["duplicate-iip"] != "error" {
+		t.Fatalf("Unexpected config %v %v", config, err)
+	}
+	if _, err := ParseLintConfig([]byte(`{"rules": {"duplicate-iip": "fatal"}}`)); err == nil {
+		t.Error("Expected an error of an unknown severity")
+	}
+	config = &LintConfig{Rules: map[string]string{"no-such-rule": "off"}}
+	if _, err := (&Linter{Config: config}).Lint([]byte("'x' -> IN A(core/a)\n")); err == nil {
+		t.Error("Expected an error of an unknown rule")
+	}
+	if _, err := (&Linter{}).Lint([]byte("A OUT ->\n")); err == nil {
+		t.Error("Expected a syntax error")
+	}
+}
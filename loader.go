@@ -0,0 +1,144 @@
+package fbp
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sync"
+)
+
+//
+// Loader finds the graphs a graph refers to (composites, includes...) by
+// slash-separated path, as fs.FS has them. Sources ending with .json are
+// NoFlo JSON, the others .fbp. The graphs are parsed once for the same path
+// and content and shared, they mustn't be modified.
+//
+type Loader interface {
+	// Load returns the graph at path, the error wraps fs.ErrNotExist if
+	// there is none
+	Load(path string) (*BaseFbp, error)
+}
+
+//
+// SourceLoader is a Loader that gives the sources it parses too, as the
+// loaders of this package do
+//
+type SourceLoader interface {
+	Loader
+	// Source returns the source at path
+	Source(path string) ([]byte, error)
+}
+
+// NewFSLoader returns a loader of the files of fsys
+func NewFSLoader(fsys fs.FS) SourceLoader {
+	return newSourceLoader(func(name string) ([]byte, error) {
+		return fs.ReadFile(fsys, name)
+	})
+}
+
+// NewMapLoader returns a loader of the sources of files by path, changes to
+// files are seen by the loader
+func NewMapLoader(files map[string]string) SourceLoader {
+	return newSourceLoader(func(name string) ([]byte, error) {
+		src, ok := files[name]
+		if !ok {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		return []byte(src), nil
+	})
+}
+
+// NewSearchPathLoader returns a loader of the file at the path in the first
+// of the file systems having one, e.g. of directories with os.DirFS()
+func NewSearchPathLoader(search ...fs.FS) SourceLoader {
+	return newSourceLoader(func(name string) ([]byte, error) {
+		for _, fsys := range search {
+			data, err := fs.ReadFile(fsys, name)
+			if !errors.Is(err, fs.ErrNotExist) {
+				return data, err
+			}
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	})
+}
+
+//
+// Loader of sources read by path, the graph of a path is parsed again when
+// the hash of its source changes
+//
+type sourceLoader struct {
+	read  func(path string) ([]byte, error)
+	mutex sync.Mutex
+	cache map[string]*loadedGraph
+}
+
+type loadedGraph struct {
+	hash  [sha256.Size]byte
+	graph *BaseFbp
+}
+
+func newSourceLoader(read func(path string) ([]byte, error)) *sourceLoader {
+	return &sourceLoader{read: read, cache: make(map[string]*loadedGraph)}
+}
+
+func (l *sourceLoader) Source(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return l.read(name)
+}
+
+func (l *sourceLoader) Load(name string) (*BaseFbp, error) {
+	data, err := l.Source(name)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if loaded, ok := l.cache[name]; ok && loaded.hash == hash {
+		return loaded.graph, nil
+	}
+	graph, err := parseSource(name, data)
+	if err != nil {
+		return nil, err
+	}
+	l.cache[name] = &loadedGraph{hash: hash, graph: graph}
+	return graph, nil
+}
+
+// parseSource parses the .fbp or NoFlo JSON source of a file
+func parseSource(name string, data []byte) (*BaseFbp, error) {
+	if path.Ext(name) == ".json" {
+		graph, err := ParseJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return graph, nil
+	}
+	parser := &Fbp{Buffer: string(data)}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		if diagnostics := Check(data); len(diagnostics) > 0 {
+			return nil, fmt.Errorf("%s:%s", name, diagnostics[0])
+		}
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	parser.Execute()
+	return &parser.BaseFbp, nil
+}
+
+// LoadComponent returns the graph of component a/b at a/b.fbp or a/b.json
+// of loader and its path, nil if there is neither (the component isn't a
+// composite)
+func LoadComponent(loader Loader, name string) (*BaseFbp, string, error) {
+	for _, ext := range []string{".fbp", ".json"} {
+		graph, err := loader.Load(name + ext)
+		if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrInvalid) {
+			return graph, name + ext, err
+		}
+	}
+	return nil, "", nil
+}
@@ -0,0 +1,137 @@
+package fbp
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFSLoader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/lines.fbp": {Data: []byte("INPORT=Read.IN:FILE\nRead(fs/read) OUT -> IN Split(strings/split)\n")},
+		"app/echo.json": {Data: []byte(`{"processes": {"Out": {"component": "core/out"}}, "connections": [{"data": "hi", "tgt": {"process": "Out", "port": "IN"}}]}`)},
+		"app/bad.fbp":   {Data: []byte("Read(fs/read) OUT ->\n")},
+	}
+	loader := NewFSLoader(fsys)
+
+	graph, err := loader.Load("app/lines.fbp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Processes) != 2 || graph.Inports["FILE"] == nil {
+		t.Errorf("Unexpected graph %+v", graph)
+	}
+	again, err := loader.Load("app/lines.fbp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != graph {
+		t.Error("Expected the cached graph")
+	}
+
+	graph, err = loader.Load("app/echo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Processes) != 1 || len(graph.Connections) != 1 || graph.Connections[0].Data != "hi" {
+		t.Errorf("Unexpected graph %+v", graph)
+	}
+
+	if _, err = loader.Load("app/bad.fbp"); err == nil || !strings.HasPrefix(err.Error(), "app/bad.fbp:") {
+		t.Errorf("Expected a syntax error, got %v", err)
+	}
+	if _, err = loader.Load("app/none.fbp"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not-exist error, got %v", err)
+	}
+	if _, err = loader.Load("../app/lines.fbp"); err == nil {
+		t.Error("Expected an error for an invalid path")
+	}
+}
+
+func TestMapLoaderCache(t *testing.T) {
+	files := map[string]string{"main.fbp": "'a' -> IN Out(core/out)\n"}
+	loader := NewMapLoader(files)
+
+	first, err := loader.Load("main.fbp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := loader.Load("main.fbp")
+	if first != second {
+		t.Error("Expected the cached graph for the same content")
+	}
+
+	files["main.fbp"] = "'b' -> IN Out(core/out)\n"
+	third, err := loader.Load("main.fbp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first || third.Connections[0].Data != "b" {
+		t.Errorf("Expected the changed graph, got %+v", third.Connections[0])
+	}
+
+	delete(files, "main.fbp")
+	if _, err = loader.Load("main.fbp"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not-exist error, got %v", err)
+	}
+}
+
+func TestSearchPathLoader(t *testing.T) {
+	project := fstest.MapFS{
+		"app/lines.fbp": {Data: []byte("Read(app/read) OUT -> IN Split(strings/split)\n")},
+	}
+	library := fstest.MapFS{
+		"app/lines.fbp": {Data: []byte("Read(fs/read) OUT -> IN Split(strings/split)\n")},
+		"app/count.fbp": {Data: []byte("Lines(app/lines) LINES -> IN Count(core/count)\n")},
+	}
+	loader := NewSearchPathLoader(project, library)
+
+	graph, err := loader.Load("app/lines.fbp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if graph.Processes[0].Component != "app/read" {
+		t.Errorf("Expected the graph of the first path, got %+v", graph.Processes[0])
+	}
+	if _, err = loader.Load("app/count.fbp"); err != nil {
+		t.Error(err)
+	}
+	if _, err = loader.Load("app/none.fbp"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not-exist error, got %v", err)
+	}
+}
+
+func TestLoadComponent(t *testing.T) {
+	loader := NewMapLoader(map[string]string{
+		"app/lines.fbp": "INPORT=Read.IN:FILE\nOUTPORT=Split.OUT:LINES\nRead(fs/read) OUT -> IN Split(strings/split)\n",
+	})
+	graph := &parseGraph(t, "'a.txt' -> FILE Lines(app/lines) LINES -> IN Display(core/out)\n").BaseFbp
+	flat, err := Flatten(graph, loader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `'a.txt' -> IN Lines_Read(fs/read)
+Lines_Split(strings/split) OUT -> IN Display(core/out)
+Lines_Read OUT -> IN Lines_Split
+`
+	data, err := flat.MarshalFbp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, data)
+	}
+
+	loader = NewMapLoader(map[string]string{"app/one.json": `{"processes": {"A": {"component": "core/a"}}}`})
+	if graph, path, err := LoadComponent(loader, "app/one"); err != nil || path != "app/one.json" || len(graph.Processes) != 1 {
+		t.Errorf("Unexpected component %v %q %v", graph, path, err)
+	}
+	if graph, _, err := LoadComponent(loader, "core/a"); graph != nil || err != nil {
+		t.Errorf("Unexpected component %v %v", graph, err)
+	}
+	if src, err := loader.Source("app/one.json"); err != nil || !strings.Contains(string(src), "core/a") {
+		t.Errorf("Unexpected source %q %v", src, err)
+	}
+}
@@ -0,0 +1,109 @@
+package lsp
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/oleksandr/fbp"
+)
+
+//
+// Open text document, positions of the protocol are mapped to byte offsets
+// of text with the line starts (lines end with \n, \r\n or \r). The parser
+// re-parses the lines changed.
+//
+type document struct {
+	uri     string
+	version int
+	text    string
+	lines   []int
+	parser  *fbp.IncrementalParser
+}
+
+func newDocument(uri string, version int, text string) *document {
+	d := &document{uri: uri, version: version}
+	d.setText(text)
+	d.parser = fbp.NewIncrementalParser([]byte(text))
+	return d
+}
+
+func (d *document) setText(text string) {
+	d.text = text
+	d.lines = []int{0}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\r':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				i++
+			}
+			d.lines = append(d.lines, i+1)
+		case '\n':
+			d.lines = append(d.lines, i+1)
+		}
+	}
+}
+
+// apply replaces the range of a change (or all the text) with its text
+func (d *document) apply(change TextDocumentContentChangeEvent) {
+	if change.Range == nil {
+		d.setText(change.Text)
+		d.parser = fbp.NewIncrementalParser([]byte(change.Text))
+		return
+	}
+	begin, end := d.offset(change.Range.Start), d.offset(change.Range.End)
+	if end < begin {
+		begin, end = end, begin
+	}
+	d.setText(d.text[:begin] + change.Text + d.text[end:])
+	d.parser.Apply(fbp.Edit{Begin: begin, End: end, Text: change.Text})
+}
+
+// offset returns the byte offset of a position, positions past the end of
+// a line or of the text are clamped
+func (d *document) offset(p Position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset, end := d.lines[p.Line], d.lineEnd(p.Line)
+	for units := 0; offset < end && units < p.Character; {
+		r, size := utf8.DecodeRuneInString(d.text[offset:end])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+// position returns the position of a byte offset
+func (d *document) position(offset int) Position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := 0
+	for line+1 < len(d.lines) && d.lines[line+1] <= offset {
+		line++
+	}
+	character := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		character += len(utf16.Encode([]rune{r}))
+	}
+	return Position{Line: line, Character: character}
+}
+
+func (d *document) rangeOf(begin, end int) Range {
+	return Range{Start: d.position(begin), End: d.position(end)}
+}
+
+// lineEnd returns the offset of the line terminator of a line
+func (d *document) lineEnd(line int) int {
+	end := len(d.text)
+	if line+1 < len(d.lines) {
+		end = d.lines[line+1]
+	}
+	for end > d.lines[line] && (d.text[end-1] == '\n' || d.text[end-1] == '\r') {
+		end--
+	}
+	return end
+}
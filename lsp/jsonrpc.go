@@ -0,0 +1,101 @@
+// Package lsp implements a Language Server Protocol server for .fbp files
+// (https://microsoft.github.io/language-server-protocol/). It publishes the
+// diagnostics of fbp.Check() as the documents change and offers hover,
+// go-to-definition, find-references and rename of processes.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+	codeNotInitialized = -32002
+	codeRequestFailed  = -32803
+)
+
+//
+// JSON-RPC 2.0 message: a request (ID and Method), a notification (Method
+// only) or a response (ID with Result or Error)
+//
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+//
+// Stream of messages framed with Content-Length headers, as LSP has them
+//
+type stream struct {
+	reader *textproto.Reader
+	mutex  sync.Mutex
+	writer *bufio.Writer
+}
+
+func newStream(r io.Reader, w io.Writer) *stream {
+	return &stream{
+		reader: textproto.NewReader(bufio.NewReader(r)),
+		writer: bufio.NewWriter(w),
+	}
+}
+
+// read returns the next message, io.EOF at the end of the stream
+func (s *stream) read() (*message, error) {
+	header, err := s.reader.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("lsp: invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.reader.R, body); err != nil {
+		return nil, err
+	}
+	m := &message{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: err.Error()}
+	}
+	return m, nil
+}
+
+func (s *stream) write(m *message) error {
+	m.JSONRPC = "2.0"
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n", len(data))
+	s.writer.Write(data)
+	return s.writer.Flush()
+}
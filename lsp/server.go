@@ -0,0 +1,395 @@
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/oleksandr/fbp"
+)
+
+// ErrNoShutdown is returned by Serve if the client exits without shutdown
+var ErrNoShutdown = errors.New("lsp: exit without shutdown")
+
+type handler func(s *Server, params json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":              initialize,
+	"initialized":             ignore,
+	"shutdown":                shutdown,
+	"textDocument/didOpen":    didOpen,
+	"textDocument/didChange":  didChange,
+	"textDocument/didClose":   didClose,
+	"textDocument/didSave":    ignore,
+	"textDocument/hover":      hover,
+	"textDocument/definition": definition,
+	"textDocument/references": references,
+	"textDocument/rename":     rename,
+	"textDocument/completion": completion,
+	"$/cancelRequest":         ignore,
+	"$/setTrace":              ignore,
+}
+
+//
+// Server of the Language Server Protocol for .fbp documents. Messages are
+// handled one at a time in the order they come.
+//
+type Server struct {
+	// Name and Version reported to the client
+	Name    string
+	Version string
+	// Components completed inside "(" and whose ports are completed, e.g.
+	// runtime.DefaultRegistry (processes only if nil)
+	Components fbp.Components
+
+	mutex       sync.Mutex
+	stream      *stream
+	documents   map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer returns a server with no open documents
+func NewServer() *Server {
+	return &Server{
+		Name:      "fbp",
+		documents: make(map[string]*document),
+	}
+}
+
+// Serve handles the messages read from r and writes the replies to w, e.g.
+// os.Stdin and os.Stdout. It returns at the exit notification or the end
+// of r, ErrNoShutdown if the client exits without shutdown first.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.stream = newStream(r, w)
+	for {
+		m, err := s.stream.read()
+		if err == io.EOF {
+			return nil
+		}
+		if e, ok := err.(*responseError); ok {
+			if err := s.stream.write(&message{ID: nullID(), Error: e}); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if m.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+		if err := s.handle(m); err != nil {
+			return err
+		}
+	}
+}
+
+// handle calls the handler of a request or notification and replies to
+// requests, notifications have no replies even if they fail
+func (s *Server) handle(m *message) error {
+	var result interface{}
+	var err error
+	h, ok := handlers[m.Method]
+	switch {
+	case m.Method == "":
+		err = &responseError{Code: codeInvalidRequest, Message: "no method"}
+	case !s.initialized && m.Method != "initialize":
+		err = &responseError{Code: codeNotInitialized, Message: "server not initialized"}
+	case s.shutdown:
+		err = &responseError{Code: codeInvalidRequest, Message: "server is shut down"}
+	case !ok:
+		err = &responseError{Code: codeMethodNotFound, Message: "method not found: " + m.Method}
+	default:
+		result, err = h(s, m.Params)
+	}
+	if m.ID == nil {
+		return nil
+	}
+	reply := &message{ID: m.ID}
+	if err != nil {
+		e, ok := err.(*responseError)
+		if !ok {
+			e = &responseError{Code: codeRequestFailed, Message: err.Error()}
+		}
+		reply.Error = e
+	} else if reply.Result, err = json.Marshal(result); err != nil {
+		return err
+	}
+	return s.stream.write(reply)
+}
+
+// notify sends a notification to the client
+func (s *Server) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.stream.write(&message{Method: method, Params: data})
+}
+
+func nullID() *json.RawMessage {
+	id := json.RawMessage("null")
+	return &id
+}
+
+func decode(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func ignore(s *Server, params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func initialize(s *Server, params json.RawMessage) (interface{}, error) {
+	s.initialized = true
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:   SyncIncremental,
+			HoverProvider:      true,
+			DefinitionProvider: true,
+			ReferencesProvider: true,
+			RenameProvider:     true,
+			CompletionProvider: &CompletionOptions{TriggerCharacters: []string{"(", ".", ">"}},
+		},
+		ServerInfo: &ServerInfo{Name: s.Name, Version: s.Version},
+	}, nil
+}
+
+func shutdown(s *Server, params json.RawMessage) (interface{}, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func didOpen(s *Server, params json.RawMessage) (interface{}, error) {
+	p := &DidOpenTextDocumentParams{}
+	if err := decode(params, p); err != nil {
+		return nil, err
+	}
+	d := newDocument(p.TextDocument.URI, p.TextDocument.Version, p.TextDocument.Text)
+	s.mutex.Lock()
+	s.documents[d.uri] = d
+	s.mutex.Unlock()
+	return nil, s.publishDiagnostics(d)
+}
+
+func didChange(s *Server, params json.RawMessage) (interface{}, error) {
+	p := &DidChangeTextDocumentParams{}
+	if err := decode(params, p); err != nil {
+		return nil, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range p.ContentChanges {
+		d.apply(change)
+	}
+	d.version = p.TextDocument.Version
+	return nil, s.publishDiagnostics(d)
+}
+
+func didClose(s *Server, params json.RawMessage) (interface{}, error) {
+	p := &DidCloseTextDocumentParams{}
+	if err := decode(params, p); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	delete(s.documents, p.TextDocument.URI)
+	s.mutex.Unlock()
+	return nil, s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         p.TextDocument.URI,
+		Diagnostics: []*Diagnostic{},
+	})
+}
+
+// publishDiagnostics sends the diagnostics of fbp.Check() for a document
+func (s *Server) publishDiagnostics(d *document) error {
+	diagnostics := []*Diagnostic{}
+	for _, c := range d.parser.Diagnostics() {
+		diagnostics = append(diagnostics, &Diagnostic{
+			Range:    d.rangeOf(c.Begin, c.End),
+			Severity: severity(c.Severity),
+			Source:   "fbp",
+			Message:  c.Message,
+		})
+	}
+	version := d.version
+	return s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         d.uri,
+		Version:     &version,
+		Diagnostics: diagnostics,
+	})
+}
+
+func severity(s fbp.Severity) int {
+	switch s {
+	case fbp.SeverityError:
+		return SeverityError
+	case fbp.SeverityWarning:
+		return SeverityWarning
+	}
+	return SeverityInformation
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.documents[uri]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: "unknown document " + uri}
+	}
+	return d, nil
+}
+
+// symbolAt returns the document, its symbols and the process name at a
+// position, the symbol is nil if there is none or the document has errors
+func (s *Server) symbolAt(p *TextDocumentPositionParams) (*document, *fbp.Symbols, *fbp.Symbol, error) {
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	symbols, err := fbp.ParseSymbols([]byte(d.text))
+	if err != nil {
+		return d, nil, nil, nil
+	}
+	return d, symbols, symbols.At(d.offset(p.Position)), nil
+}
+
+func hover(s *Server, params json.RawMessage) (interface{}, error) {
+	p := &TextDocumentPositionParams{}
+	if err := decode(params, p); err != nil {
+		return nil, err
+	}
+	d, symbols, symbol, err := s.symbolAt(p)
+	if err != nil || symbol == nil {
+		return nil, err
+	}
+	var text string
+	if declaration := symbols.Declaration(symbol.Name); declaration != nil {
+		text = fmt.Sprintf("```fbp\n%s(%s)\n```\n", declaration.Name, declaration.Component)
+		if declaration.Metadata != "" {
+			text += "\nMetadata: `" + strings.Replace(declaration.Metadata, ",", "`, `", -1) + "`\n"
+		}
+		text += fmt.Sprintf("\nDeclared on line %d", d.position(declaration.Begin).Line+1)
+	} else {
+		text = fmt.Sprintf("```fbp\n%s\n```\n\nProcess without a component", symbol.Name)
+	}
+	r := d.rangeOf(symbol.Begin, symbol.End)
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: &r}, nil
+}
+
+func definition(s *Server, params json.RawMessage) (interface{}, error) {
+	p := &TextDocumentPositionParams{}
+	if err := decode(params, p); err != nil {
+		return nil, err
+	}
+	d, symbols, symbol, err := s.symbolAt(p)
+	if err != nil || symbol == nil {
+		return nil, err
+	}
+	declaration := symbols.Declaration(symbol.Name)
+	if declaration == nil {
+		// no component anywhere, the first occurrence defines the process
+		declaration = symbols.References(symbol.Name)[0]
+	}
+	return &Location{URI: d.uri, Range: d.rangeOf(declaration.Begin, declaration.End)}, nil
+}
+
+func references(s *Server, params json.RawMessage) (interface{}, error) {
+	p := &ReferenceParams{}
+	if err := decode(params, p); err != nil {
+		return nil, err
+	}
+	d, symbols, symbol, err := s.symbolAt(&p.TextDocumentPositionParams)
+	if err != nil || symbol == nil {
+		return nil, err
+	}
+	declaration := symbols.Declaration(symbol.Name)
+	locations := []*Location{}
+	for _, r := range symbols.References(symbol.Name) {
+		if r == declaration && !p.Context.IncludeDeclaration {
+			continue
+		}
+		locations = append(locations, &Location{URI: d.uri, Range: d.rangeOf(r.Begin, r.End)})
+	}
+	return locations, nil
+}
+
+// rename replaces all occurrences of a process, the new name must not be
+// taken and the renamed document must parse to the same occurrences
+func rename(s *Server, params json.RawMessage) (interface{}, error) {
+	p := &RenameParams{}
+	if err := decode(params, p); err != nil {
+		return nil, err
+	}
+	d, symbols, symbol, err := s.symbolAt(&p.TextDocumentPositionParams)
+	if err != nil {
+		return nil, err
+	}
+	if symbol == nil {
+		return nil, errors.New("no process to rename")
+	}
+	if !fbp.ValidProcessName(p.NewName) {
+		return nil, fmt.Errorf("invalid process name %q", p.NewName)
+	}
+	if p.NewName != symbol.Name {
+		for _, name := range symbols.Names() {
+			if name == p.NewName {
+				return nil, fmt.Errorf("process %s already exists", p.NewName)
+			}
+		}
+	}
+
+	occurrences := symbols.References(symbol.Name)
+	edits := []TextEdit{}
+	var text []string
+	last := 0
+	for _, r := range occurrences {
+		edits = append(edits, TextEdit{Range: d.rangeOf(r.Begin, r.End), NewText: p.NewName})
+		text = append(text, d.text[last:r.Begin], p.NewName)
+		last = r.End
+	}
+	text = append(text, d.text[last:])
+	renamed, err := fbp.ParseSymbols([]byte(strings.Join(text, "")))
+	if err != nil || len(renamed.References(p.NewName)) != len(occurrences) {
+		return nil, fmt.Errorf("renaming %s to %s changes the graph", symbol.Name, p.NewName)
+	}
+	return &WorkspaceEdit{Changes: map[string][]TextEdit{d.uri: edits}}, nil
+}
+
+var completionKinds = map[fbp.CompletionKind]int{
+	fbp.CompletionProcess:   CompletionItemVariable,
+	fbp.CompletionComponent: CompletionItemClass,
+	fbp.CompletionInPort:    CompletionItemField,
+	fbp.CompletionOutPort:   CompletionItemField,
+}
+
+func completion(s *Server, params json.RawMessage) (interface{}, error) {
+	p := &TextDocumentPositionParams{}
+	if err := decode(params, p); err != nil {
+		return nil, err
+	}
+	d, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	list := &CompletionList{Items: []*CompletionItem{}}
+	for _, c := range d.parser.Complete(d.offset(p.Position), s.Components) {
+		list.Items = append(list.Items, &CompletionItem{
+			Label:    c.Label,
+			Kind:     completionKinds[c.Kind],
+			Detail:   c.Detail,
+			TextEdit: &TextEdit{Range: d.rangeOf(c.Begin, c.End), NewText: c.Label},
+		})
+	}
+	return list, nil
+}
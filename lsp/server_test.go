@@ -0,0 +1,295 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const uri = "file:///graph.fbp"
+
+// session runs the server with messages (requests if they have "id") and
+// returns the messages it wrote
+func session(t *testing.T, messages ...string) []*message {
+	var in, out bytes.Buffer
+	for _, m := range messages {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	if err := NewServer().Serve(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+	var replies []*message
+	s := newStream(&out, nil)
+	for {
+		m, err := s.read()
+		if err != nil {
+			break
+		}
+		replies = append(replies, m)
+	}
+	return replies
+}
+
+func request(id int, method string, params interface{}) string {
+	data, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	return string(data)
+}
+
+func notification(method string, params interface{}) string {
+	data, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+	return string(data)
+}
+
+func open(text string) []string {
+	return []string{
+		request(1, "initialize", map[string]interface{}{}),
+		notification("initialized", map[string]interface{}{}),
+		notification("textDocument/didOpen", &DidOpenTextDocumentParams{
+			TextDocument: TextDocumentItem{URI: uri, LanguageID: "fbp", Version: 1, Text: text},
+		}),
+	}
+}
+
+func at(line, character int) *TextDocumentPositionParams {
+	return &TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+// reply returns the result of the reply to request id decoded into v
+func reply(t *testing.T, replies []*message, id int, v interface{}) *responseError {
+	for _, m := range replies {
+		if m.ID != nil && string(*m.ID) == fmt.Sprint(id) {
+			if m.Error != nil {
+				return m.Error
+			}
+			if err := json.Unmarshal(m.Result, v); err != nil {
+				t.Fatal(err)
+			}
+			return nil
+		}
+	}
+	t.Fatalf("No reply to %d", id)
+	return nil
+}
+
+func diagnostics(t *testing.T, replies []*message) [][]*Diagnostic {
+	var all [][]*Diagnostic
+	for _, m := range replies {
+		if m.Method == "textDocument/publishDiagnostics" {
+			p := &PublishDiagnosticsParams{}
+			if err := json.Unmarshal(m.Params, p); err != nil {
+				t.Fatal(err)
+			}
+			all = append(all, p.Diagnostics)
+		}
+	}
+	return all
+}
+
+const graph = `# hello
+'x' -> IN Read(fs/read:label=Read)
+Read OUT -> IN Display(core/out)
+INPORT=Read.FILE:FILE
+`
+
+func TestLifecycle(t *testing.T) {
+	replies := session(t,
+		request(1, "textDocument/hover", at(0, 0)),
+		request(2, "initialize", map[string]interface{}{}),
+		request(3, "unknown/method", nil),
+		request(4, "shutdown", nil),
+		request(5, "textDocument/hover", at(0, 0)),
+		notification("exit", nil),
+	)
+	var initialized InitializeResult
+	if e := reply(t, replies, 1, nil); e == nil || e.Code != codeNotInitialized {
+		t.Fatalf("Unexpected error %v", e)
+	}
+	if e := reply(t, replies, 2, &initialized); e != nil || !initialized.Capabilities.RenameProvider {
+		t.Fatalf("Unexpected reply %+v %v", initialized, e)
+	}
+	if e := reply(t, replies, 3, nil); e == nil || e.Code != codeMethodNotFound {
+		t.Fatalf("Unexpected error %v", e)
+	}
+	if e := reply(t, replies, 5, nil); e == nil || e.Code != codeInvalidRequest {
+		t.Fatalf("Unexpected error %v", e)
+	}
+
+	var in, out bytes.Buffer
+	fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(`{"jsonrpc":"2.0","method":"exit"}`), `{"jsonrpc":"2.0","method":"exit"}`)
+	if err := NewServer().Serve(&in, &out); err != ErrNoShutdown {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	messages := open("A(core/a) OUT -> IN B\n")
+	messages = append(messages,
+		notification("textDocument/didChange", &DidChangeTextDocumentParams{
+			TextDocument: VersionedTextDocumentIdentifier{URI: uri, Version: 2},
+			ContentChanges: []TextDocumentContentChangeEvent{
+				{Range: &Range{Start: Position{0, 21}, End: Position{0, 21}}, Text: "(core/b"},
+			},
+		}),
+		notification("textDocument/didChange", &DidChangeTextDocumentParams{
+			TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: 3},
+			ContentChanges: []TextDocumentContentChangeEvent{{Range: &Range{Start: Position{0, 28}, End: Position{0, 28}}, Text: ")"}},
+		}),
+		notification("textDocument/didClose", &DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}}),
+	)
+	all := diagnostics(t, session(t, messages...))
+	if len(all) != 4 {
+		t.Fatalf("Unexpected diagnostics %v", all)
+	}
+	warning := &Diagnostic{Range: Range{Position{0, 20}, Position{0, 21}}, Severity: SeverityWarning, Source: "fbp", Message: "process B has no component"}
+	if len(all[0]) != 1 || !reflect.DeepEqual(all[0][0], warning) {
+		t.Fatalf("Unexpected diagnostics %+v", all[0])
+	}
+	if len(all[1]) != 1 || all[1][0].Severity != SeverityError || all[1][0].Range.Start.Line != 0 {
+		t.Fatalf("Unexpected diagnostics %+v", all[1])
+	}
+	if len(all[2]) != 0 || len(all[3]) != 0 {
+		t.Fatalf("Unexpected diagnostics %+v %+v", all[2], all[3])
+	}
+}
+
+func TestHoverAndDefinition(t *testing.T) {
+	messages := append(open(graph),
+		request(2, "textDocument/hover", at(2, 2)),
+		request(3, "textDocument/definition", at(3, 9)),
+		request(4, "textDocument/hover", at(0, 3)),
+	)
+	replies := session(t, messages...)
+
+	var h Hover
+	if e := reply(t, replies, 2, &h); e != nil {
+		t.Fatal(e)
+	}
+	if !strings.Contains(h.Contents.Value, "Read(fs/read)") || !strings.Contains(h.Contents.Value, "`label=Read`") ||
+		!reflect.DeepEqual(h.Range, &Range{Position{2, 0}, Position{2, 4}}) {
+		t.Fatalf("Unexpected hover %+v", h)
+	}
+	var l Location
+	if e := reply(t, replies, 3, &l); e != nil {
+		t.Fatal(e)
+	}
+	if l.URI != uri || l.Range != (Range{Position{1, 10}, Position{1, 14}}) {
+		t.Fatalf("Unexpected definition %+v", l)
+	}
+	var none *Hover
+	if e := reply(t, replies, 4, &none); e != nil || none != nil {
+		t.Fatalf("Unexpected hover %+v", none)
+	}
+}
+
+func TestReferencesAndRename(t *testing.T) {
+	references := &ReferenceParams{TextDocumentPositionParams: *at(2, 0)}
+	references.Context.IncludeDeclaration = true
+	messages := append(open(graph),
+		request(2, "textDocument/references", references),
+		request(3, "textDocument/rename", &RenameParams{TextDocumentPositionParams: *at(1, 12), NewName: "Reader"}),
+		request(4, "textDocument/rename", &RenameParams{TextDocumentPositionParams: *at(1, 12), NewName: "Display"}),
+		request(5, "textDocument/rename", &RenameParams{TextDocumentPositionParams: *at(1, 12), NewName: "Read er"}),
+	)
+	replies := session(t, messages...)
+
+	var locations []Location
+	if e := reply(t, replies, 2, &locations); e != nil {
+		t.Fatal(e)
+	}
+	expected := []Location{
+		{uri, Range{Position{1, 10}, Position{1, 14}}},
+		{uri, Range{Position{2, 0}, Position{2, 4}}},
+		{uri, Range{Position{3, 7}, Position{3, 11}}},
+	}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Fatalf("Unexpected references %+v", locations)
+	}
+
+	var edit WorkspaceEdit
+	if e := reply(t, replies, 3, &edit); e != nil {
+		t.Fatal(e)
+	}
+	d := newDocument(uri, 1, graph)
+	edits := edit.Changes[uri]
+	for i := len(edits) - 1; i >= 0; i-- {
+		d.apply(TextDocumentContentChangeEvent{Range: &edits[i].Range, Text: edits[i].NewText})
+	}
+	if d.text != strings.Replace(strings.Replace(graph, "Read", "Reader", -1), "label=Reader", "label=Read", 1) {
+		t.Fatalf("Unexpected rename %q", d.text)
+	}
+	for _, id := range []int{4, 5} {
+		if e := reply(t, replies, id, nil); e == nil || e.Code != codeRequestFailed {
+			t.Fatalf("Unexpected error %v", e)
+		}
+	}
+}
+
+func TestRenameLowerCaseDirective(t *testing.T) {
+	src := "inport=A.IN:X\nA(core/a) OUT -> IN B(core/b)\n"
+	replies := session(t, append(open(src),
+		request(2, "textDocument/rename", &RenameParams{TextDocumentPositionParams: *at(1, 0), NewName: "C"}),
+	)...)
+	var edit WorkspaceEdit
+	if e := reply(t, replies, 2, &edit); e != nil {
+		t.Fatal(e)
+	}
+	d := newDocument(uri, 1, src)
+	edits := edit.Changes[uri]
+	for i := len(edits) - 1; i >= 0; i-- {
+		d.apply(TextDocumentContentChangeEvent{Range: &edits[i].Range, Text: edits[i].NewText})
+	}
+	if d.text != "inport=C.IN:X\nC(core/a) OUT -> IN B(core/b)\n" {
+		t.Fatalf("Unexpected rename %q", d.text)
+	}
+}
+
+func TestDocumentPositions(t *testing.T) {
+	d := newDocument(uri, 1, "a\r\n'ü𝄞' -> IN B\rC")
+	for _, test := range []struct {
+		position Position
+		offset   int
+	}{
+		{Position{0, 0}, 0},
+		{Position{0, 1}, 1},
+		{Position{1, 0}, 3},
+		{Position{1, 2}, 6},  // after ü (2 bytes, 1 unit)
+		{Position{1, 4}, 10}, // after 𝄞 (4 bytes, 2 units)
+		{Position{2, 0}, 20},
+		{Position{2, 1}, 21},
+	} {
+		if offset := d.offset(test.position); offset != test.offset {
+			t.Errorf("Offset of %v: expected %d, got %d", test.position, test.offset, offset)
+		}
+		if position := d.position(test.offset); position != test.position {
+			t.Errorf("Position of %d: expected %v, got %v", test.offset, test.position, position)
+		}
+	}
+	if offset := d.offset(Position{0, 10}); offset != 1 {
+		t.Errorf("Unexpected clamped offset %d", offset)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	messages := append(open(graph+"Read OUT -> IN D"),
+		request(2, "textDocument/completion", at(4, 16)),
+	)
+	var list CompletionList
+	if e := reply(t, session(t, messages...), 2, &list); e != nil {
+		t.Fatal(e)
+	}
+	expected := []*CompletionItem{{
+		Label:    "Display",
+		Kind:     CompletionItemVariable,
+		Detail:   "core/out",
+		TextEdit: &TextEdit{Range: Range{Position{4, 15}, Position{4, 16}}, NewText: "Display"},
+	}}
+	if !reflect.DeepEqual(list.Items, expected) {
+		t.Fatalf("Unexpected completions %+v", list.Items)
+	}
+}
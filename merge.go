@@ -0,0 +1,352 @@
+package fbp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// Kind of a merge conflict
+//
+type ConflictKind int
+
+const (
+	// process given different components or metadata, changed on one side
+	// and removed on the other, or removed on one side and connected by a
+	// connection or IIP the other added
+	ConflictProcess ConflictKind = iota + 1
+	// IIP given different values, or changed on one side and removed on
+	// the other
+	ConflictIIP
+	// in-port exported from different processes or ports
+	ConflictInport
+	// out-port exported from different processes or ports
+	ConflictOutport
+	// connection given different metadata, or changed on one side and
+	// removed on the other
+	ConflictConnection
+	// graph property given different values
+	ConflictProperty
+	// group given different processes or metadata
+	ConflictGroup
+)
+
+func (k ConflictKind) String() string {
+	switch k {
+	case ConflictProcess:
+		return "process"
+	case ConflictIIP:
+		return "iip"
+	case ConflictInport:
+		return "inport"
+	case ConflictOutport:
+		return "outport"
+	case ConflictConnection:
+		return "connection"
+	case ConflictProperty:
+		return "property"
+	case ConflictGroup:
+		return "group"
+	}
+	return "unknown"
+}
+
+//
+// Conflict is a change both sides made differently. Name is the process,
+// the target of the IIP ("IN Process"), the connection, the exported port,
+// the property or the group. Base, Ours and Theirs are the versions as .fbp
+// has them (Process(component:meta), 'iip' or Process.PORT, followed by the
+// metadata of a connection in parentheses), a quoted property value or the
+// processes of a group in brackets, empty where there is none. A process
+// removed on one side has the connection the other side added to it.
+//
+type Conflict struct {
+	Kind               ConflictKind
+	Name               string
+	Base, Ours, Theirs string
+}
+
+func (c *Conflict) String() string {
+	version := func(v string) string {
+		if v == "" {
+			return "none"
+		}
+		return v
+	}
+	return fmt.Sprintf("%s %s: base %s, ours %s, theirs %s", c.Kind, c.Name, version(c.Base), version(c.Ours), version(c.Theirs))
+}
+
+// Merge merges the changes ours and theirs made to base. What one side
+// changed and the other didn't is taken, as are the same changes made on
+// both sides. Changes made differently are conflicts, the merged graph has
+// ours for them: a process removed by theirs stays if ours added a
+// connection to it, a connection theirs added to a process ours removed is
+// left out. Connections (IIPs included) are kept in the order of ours
+// followed by those added by theirs, and so are processes and groups.
+// Processes, connections with their metadata, exported ports, properties
+// and groups are merged, Subgraph is that of ours.
+func Merge(base, ours, theirs *BaseFbp) (*BaseFbp, []*Conflict) {
+	merged := &BaseFbp{Subgraph: ours.Subgraph}
+	var conflicts []*Conflict
+
+	processes, found := mergeItems(processItems(base), processItems(ours), processItems(theirs))
+	conflicts = append(conflicts, found...)
+	connections, found := mergeItems(connectionItems(base), connectionItems(ours), connectionItems(theirs))
+	conflicts = append(conflicts, found...)
+	processes, connections, found = mergeRemoved(base, ours, theirs, processes, connections)
+	conflicts = append(conflicts, found...)
+	for _, item := range processes {
+		merged.Processes = append(merged.Processes, item.value.(*Process))
+	}
+	for _, item := range connections {
+		merged.Connections = append(merged.Connections, item.value.(*Connection))
+	}
+
+	for _, graph := range []*BaseFbp{ours, theirs} {
+		for name := range graph.named {
+			merged.name(name)
+		}
+	}
+
+	merged.Inports, found = mergePorts(ConflictInport, base.Inports, ours.Inports, theirs.Inports)
+	conflicts = append(conflicts, found...)
+	merged.Outports, found = mergePorts(ConflictOutport, base.Outports, ours.Outports, theirs.Outports)
+	conflicts = append(conflicts, found...)
+	merged.Properties, found = mergeProperties(base.Properties, ours.Properties, theirs.Properties)
+	conflicts = append(conflicts, found...)
+
+	items, found := mergeItems(groupItems(base), groupItems(ours), groupItems(theirs))
+	conflicts = append(conflicts, found...)
+	for _, item := range items {
+		merged.Groups = append(merged.Groups, item.value.(*Group))
+	}
+	return merged, conflicts
+}
+
+//
+// Element of a graph to merge, key identifies it and text is the version
+// compared
+//
+type mergeItem struct {
+	kind  ConflictKind
+	key   string
+	name  string
+	text  string
+	value interface{}
+}
+
+// mergeItems returns the merged items in the order of ours followed by
+// those of theirs
+func mergeItems(base, ours, theirs []*mergeItem) ([]*mergeItem, []*Conflict) {
+	byKey := func(items []*mergeItem) map[string]*mergeItem {
+		m := make(map[string]*mergeItem, len(items))
+		for _, item := range items {
+			m[item.key] = item
+		}
+		return m
+	}
+	b, o, t := byKey(base), byKey(ours), byKey(theirs)
+	text := func(item *mergeItem) string {
+		if item == nil {
+			return ""
+		}
+		return item.text
+	}
+
+	var merged []*mergeItem
+	var conflicts []*Conflict
+	seen := make(map[string]bool)
+	for _, item := range append(append([]*mergeItem{}, ours...), theirs...) {
+		if seen[item.key] {
+			continue
+		}
+		seen[item.key] = true
+		bi, oi, ti := b[item.key], o[item.key], t[item.key]
+		var chosen *mergeItem
+		switch {
+		case text(oi) == text(ti):
+			chosen = oi
+		case text(oi) == text(bi):
+			chosen = ti
+		case text(ti) == text(bi):
+			chosen = oi
+		default:
+			chosen = oi
+			conflicts = append(conflicts, &Conflict{
+				Kind:   item.kind,
+				Name:   item.name,
+				Base:   text(bi),
+				Ours:   text(oi),
+				Theirs: text(ti),
+			})
+		}
+		if chosen != nil {
+			merged = append(merged, chosen)
+		}
+	}
+	return merged, conflicts
+}
+
+func processItems(graph *BaseFbp) []*mergeItem {
+	items := make([]*mergeItem, len(graph.Processes))
+	for i, p := range graph.Processes {
+		items[i] = &mergeItem{kind: ConflictProcess, key: p.Name, name: p.Name, text: formatProcess(p), value: p}
+	}
+	return items
+}
+
+// connectionItems returns the connections keyed by their endpoints and the
+// IIPs by their target, the n-th one of a key gets "#n"
+func connectionItems(graph *BaseFbp) []*mergeItem {
+	var items []*mergeItem
+	occurrences := make(map[string]int)
+	for _, c := range graph.Connections {
+		if c.Target == nil {
+			continue
+		}
+		item := &mergeItem{value: c}
+		if c.Source == nil {
+			item.kind = ConflictIIP
+			item.name = formatPort(c.Target) + " " + c.Target.Process
+			item.key = "-> " + item.name
+			item.text = withMetadata(formatIIP(c.Data), c.Metadata)
+		} else {
+			item.kind = ConflictConnection
+			item.name = formatConnection(c)
+			item.key = item.name
+			item.text = withMetadata(item.name, c.Metadata)
+		}
+		occurrences[item.key]++
+		item.key += "#" + strconv.Itoa(occurrences[item.key])
+		items = append(items, item)
+	}
+	return items
+}
+
+func mergePorts(kind ConflictKind, base, ours, theirs map[string]*Endpoint) (map[string]*Endpoint, []*Conflict) {
+	items := func(ports map[string]*Endpoint) []*mergeItem {
+		var items []*mergeItem
+		for _, name := range sortedPorts(ports) {
+			items = append(items, &mergeItem{kind: kind, key: name, name: name, text: endpointKey(ports[name]), value: ports[name]})
+		}
+		return items
+	}
+	merged, conflicts := mergeItems(items(base), items(ours), items(theirs))
+	if len(merged) == 0 && ours == nil {
+		return nil, conflicts
+	}
+	ports := make(map[string]*Endpoint, len(merged))
+	for _, item := range merged {
+		ports[item.name] = item.value.(*Endpoint)
+	}
+	return ports, conflicts
+}
+
+// mergeRemoved looks for the connections added to a process the other side
+// removed. Ours is kept: the process if ours added the connection, else
+// the connection is left out.
+func mergeRemoved(base, ours, theirs *BaseFbp, processes, connections []*mergeItem) ([]*mergeItem, []*mergeItem, []*Conflict) {
+	byName := func(graph *BaseFbp) map[string]*Process {
+		m := make(map[string]*Process, len(graph.Processes))
+		for _, p := range graph.Processes {
+			m[p.Name] = p
+		}
+		return m
+	}
+	keys := func(graph *BaseFbp) map[string]bool {
+		m := make(map[string]bool)
+		for _, item := range connectionItems(graph) {
+			m[item.key] = true
+		}
+		return m
+	}
+	baseProcesses, ourProcesses, theirProcesses := byName(base), byName(ours), byName(theirs)
+	baseKeys, ourKeys := keys(base), keys(ours)
+	kept := make(map[string]bool, len(processes))
+	for _, item := range processes {
+		kept[item.key] = true
+	}
+
+	var merged []*mergeItem
+	var conflicts []*Conflict
+	for _, item := range connections {
+		c := item.value.(*Connection)
+		drop := false
+		for _, e := range []*Endpoint{c.Source, c.Target} {
+			if e == nil || baseKeys[item.key] || kept[e.Process] {
+				continue
+			}
+			b, ok := baseProcesses[e.Process]
+			if !ok {
+				continue
+			}
+			conflict := &Conflict{Kind: ConflictProcess, Name: e.Process, Base: formatProcess(b)}
+			if ourKeys[item.key] {
+				p, ok := ourProcesses[e.Process]
+				if !ok {
+					continue
+				}
+				conflict.Ours = formatConnection(c)
+				processes = append(processes, &mergeItem{kind: ConflictProcess, key: p.Name, name: p.Name, text: formatProcess(p), value: p})
+				kept[p.Name] = true
+			} else {
+				if _, ok := theirProcesses[e.Process]; !ok {
+					continue
+				}
+				conflict.Theirs = formatConnection(c)
+				drop = true
+			}
+			conflicts = append(conflicts, conflict)
+			if drop {
+				break
+			}
+		}
+		if !drop {
+			merged = append(merged, item)
+		}
+	}
+	return processes, merged, conflicts
+}
+
+func mergeProperties(base, ours, theirs map[string]string) (map[string]string, []*Conflict) {
+	items := func(properties map[string]string) []*mergeItem {
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		items := make([]*mergeItem, len(names))
+		for i, name := range names {
+			items[i] = &mergeItem{kind: ConflictProperty, key: name, name: name, text: strconv.Quote(properties[name]), value: properties[name]}
+		}
+		return items
+	}
+	merged, conflicts := mergeItems(items(base), items(ours), items(theirs))
+	if len(merged) == 0 && ours == nil {
+		return nil, conflicts
+	}
+	properties := make(map[string]string, len(merged))
+	for _, item := range merged {
+		properties[item.name] = item.value.(string)
+	}
+	return properties, conflicts
+}
+
+func groupItems(graph *BaseFbp) []*mergeItem {
+	items := make([]*mergeItem, len(graph.Groups))
+	for i, g := range graph.Groups {
+		text := withMetadata("["+strings.Join(g.Nodes, " ")+"]", g.Metadata)
+		items[i] = &mergeItem{kind: ConflictGroup, key: g.Name, name: g.Name, text: text, value: g}
+	}
+	return items
+}
+
+// withMetadata returns text followed by the metadata in parentheses, if any
+func withMetadata(text string, metadata map[string]string) string {
+	if len(metadata) == 0 {
+		return text
+	}
+	return text + " (" + formatMetadata(metadata)[1:] + ")"
+}
@@ -0,0 +1,164 @@
+package fbp
+
+import (
+	"strings"
+	"testing"
+)
+
+const mergeBase = `INPORT=Read.SOURCE:FILENAME
+Read(core/read) OUT -> IN Split(strings/split)
+'\n' -> DELIMITER Split
+Split OUT -> IN Count(core/count)
+'x' -> OPTIONS Count
+Count OUT -> IN Display(core/output)
+`
+
+func TestMerge(t *testing.T) {
+	base := &parseGraph(t, mergeBase).BaseFbp
+	// ours adds a logger, theirs drops an IIP, prints the count and exports it
+	ours := &parseGraph(t, mergeBase+"Count ERROR -> IN Log(core/log)\n").BaseFbp
+	theirs := &parseGraph(t, strings.NewReplacer(
+		"'x' -> OPTIONS Count\n", "",
+		"core/output", "core/print",
+	).Replace(mergeBase)+"OUTPORT=Display.OUT:OUT\n").BaseFbp
+
+	merged, conflicts := Merge(base, ours, theirs)
+	if len(conflicts) != 0 {
+		t.Fatalf("Unexpected conflicts %v", conflicts)
+	}
+	expected := `INPORT=Read.SOURCE:FILENAME
+OUTPORT=Display.OUT:OUT
+Read(core/read) OUT -> IN Split(strings/split)
+'\n' -> DELIMITER Split
+Split OUT -> IN Count(core/count)
+Count OUT -> IN Display(core/print)
+Count ERROR -> IN Log(core/log)
+`
+	data, err := merged.MarshalFbp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, data)
+	}
+	if d := Diff(merged, &parseGraph(t, string(data)).BaseFbp); !d.Empty() {
+		t.Errorf("Merged graph doesn't parse back:\n%s", d)
+	}
+
+	// the same changes on both sides
+	if merged, conflicts := Merge(base, ours, ours); len(conflicts) != 0 || !Diff(merged, ours).Empty() {
+		t.Errorf("Unexpected merge of the same changes %v %v", conflicts, Diff(merged, ours))
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	base := &parseGraph(t, mergeBase).BaseFbp
+	ours := &parseGraph(t, strings.NewReplacer(
+		"core/count", "core/count:fast",
+		`'\n'`, "','",
+		"Read.SOURCE", "Split.IN",
+	).Replace(mergeBase)).BaseFbp
+	theirs := &parseGraph(t, strings.NewReplacer(
+		"core/count", "core/counter",
+		`'\n'`, "';'",
+		"'x' -> OPTIONS Count\n", "",
+		"Read.SOURCE", "Read.PATH",
+	).Replace(mergeBase)).BaseFbp
+
+	merged, conflicts := Merge(base, ours, theirs)
+	expected := []string{
+		"process Count: base Count(core/count), ours Count(core/count:fast=), theirs Count(core/counter)",
+		`iip DELIMITER Split: base '\n', ours ',', theirs ';'`,
+		"inport FILENAME: base Read.SOURCE, ours Split.IN, theirs Read.PATH",
+	}
+	if len(conflicts) != len(expected) {
+		t.Fatalf("Unexpected conflicts %v", conflicts)
+	}
+	for i, c := range conflicts {
+		if c.String() != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], c)
+		}
+	}
+	// ours is kept for the conflicts, the removed IIP is merged
+	if d := Diff(ours, merged).String(); d != "- iip 'x' -> OPTIONS Count\n" {
+		t.Errorf("Unexpected merged graph:\n%s", d)
+	}
+}
+
+func TestMergeRemoved(t *testing.T) {
+	base := &parseGraph(t, mergeBase).BaseFbp
+	// ours removes Display and connects Count, theirs connects Display and
+	// removes Count
+	ours := &parseGraph(t, strings.Replace(mergeBase, "Count OUT -> IN Display(core/output)\n", "Count ERROR -> IN Log(core/log)\n", 1)).BaseFbp
+	theirs := &parseGraph(t, strings.NewReplacer(
+		"Split OUT -> IN Count(core/count)\n", "",
+		"'x' -> OPTIONS Count\n", "",
+		"Count OUT -> IN Display(core/output)\n", "Split OUT -> IN Display(core/output)\n'y' -> OPTIONS Display\n",
+	).Replace(mergeBase)).BaseFbp
+
+	merged, conflicts := Merge(base, ours, theirs)
+	expected := []string{
+		"process Count: base Count(core/count), ours Count ERROR -> IN Log, theirs none",
+		"process Display: base Display(core/output), ours none, theirs Split OUT -> IN Display",
+		"process Display: base Display(core/output), ours none, theirs 'y' -> OPTIONS Display",
+	}
+	if len(conflicts) != len(expected) {
+		t.Fatalf("Unexpected conflicts %v", conflicts)
+	}
+	for i, c := range conflicts {
+		if c.String() != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], c)
+		}
+	}
+	if err := merged.Validate(); err != nil {
+		t.Errorf("Unexpected invalid merge: %v", err)
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	graph := func(properties map[string]string, groups []*Group, metadata map[string]string) *BaseFbp {
+		g := &parseGraph(t, mergeBase).BaseFbp
+		g.Properties, g.Groups = properties, groups
+		g.Connections[0].Metadata = metadata
+		return g
+	}
+	base := graph(map[string]string{"name": "count", "icon": "a"}, []*Group{{Name: "input", Nodes: []string{"Read"}}}, nil)
+	ours := graph(map[string]string{"name": "count", "icon": "b"}, []*Group{{Name: "input", Nodes: []string{"Read", "Split"}}}, nil)
+	theirs := graph(map[string]string{"name": "lines", "icon": "c"},
+		[]*Group{{Name: "input", Nodes: []string{"Read"}}, {Name: "output", Nodes: []string{"Display"}}},
+		map[string]string{"route": "1"})
+
+	merged, conflicts := Merge(base, ours, theirs)
+	if len(conflicts) != 1 || conflicts[0].String() != `property icon: base "a", ours "b", theirs "c"` {
+		t.Fatalf("Unexpected conflicts %v", conflicts)
+	}
+	if merged.Properties["name"] != "lines" || merged.Properties["icon"] != "b" {
+		t.Errorf("Unexpected properties %v", merged.Properties)
+	}
+	if len(merged.Groups) != 2 || len(merged.Groups[0].Nodes) != 2 || merged.Groups[1].Name != "output" {
+		t.Errorf("Unexpected groups %v", merged.Groups)
+	}
+	if merged.Connections[0].Metadata["route"] != "1" {
+		t.Errorf("Unexpected connection %v", merged.Connections[0])
+	}
+
+	// the group removed by ours is merged
+	ours.Connections[0].Metadata = map[string]string{"route": "2"}
+	ours.Groups = nil
+	merged, conflicts = Merge(base, ours, theirs)
+	expected := []string{
+		`connection Read OUT -> IN Split: base Read OUT -> IN Split, ours Read OUT -> IN Split (route=2), theirs Read OUT -> IN Split (route=1)`,
+		`property icon: base "a", ours "b", theirs "c"`,
+	}
+	if len(conflicts) != len(expected) {
+		t.Fatalf("Unexpected conflicts %v", conflicts)
+	}
+	for i, c := range conflicts {
+		if c.String() != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], c)
+		}
+	}
+	if len(merged.Groups) != 1 || merged.Groups[0].Name != "output" {
+		t.Errorf("Unexpected groups %v", merged.Groups)
+	}
+}
@@ -0,0 +1,129 @@
+package fbp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// MarshalMermaid returns the graph as Mermaid flowchart
+func (self *BaseFbp) MarshalMermaid() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := self.WriteMermaid(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteMermaid writes the graph to w as "flowchart LR" Mermaid diagram.
+// Processes are drawn inside a subgraph, exported ports outside of it, so
+// that their edges cross the graph boundary. Port names are edge labels,
+// IIPs are stadium-shaped nodes.
+func (self *BaseFbp) WriteMermaid(w io.Writer) error {
+	var buf bytes.Buffer
+	name := self.Properties["name"]
+	if name == "" {
+		name = self.Subgraph
+	}
+	if name == "" {
+		name = "fbp"
+	}
+
+	// Mermaid ids are generated, names only go to the (escaped) labels
+	ids := make(map[string]string)
+	var names []string
+	components := make(map[string]string)
+	addProcess := func(name string) {
+		if _, ok := ids[name]; !ok {
+			ids[name] = fmt.Sprintf("p%d", len(ids))
+			names = append(names, name)
+		}
+	}
+	for _, p := range self.Processes {
+		components[p.Name] = p.Component
+		addProcess(p.Name)
+	}
+	for _, c := range self.Connections {
+		if c.Source != nil {
+			addProcess(c.Source.Process)
+		}
+		addProcess(c.Target.Process)
+	}
+	inports, outports := sortedPorts(self.Inports), sortedPorts(self.Outports)
+	for _, port := range inports {
+		addProcess(self.Inports[port].Process)
+	}
+	for _, port := range outports {
+		addProcess(self.Outports[port].Process)
+	}
+
+	buf.WriteString("flowchart LR\n")
+	fmt.Fprintf(&buf, "    subgraph network [%s]\n", mermaidLabel(name))
+	for _, name := range names {
+		label := mermaidEscape(name)
+		if component, ok := components[name]; ok {
+			label += "<br/>(" + mermaidEscape(component) + ")"
+		}
+		fmt.Fprintf(&buf, "        %s[\"%s\"]\n", ids[name], label)
+	}
+	for i, c := range self.Connections {
+		if c.Source == nil {
+			fmt.Fprintf(&buf, "        iip%d([%s])\n", i, mermaidLabel("'"+c.Data+"'"))
+		}
+	}
+	buf.WriteString("    end\n")
+
+	for i, c := range self.Connections {
+		target := ids[c.Target.Process]
+		if c.Source == nil {
+			fmt.Fprintf(&buf, "    iip%d -->|%s| %s\n", i, mermaidLabel(formatPort(c.Target)), target)
+			continue
+		}
+		label := formatPort(c.Source) + " → " + formatPort(c.Target)
+		fmt.Fprintf(&buf, "    %s -->|%s| %s\n", ids[c.Source.Process], mermaidLabel(label), target)
+	}
+	for i, port := range inports {
+		e := self.Inports[port]
+		fmt.Fprintf(&buf, "    inport%d>%s] -->|%s| %s\n", i, mermaidLabel(port),
+			mermaidLabel(formatPort(e)), ids[e.Process])
+	}
+	for i, port := range outports {
+		e := self.Outports[port]
+		fmt.Fprintf(&buf, "    %s -->|%s| outport%d>%s]\n", ids[e.Process],
+			mermaidLabel(formatPort(e)), i, mermaidLabel(port))
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// mermaidLabel returns s as a quoted Mermaid label
+func mermaidLabel(s string) string {
+	return `"` + mermaidEscape(s) + `"`
+}
+
+// mermaidEscape replaces the characters Mermaid reserves with entity codes
+func mermaidEscape(s string) string {
+	var buf bytes.Buffer
+	for _, r := range s {
+		switch r {
+		case '#':
+			buf.WriteString("#35;")
+		case '"':
+			buf.WriteString("#quot;")
+		case '<':
+			buf.WriteString("#lt;")
+		case '>':
+			buf.WriteString("#gt;")
+		case '&':
+			buf.WriteString("#amp;")
+		case '|':
+			buf.WriteString("#124;")
+		case '\n':
+			buf.WriteString("<br/>")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
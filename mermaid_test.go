@@ -0,0 +1,54 @@
+package fbp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarshalMermaid(t *testing.T) {
+	parser := parseGraph(t, graphExportedArrayPort+"\t'5s' -> OPTIONS Read\n")
+	data, err := parser.MarshalMermaid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mermaid := string(data)
+	t.Log(mermaid)
+	expected := `flowchart LR
+    subgraph network ["fbp"]
+        p0["Read<br/>(ReadFile)"]
+        p1["Process<br/>(Output)"]
+        p2["Log<br/>(Console)"]
+        iip2(["'5s'"])
+    end
+    p0 -->|"OUT → IN[0]"| p1
+    p1 -->|"OUT[0] → IN"| p2
+    iip2 -->|"OPTIONS"| p0
+    inport0>"CONFIG"] -->|"OPTIONS"| p0
+    inport1>"EXTRA"] -->|"IN[0]"| p1
+    inport2>"FILENAME"] -->|"IN"| p0
+    p1 -->|"OUT[1]"| outport0>"RESULT"]
+`
+	if mermaid != expected {
+		t.Fatalf("Unexpected diagram:\n%s", mermaid)
+	}
+}
+
+func TestMermaidEscaping(t *testing.T) {
+	g := &BaseFbp{
+		Processes:   []*Process{{Name: "A", Component: `a"b|c#<d>`}},
+		Connections: []*Connection{{Data: "x\ny", Target: &Endpoint{Process: "A", Port: "IN"}}},
+	}
+	data, err := g.MarshalMermaid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mermaid := string(data)
+	for _, expected := range []string{
+		`p0["A<br/>(a#quot;b#124;c#35;#lt;d#gt;)"]`,
+		`iip0(["'x<br/>y'"])`,
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Fatalf("Missing %s in\n%s", expected, mermaid)
+		}
+	}
+}
@@ -39,9 +39,10 @@ func (e *Endpoint) String() string {
 // Connection (arc) between endpoints
 //
 type Connection struct {
-	Data   string    `json:"data,omitempty"`
-	Source *Endpoint `json:"src,omitempty"`
-	Target *Endpoint `json:"tgt"`
+	Data     string            `json:"data,omitempty"`
+	Source   *Endpoint         `json:"src,omitempty"`
+	Target   *Endpoint         `json:"tgt"`
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 func (c *Connection) String() string {
@@ -54,6 +55,15 @@ func (c *Connection) String() string {
 	}
 }
 
+//
+// Group of processes (as in NoFlo graphs)
+//
+type Group struct {
+	Name     string            `json:"name"`
+	Nodes    []string          `json:"nodes"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
 //
 // In/Out ports for composites
 //
@@ -87,6 +97,20 @@ type BaseFbp struct {
 	// In/Out ports to export outside (composite components)
 	Inports  map[string]*Endpoint
 	Outports map[string]*Endpoint
+
+	// Graph properties and process groups (not part of .fbp DSL, kept
+	// for NoFlo JSON graphs)
+	Properties map[string]string
+	Groups     []*Group
+
+	// Processes named without a component (in .fbp, or with an empty
+	// component in NoFlo JSON), they aren't in Processes
+	named map[string]bool
+
+	// Keys of the metadata (of processes, connections, groups) and of the
+	// properties (of the graph) read from NoFlo JSON values other than
+	// strings, by their owner, they are written back as JSON values
+	nonStrings map[interface{}]map[string]bool
 }
 
 func (self *BaseFbp) createProcessName(name string) string {
@@ -96,6 +120,11 @@ func (self *BaseFbp) createProcessName(name string) string {
 	return name
 }
 
+// unescapeIIP turns the \' escapes of an IIP literal into plain quotes
+func unescapeIIP(str string) string {
+	return strings.Replace(str, `\'`, `'`, -1)
+}
+
 func (self *BaseFbp) createLeftlet() {
 	//log.Println("createLeftlet()", self.nodeProcessName, self.port)
 	self.srcEndpoint = &Endpoint{
@@ -135,7 +164,7 @@ func (self *BaseFbp) createRightlet() {
 		}
 	} else {
 		connection = &Connection{
-			Data:   self.iip,
+			Data:   unescapeIIP(self.iip),
 			Target: self.tgtEndpoint,
 		}
 	}
@@ -158,6 +187,7 @@ func (self *BaseFbp) createMiddlet() {
 	if self.inPortIndex != "" {
 		i, err := strconv.Atoi(self.inPortIndex)
 		if err == nil {
+			self.tgtEndpoint.Index = new(int)
 			*self.tgtEndpoint.Index = i
 		}
 	}
@@ -170,15 +200,19 @@ func (self *BaseFbp) createMiddlet() {
 		}
 	} else {
 		connection = &Connection{
-			Data:   self.iip,
+			Data:   unescapeIIP(self.iip),
 			Target: self.tgtEndpoint,
 		}
 	}
 	self.Connections = append(self.Connections, connection)
 
 	self.port = self.outPort
+	self.index = self.outPortIndex
 	self.inPort = ""
+	self.inPortIndex = ""
 	self.outPort = ""
+	self.outPortIndex = ""
+	self.iip = ""
 	self.createLeftlet()
 }
 
@@ -201,9 +235,30 @@ func (self *BaseFbp) createNode() {
 			}
 			process.Metadata = m
 		}
-		self.nodeMeta = ""
 		self.Processes = append(self.Processes, process)
+	} else if self.nodeComponentName == "" {
+		self.name(self.createProcessName(self.nodeProcessName))
 	}
+	self.nodeComponentName = ""
+	self.nodeMeta = ""
+}
+
+// name records a process named without a component
+func (self *BaseFbp) name(process string) {
+	if self.named == nil {
+		self.named = make(map[string]bool)
+	}
+	self.named[process] = true
+}
+
+// NameProcesses records processes named without a component in graph, so
+// that Validate() knows them, and returns the graph. The code WriteGo
+// generates declares graphs with it.
+func NameProcesses(graph *BaseFbp, names ...string) *BaseFbp {
+	for _, name := range names {
+		graph.name(name)
+	}
+	return graph
 }
 
 func (self *BaseFbp) processExists(name string) bool {
@@ -217,23 +272,59 @@ func (self *BaseFbp) processExists(name string) bool {
 
 func (self *BaseFbp) parseExportedPort(str string) (name string, endpoint *Endpoint) {
 	// str = component.port:externalport
-	parts := strings.Split(str, ":")
-	if len(parts) != 2 {
+	str = strings.TrimSpace(str)
+	parts, ok := splitExport(str)
+	if !ok {
 		return "", nil
 	}
-	name = strings.TrimSpace(parts[1])
-	parts = strings.Split(parts[0], ".")
-	endpoint = &Endpoint{}
-	endpoint.Port = strings.TrimSpace(parts[1])
-	if parts := strings.SplitN(endpoint.Port, "[", 2); len(parts) == 2 {
-		i, err := strconv.Atoi(strings.TrimSuffix(parts[1], "]"))
-		if err != nil {
-			endpoint.Port = parts[0]
+	endpoint = &Endpoint{Port: str[parts.dot+1 : parts.colon]}
+	if parts.indexBegin >= 0 {
+		i, err := strconv.Atoi(str[parts.indexBegin:parts.indexEnd])
+		if err == nil {
+			endpoint.Port = str[parts.dot+1 : parts.portEnd]
+			endpoint.Index = new(int)
 			*endpoint.Index = i
 		}
 	}
-	endpoint.Process = self.createProcessName(strings.TrimSpace(parts[0]))
-	return name, endpoint
+	endpoint.Process = self.createProcessName(str[:parts.dot])
+	return str[parts.colon+1 : parts.end], endpoint
+}
+
+//
+// Parts of Process.PORT[INDEX]:NAME (the value of INPORT, OUTPORT and EXPORT
+// lines) as offsets in its text, indexBegin and indexEnd are -1 without
+// an index
+//
+type exportParts struct {
+	end                  int // before the blanks or comment following it
+	dot, colon           int
+	portEnd              int
+	indexBegin, indexEnd int
+}
+
+// splitExport returns the parts of the exported port text starts with
+func splitExport(text string) (parts exportParts, ok bool) {
+	if parts.end = strings.IndexAny(text, " \t,#\n\r"); parts.end < 0 {
+		parts.end = len(text)
+	}
+	text = text[:parts.end]
+	parts.dot, parts.colon = strings.Index(text, "."), strings.LastIndex(text, ":")
+	if parts.dot < 0 || parts.colon < parts.dot {
+		return parts, false
+	}
+	parts.portEnd, parts.indexBegin, parts.indexEnd = parts.colon, -1, -1
+	port := text[parts.dot+1 : parts.colon]
+	if bracket := strings.IndexByte(port, '['); bracket >= 0 && strings.HasSuffix(port, "]") {
+		parts.portEnd = parts.dot + 1 + bracket
+		parts.indexBegin, parts.indexEnd = parts.portEnd+1, parts.colon-1
+	}
+	return parts, true
+}
+
+// ValidProcessName reports whether name can be the name of a process in
+// the .fbp DSL
+func ValidProcessName(name string) bool {
+	return processNameRe.MatchString(name)
 }
 
 func (self *BaseFbp) createInport(str string) {
@@ -258,10 +349,38 @@ func (self *BaseFbp) createOutport(str string) {
 	self.Outports[port] = endpoint
 }
 
+// Validate checks that the connections and exported ports of the graph are
+// complete and refer to its processes, those declared and those the source
+// it was parsed from names without a component (the graph may be a part of
+// another one). See Check() for the problems of .fbp sources.
 func (self *BaseFbp) Validate() error {
-	//TODO: check if the network can be executed (it can conform to PEG but be invalid)
-	// - Process without component (compare # of components with # of processes)
-	// - Check if all endpoints in connections are in the processes
-	// - etc
+	known := make(map[string]bool)
+	for _, p := range self.Processes {
+		known[p.Name] = true
+	}
+	for name := range self.named {
+		known[name] = true
+	}
+	for i, c := range self.Connections {
+		if c.Target == nil {
+			return fmt.Errorf("fbp: connection #%d has no target", i)
+		}
+		if !known[c.Target.Process] {
+			return fmt.Errorf("fbp: connection #%d to unknown process %s", i, c.Target.Process)
+		}
+		if c.Source != nil && !known[c.Source.Process] {
+			return fmt.Errorf("fbp: connection #%d from unknown process %s", i, c.Source.Process)
+		}
+	}
+	for _, exports := range []struct {
+		kind  string
+		ports map[string]*Endpoint
+	}{{"inport", self.Inports}, {"outport", self.Outports}} {
+		for _, name := range sortedPorts(exports.ports) {
+			if e := exports.ports[name]; !known[e.Process] {
+				return fmt.Errorf("fbp: %s %s of unknown process %s", exports.kind, name, e.Process)
+			}
+		}
+	}
 	return nil
 }
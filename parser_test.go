@@ -160,3 +160,38 @@ func TestGraphArrayPortsOneline(t *testing.T) {
 		t.Fatal("Should be only 7 connections")
 	}
 }
+
+func TestValidate(t *testing.T) {
+	graph := &BaseFbp{
+		Processes: []*Process{{Name: "A", Component: "core/repeat"}},
+		Connections: []*Connection{
+			{Source: &Endpoint{Process: "A", Port: "OUT"}, Target: &Endpoint{Process: "B", Port: "IN"}},
+		},
+	}
+	if err := graph.Validate(); err == nil || err.Error() != "fbp: connection #0 to unknown process B" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	graph.Connections[0].Source.Process = "C"
+	graph.Connections[0].Target.Process = "A"
+	if err := graph.Validate(); err == nil || err.Error() != "fbp: connection #0 from unknown process C" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// NoFlo JSON declares the processes, with an empty component if need be
+	graph, err := ParseJSON([]byte(`{"processes": {"A": {"component": "core/repeat"}, "B": {"component": ""}},
+		"connections": [{"src": {"process": "A", "port": "out"}, "tgt": {"process": "B", "port": "in"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	graph, err = ParseJSON([]byte(`{"processes": {"A": {"component": "core/repeat"}},
+		"connections": [{"src": {"process": "A", "port": "out"}, "tgt": {"process": "B", "port": "in"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.Validate(); err == nil {
+		t.Fatal("Connection to an undeclared process should fail")
+	}
+}
@@ -0,0 +1,393 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oleksandr/fbp"
+)
+
+var ErrClosed = errors.New("protocol: connection closed")
+
+//
+// Client of a runtime speaking the FBP protocol. Commands are sent one at a
+// time, each waits for its reply. The events of the networks (network:data,
+// network:error, network:output and runtime:packet) go to the channels of
+// Data, Errors, Output and Packets, which must be drained once asked for:
+// the client reads nothing else while an event waits in a full channel.
+// Server of this package sends network:error and the packets of exported
+// out-ports as runtime:packet, not network:data nor network:output.
+//
+type Client struct {
+	// Timeout of a command, no timeout if 0
+	Timeout time.Duration
+	// Secret sent with every message
+	Secret string
+
+	conn     Conn
+	requests sync.Mutex
+	mutex    sync.Mutex
+	pending  *request
+	data     chan *Data
+	errors   chan *Error
+	output   chan *Output
+	packets  chan *Packet
+	closed   bool
+	quit     chan struct{}
+	done     chan struct{}
+	stop     sync.Once
+}
+
+// request waits for a reply with command or for <protocol>:error, of the
+// graph of the command if it has one
+type request struct {
+	protocol string
+	command  string
+	graph    string
+	reply    chan *Message
+}
+
+// replies reports whether m is the reply to the request. Replies naming
+// another graph aren't, nor are errors naming a graph: network:error of a
+// running network is an event, a failed command gets an error without it.
+func (r *request) replies(m *Message) bool {
+	if m.Protocol != r.protocol {
+		return false
+	}
+	graph := payloadGraph(m.Payload)
+	switch m.Command {
+	case r.command:
+		return r.graph == "" || graph == "" || graph == r.graph
+	case "error":
+		return graph == ""
+	}
+	return false
+}
+
+// payloadGraph returns the graph named by a payload, if any
+func payloadGraph(payload json.RawMessage) string {
+	var p struct {
+		Graph string `json:"graph"`
+	}
+	json.Unmarshal(payload, &p)
+	return p.Graph
+}
+
+// Dial connects to a runtime at ws://, wss:// or tcp:// URL
+func Dial(rawurl string) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "tcp" {
+		conn, err := net.Dial("tcp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return NewClient(NewConn(conn)), nil
+	}
+	conn, err := DialWebSocket(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client talking to a runtime over conn
+func NewClient(conn Conn) *Client {
+	c := &Client{
+		Timeout: 30 * time.Second,
+		conn:    conn,
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+// Close closes the connection, the event channels get closed
+func (c *Client) Close() error {
+	var err error
+	c.stop.Do(func() {
+		close(c.quit)
+		err = c.conn.Close()
+	})
+	<-c.done
+	return err
+}
+
+// Data returns the channel of network:data events
+func (c *Client) Data() <-chan *Data {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.data == nil {
+		c.data = make(chan *Data, 16)
+		if c.closed {
+			close(c.data)
+		}
+	}
+	return c.data
+}
+
+// Errors returns the channel of network:error events
+func (c *Client) Errors() <-chan *Error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.errors == nil {
+		c.errors = make(chan *Error, 16)
+		if c.closed {
+			close(c.errors)
+		}
+	}
+	return c.errors
+}
+
+// Output returns the channel of network:output events
+func (c *Client) Output() <-chan *Output {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.output == nil {
+		c.output = make(chan *Output, 16)
+		if c.closed {
+			close(c.output)
+		}
+	}
+	return c.output
+}
+
+// Packets returns the channel of runtime:packet events
+func (c *Client) Packets() <-chan *Packet {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.packets == nil {
+		c.packets = make(chan *Packet, 16)
+		if c.closed {
+			close(c.packets)
+		}
+	}
+	return c.packets
+}
+
+// read passes replies to the pending request and events to their channels
+func (c *Client) read() {
+	defer close(c.done)
+	for {
+		m, err := c.conn.ReadMessage()
+		if err != nil {
+			c.close()
+			return
+		}
+		c.mutex.Lock()
+		r := c.pending
+		if r != nil && r.replies(m) {
+			c.pending = nil
+			c.mutex.Unlock()
+			r.reply <- m
+			continue
+		}
+		data, errs, output, packets := c.data, c.errors, c.output, c.packets
+		c.mutex.Unlock()
+
+		switch m.Protocol + ":" + m.Command {
+		case "network:data":
+			if data != nil {
+				d := &Data{}
+				if json.Unmarshal(m.Payload, d) == nil {
+					select {
+					case data <- d:
+					case <-c.quit:
+					}
+				}
+			}
+		case "network:error":
+			if errs != nil {
+				e := &Error{}
+				if json.Unmarshal(m.Payload, e) == nil {
+					select {
+					case errs <- e:
+					case <-c.quit:
+					}
+				}
+			}
+		case "network:output":
+			if output != nil {
+				o := &Output{}
+				if json.Unmarshal(m.Payload, o) == nil {
+					select {
+					case output <- o:
+					case <-c.quit:
+					}
+				}
+			}
+		case "runtime:packet":
+			if packets != nil {
+				p := &Packet{}
+				if json.Unmarshal(m.Payload, p) == nil {
+					select {
+					case packets <- p:
+					case <-c.quit:
+					}
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	if c.pending != nil {
+		close(c.pending.reply)
+		c.pending = nil
+	}
+	if c.data != nil {
+		close(c.data)
+	}
+	if c.errors != nil {
+		close(c.errors)
+	}
+	if c.output != nil {
+		close(c.output)
+	}
+	if c.packets != nil {
+		close(c.packets)
+	}
+}
+
+// Send sends a command and waits for the reply with command reply
+func (c *Client) Send(protocol, command string, payload interface{}, reply string) (*Message, error) {
+	m, err := NewMessage(protocol, command, payload)
+	if err != nil {
+		return nil, err
+	}
+	m.Secret = c.Secret
+
+	c.requests.Lock()
+	defer c.requests.Unlock()
+	r := &request{protocol: protocol, command: reply, graph: payloadGraph(m.Payload), reply: make(chan *Message, 1)}
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	c.pending = r
+	c.mutex.Unlock()
+
+	if err := c.conn.WriteMessage(m); err != nil {
+		c.cancel(r)
+		return nil, err
+	}
+	var timeout <-chan time.Time
+	if c.Timeout > 0 {
+		timer := time.NewTimer(c.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case m, ok := <-r.reply:
+		if !ok {
+			return nil, ErrClosed
+		}
+		if m.Command == "error" && reply != "error" {
+			e := &Error{}
+			json.Unmarshal(m.Payload, e)
+			return nil, fmt.Errorf("protocol: %s:%s: %s", protocol, command, e.Message)
+		}
+		return m, nil
+	case <-timeout:
+		c.cancel(r)
+		return nil, fmt.Errorf("protocol: %s:%s: no reply", protocol, command)
+	}
+}
+
+func (c *Client) cancel(r *request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.pending == r {
+		c.pending = nil
+	}
+}
+
+// GetRuntime returns the description of the runtime
+func (c *Client) GetRuntime() (*Runtime, error) {
+	m, err := c.Send("runtime", "getruntime", nil, "runtime")
+	if err != nil {
+		return nil, err
+	}
+	r := &Runtime{}
+	if err := json.Unmarshal(m.Payload, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Push replaces graph id of the runtime with graph: graph:clear, then
+// graph:addnode for the processes, graph:addedge for the connections and
+// graph:addinitial for the IIPs
+func (c *Client) Push(id string, graph *fbp.BaseFbp) error {
+	g := &Graph{ID: id, Name: graph.Properties["name"], Main: true}
+	if _, err := c.Send("graph", "clear", g, "clear"); err != nil {
+		return err
+	}
+	for _, p := range graph.Processes {
+		node := &Node{ID: p.Name, Component: p.Component, Metadata: rawMetadata(p.Metadata), Graph: id}
+		if _, err := c.Send("graph", "addnode", node, "addnode"); err != nil {
+			return err
+		}
+	}
+	for _, conn := range graph.Connections {
+		if conn.Source == nil {
+			continue
+		}
+		edge := &Edge{Src: edgeEnd(conn.Source), Tgt: edgeEnd(conn.Target), Metadata: rawMetadata(conn.Metadata), Graph: id}
+		if _, err := c.Send("graph", "addedge", edge, "addedge"); err != nil {
+			return err
+		}
+	}
+	for _, conn := range graph.Connections {
+		if conn.Source != nil {
+			continue
+		}
+		initial := &Initial{Tgt: edgeEnd(conn.Target), Metadata: rawMetadata(conn.Metadata), Graph: id}
+		initial.Src.Data, _ = json.Marshal(conn.Data)
+		if _, err := c.Send("graph", "addinitial", initial, "addinitial"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start starts the network of a graph
+func (c *Client) Start(graph string) error {
+	_, err := c.Send("network", "start", &Network{Graph: graph}, "started")
+	return err
+}
+
+// Stop stops the network of a graph and waits for it to stop
+func (c *Client) Stop(graph string) error {
+	_, err := c.Send("network", "stop", &Network{Graph: graph}, "stopped")
+	return err
+}
+
+// edgeEnd returns an endpoint with the port name in lower case, as NoFlo has it
+func edgeEnd(e *fbp.Endpoint) EdgeEnd {
+	return EdgeEnd{Node: e.Process, Port: strings.ToLower(e.Port), Index: e.Index}
+}
+
+func rawMetadata(m map[string]string) map[string]json.RawMessage {
+	if len(m) == 0 {
+		return nil
+	}
+	raw := make(map[string]json.RawMessage, len(m))
+	for k, v := range m {
+		raw[k], _ = json.Marshal(v)
+	}
+	return raw
+}
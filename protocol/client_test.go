@@ -0,0 +1,291 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oleksandr/fbp"
+	"github.com/oleksandr/fbp/runtime"
+)
+
+// stub replies to the commands the client sends and records them, network:start
+// is followed by one event of every kind
+func stub(t *testing.T, conn Conn, commands chan<- string) {
+	defer close(commands)
+	reply := func(protocol, command string, payload interface{}) {
+		m, _ := NewMessage(protocol, command, payload)
+		conn.WriteMessage(m)
+	}
+	for {
+		m, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		commands <- m.Protocol + ":" + m.Command + " " + string(m.Payload)
+		switch m.Protocol + ":" + m.Command {
+		case "runtime:getruntime":
+			reply("runtime", "runtime", &Runtime{Type: "stub", Version: Version})
+		case "network:start":
+			// unrelated messages before the reply
+			reply("network", "data", &Data{ID: "early", Graph: "main"})
+			reply("runtime", "packet", &Packet{Port: "OUT", Event: "data"})
+			reply("network", "started", &NetworkStatus{Graph: "main", Started: true, Running: true})
+			reply("network", "data", &Data{
+				ID:    "A OUT -> IN B",
+				Src:   &EdgeEnd{Node: "A", Port: "out"},
+				Tgt:   &EdgeEnd{Node: "B", Port: "in"},
+				Data:  json.RawMessage(`"hello"`),
+				Graph: "main",
+			})
+			reply("network", "output", &Output{Message: "hello"})
+			reply("network", "error", &Error{Message: "failed", Graph: "main"})
+		case "network:stop":
+			// events of the networks before the reply
+			reply("network", "error", &Error{Message: "failed again", Graph: "main"})
+			reply("network", "stopped", &NetworkStatus{Graph: "other"})
+			reply("network", "error", &Error{Message: "not running"})
+		case "graph:clear", "graph:addnode", "graph:addedge", "graph:addinitial":
+			reply(m.Protocol, m.Command, m.Payload)
+		}
+	}
+}
+
+func newStubClient(t *testing.T) (*Client, chan string) {
+	client, server := net.Pipe()
+	commands := make(chan string, 100)
+	go stub(t, NewConn(server), commands)
+	return NewClient(NewConn(client)), commands
+}
+
+func TestClient(t *testing.T) {
+	c, commands := newStubClient(t)
+	data, errs, output := c.Data(), c.Errors(), c.Output()
+
+	r, err := c.GetRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != "stub" {
+		t.Fatalf("Unexpected runtime %+v", r)
+	}
+
+	parser := &fbp.Fbp{Buffer: `
+	'hello' -> IN A(core/repeat) OUT -> IN[1] B(core/log)
+	`}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	parser.Execute()
+	parser.Processes[0].Metadata = map[string]string{"x": "10"}
+	if err := c.Push("main", &parser.BaseFbp); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start("main"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-data:
+		if d.ID != "early" {
+			t.Fatalf("Unexpected data %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+	select {
+	case d := <-data:
+		if d.Src.Node != "A" || d.Tgt.Port != "in" || string(d.Data) != `"hello"` {
+			t.Fatalf("Unexpected data %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+	select {
+	case o := <-output:
+		if o.Message != "hello" {
+			t.Fatalf("Unexpected output %+v", o)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+	select {
+	case e := <-errs:
+		if e.Message != "failed" || e.Graph != "main" {
+			t.Fatalf("Unexpected error %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+	// network:error without graph is the reply of a failed network command
+	if err := c.Stop("main"); err == nil || err.Error() != "protocol: network:stop: not running" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	select {
+	case e := <-errs:
+		if e.Message != "failed again" {
+			t.Fatalf("Unexpected error %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+
+	c.Close()
+	if _, err := c.GetRuntime(); err != ErrClosed {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, ok := <-data; ok {
+		t.Fatal("Data channel should be closed")
+	}
+
+	var sent []string
+	for command := range commands {
+		sent = append(sent, command)
+	}
+	expected := []string{
+		`runtime:getruntime `,
+		`graph:clear {"id":"main","main":true}`,
+		`graph:addnode {"id":"A","component":"core/repeat","metadata":{"x":"10"},"graph":"main"}`,
+		`graph:addnode {"id":"B","component":"core/log","graph":"main"}`,
+		`graph:addedge {"src":{"node":"A","port":"out"},"tgt":{"node":"B","port":"in","index":1},"graph":"main"}`,
+		`graph:addinitial {"src":{"data":"hello"},"tgt":{"node":"A","port":"in"},"graph":"main"}`,
+		`network:start {"graph":"main"}`,
+		`network:stop {"graph":"main"}`,
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Fatalf("Unexpected commands\n%q\n%q", sent, expected)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		// a runtime that never replies
+		conn := NewConn(server)
+		for {
+			if _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	c := NewClient(NewConn(client))
+	c.Timeout = 10 * time.Millisecond
+	if _, err := c.GetRuntime(); err == nil {
+		t.Fatal("Command should time out")
+	}
+}
+
+func TestClientServer(t *testing.T) {
+	s, packets := newTestServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.ServeTCP(l)
+
+	c, err := Dial("tcp://" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	errs := c.Errors()
+	graph := &fbp.BaseFbp{
+		Processes: []*fbp.Process{
+			{Name: "Repeat", Component: "core/repeat"},
+			{Name: "Collect", Component: "test/collect"},
+		},
+		Connections: []*fbp.Connection{
+			{Source: &fbp.Endpoint{Process: "Repeat", Port: "OUT"}, Target: &fbp.Endpoint{Process: "Collect", Port: "IN"}},
+			{Data: "hello", Target: &fbp.Endpoint{Process: "Repeat", Port: "IN"}},
+		},
+	}
+	if err := c.Push("main", graph); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := mustJSON(t, s.Graph("main").Connections), mustJSON(t, graph.Connections); a != b {
+		t.Fatalf("Unexpected graph\n%s\n%s", a, b)
+	}
+	if err := c.Start("main"); err != nil {
+		t.Fatal(err)
+	}
+	if v := <-packets; v != "hello" {
+		t.Fatalf("Unexpected packet %v", v)
+	}
+
+	graph.Processes[0].Component = "core/unknown"
+	if err := c.Push("broken", graph); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start("broken"); err == nil {
+		t.Fatal("Network should not start")
+	}
+	select {
+	case e := <-errs:
+		t.Fatalf("Unexpected error event %+v", e)
+	default:
+	}
+}
+
+type fail struct{}
+
+func (fail) InPorts() []string  { return nil }
+func (fail) OutPorts() []string { return nil }
+
+func (fail) Run(ports *runtime.Ports) error {
+	return errors.New("boom")
+}
+
+// the events a Server sends reach the client
+func TestClientServerEvents(t *testing.T) {
+	s, _ := newTestServer()
+	s.Registry.Register("test/fail", func() runtime.Component { return fail{} })
+	client, server := net.Pipe()
+	go s.Serve(NewConn(server))
+	c := NewClient(NewConn(client))
+	defer c.Close()
+	data, errs, output, packets := c.Data(), c.Errors(), c.Output(), c.Packets()
+
+	err := c.Push("main", &fbp.BaseFbp{
+		Processes:   []*fbp.Process{{Name: "A", Component: "core/repeat"}},
+		Connections: []*fbp.Connection{{Data: "hi", Target: &fbp.Endpoint{Process: "A", Port: "IN"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Graph("main").Outports = map[string]*fbp.Endpoint{"OUT": {Process: "A", Port: "OUT"}}
+	if err := c.Start("main"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-packets:
+		if p.Port != "OUT" || p.Graph != "main" || string(p.Payload) != `"hi"` {
+			t.Fatalf("Unexpected packet %+v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+
+	if err := c.Push("failing", &fbp.BaseFbp{Processes: []*fbp.Process{{Name: "F", Component: "test/fail"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start("failing"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-errs:
+		if e.Graph != "failing" || !strings.Contains(e.Message, "boom") {
+			t.Fatalf("Unexpected error %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+	if len(data) != 0 || len(output) != 0 {
+		t.Fatal("Server sends no network:data nor network:output")
+	}
+}
@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/oleksandr/fbp"
+	"github.com/oleksandr/fbp/runtime"
+)
+
+// AddComposite registers the graph of component name (as fbp.LoadComponent
+// finds it with loader) as a component of the server registry, its ports
+// are the INPORT and OUTPORT lists of the graph. The source is what
+// component:getsource returns, the graph written as .fbp if loader isn't
+// an fbp.SourceLoader.
+func (s *Server) AddComposite(name string, loader fbp.Loader) error {
+	graph, path, err := fbp.LoadComponent(loader, name)
+	if err != nil {
+		return err
+	}
+	if graph == nil {
+		return fmt.Errorf("protocol: no graph of component %s", name)
+	}
+	source := &Source{Name: name, Language: "fbp"}
+	if sources, ok := loader.(fbp.SourceLoader); ok {
+		code, err := sources.Source(path)
+		if err != nil {
+			return err
+		}
+		source.Code = string(code)
+		if strings.HasSuffix(path, ".json") {
+			source.Language = "json"
+		}
+	} else {
+		code, err := graph.MarshalFbp()
+		if err != nil {
+			return err
+		}
+		source.Code = string(code)
+	}
+	if err := s.Registry.Register(name, runtime.Composite(graph, s.Registry)); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sources[name] = source
+	return nil
+}
+
+//
+// component subprotocol
+//
+
+// listComponents sends component:component for every registered component
+// and component:componentsready with their number
+func listComponents(s *Server, c Conn, payload json.RawMessage) error {
+	names := s.Registry.Names()
+	for _, name := range names {
+		d, err := s.Registry.Describe(name)
+		if err != nil {
+			return err
+		}
+		if err := s.send(c, "component", "component", component(name, d)); err != nil {
+			return err
+		}
+	}
+	return s.send(c, "component", "componentsready", len(names))
+}
+
+func getSource(s *Server, c Conn, payload json.RawMessage) error {
+	var p Source
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	source, ok := s.sources[p.Name]
+	s.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("no source of component %q", p.Name)
+	}
+	return s.send(c, "component", "source", source)
+}
+
+// component returns the payload of component:component, port names are
+// lower case as they are in NoFlo
+func component(name string, d *runtime.Description) *Component {
+	ports := func(descriptions []runtime.PortDescription) []Port {
+		ports := []Port{}
+		for _, p := range descriptions {
+			ports = append(ports, Port{
+				ID:          strings.ToLower(p.Name),
+				Type:        p.Type,
+				Description: p.Description,
+				Required:    p.Required,
+				Addressable: p.Addressable,
+			})
+		}
+		return ports
+	}
+	return &Component{
+		Name:        name,
+		Description: d.Description,
+		Icon:        d.Icon,
+		Subgraph:    d.Subgraph,
+		InPorts:     ports(d.InPorts),
+		OutPorts:    ports(d.OutPorts),
+	}
+}
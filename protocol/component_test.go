@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/oleksandr/fbp"
+	"github.com/oleksandr/fbp/runtime"
+)
+
+//
+// Component with a description
+//
+type greeter struct {
+	repeat
+}
+
+func (greeter) Describe() *runtime.Description {
+	return &runtime.Description{
+		Description: "Says hello",
+		Icon:        "smile-o",
+		InPorts:     []runtime.PortDescription{{Name: "NAME", Type: "string", Required: true}},
+		OutPorts:    []runtime.PortDescription{{Name: "OUT", Type: "string", Addressable: true}},
+	}
+}
+
+func TestComponents(t *testing.T) {
+	s, packets := newTestServer()
+	s.Registry.Register("test/greeter", func() runtime.Component { return greeter{} })
+	source := "# Repeats twice\nINPORT=A.IN:INPUT\nOUTPORT=B.OUT:OUTPUT\nA(core/repeat) OUT -> IN B(core/repeat)\n"
+	loader := fbp.NewMapLoader(map[string]string{
+		"test/twice.fbp":  source,
+		"test/broken.fbp": "A(core/repeat) ->",
+	})
+	if err := s.AddComposite("test/twice", loader); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddComposite("test/twice", loader); err == nil {
+		t.Fatal("Component should not be added twice")
+	}
+	if err := s.AddComposite("test/broken", loader); err == nil {
+		t.Fatal("Broken graph should not be added")
+	}
+	if err := s.AddComposite("test/none", loader); err == nil {
+		t.Fatal("Missing graph should not be added")
+	}
+	c := connect(t, s)
+
+	c.send("component", "list", nil)
+	var components []Component
+	for range s.Registry.Names() {
+		var component Component
+		c.expect("component", "component", &component)
+		components = append(components, component)
+	}
+	var count int
+	c.expect("component", "componentsready", &count)
+	if count != 4 {
+		t.Fatalf("Unexpected number of components %d", count)
+	}
+	expected := []Component{
+		{Name: "core/repeat", InPorts: []Port{{ID: "in", Type: "all"}}, OutPorts: []Port{{ID: "out", Type: "all"}}},
+		{Name: "test/collect", InPorts: []Port{{ID: "in", Type: "all"}}, OutPorts: []Port{}},
+		{
+			Name:        "test/greeter",
+			Description: "Says hello",
+			Icon:        "smile-o",
+			InPorts:     []Port{{ID: "name", Type: "string", Required: true}},
+			OutPorts:    []Port{{ID: "out", Type: "string", Addressable: true}},
+		},
+		{
+			Name:     "test/twice",
+			Subgraph: true,
+			InPorts:  []Port{{ID: "input", Type: "all"}},
+			OutPorts: []Port{{ID: "output", Type: "all"}},
+		},
+	}
+	if !reflect.DeepEqual(components, expected) {
+		t.Fatalf("Unexpected components\n%+v\n%+v", components, expected)
+	}
+
+	c.send("component", "getsource", &Source{Name: "test/twice"})
+	var src Source
+	c.expect("component", "source", &src)
+	if src.Language != "fbp" || src.Code != source {
+		t.Fatalf("Unexpected source %+v", src)
+	}
+	c.send("component", "getsource", &Source{Name: "core/repeat"})
+	c.expect("component", "error", nil)
+
+	// the composite runs as any other component
+	c.send("graph", "clear", &Graph{ID: "main"})
+	c.expect("graph", "clear", nil)
+	c.send("graph", "addnode", &Node{ID: "Twice", Component: "test/twice", Graph: "main"})
+	c.expect("graph", "addnode", nil)
+	c.send("graph", "addnode", &Node{ID: "Collect", Component: "test/collect", Graph: "main"})
+	c.expect("graph", "addnode", nil)
+	c.send("graph", "addedge", &Edge{Src: EdgeEnd{Node: "Twice", Port: "output"}, Tgt: EdgeEnd{Node: "Collect", Port: "in"}, Graph: "main"})
+	c.expect("graph", "addedge", nil)
+	c.send("graph", "addinitial", map[string]interface{}{
+		"src":   map[string]interface{}{"data": 42},
+		"tgt":   map[string]interface{}{"node": "Twice", "port": "input"},
+		"graph": "main",
+	})
+	c.expect("graph", "addinitial", nil)
+	c.send("network", "start", &Network{Graph: "main"})
+	c.expect("network", "started", nil)
+	c.expect("network", "stopped", nil)
+	if v := <-packets; v != "42" {
+		t.Fatalf("Unexpected packet %v", v)
+	}
+}
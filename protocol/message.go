@@ -0,0 +1,216 @@
+// Package protocol implements the FBP Network Protocol used by Flowhub and
+// noflo-ui to edit and run networks remotely (https://flowbased.github.io/fbp-protocol/).
+package protocol
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+//
+// Message of the protocol, e.g. {"protocol": "graph", "command": "addnode", ...}
+//
+type Message struct {
+	Protocol string          `json:"protocol"`
+	Command  string          `json:"command"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Secret   string          `json:"secret,omitempty"`
+}
+
+// NewMessage returns a message with payload encoded as JSON
+func NewMessage(protocol, command string, payload interface{}) (*Message, error) {
+	m := &Message{Protocol: protocol, Command: command}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		m.Payload = data
+	}
+	return m, nil
+}
+
+//
+// Conn sends and receives messages, WriteMessage may be called concurrently
+//
+type Conn interface {
+	ReadMessage() (*Message, error)
+	WriteMessage(m *Message) error
+	Close() error
+}
+
+//
+// Stream of JSON messages, one per line (the plain TCP transport)
+//
+type streamConn struct {
+	rwc     io.ReadWriteCloser
+	decoder *json.Decoder
+	mutex   sync.Mutex
+	writer  *bufio.Writer
+}
+
+// NewConn returns a connection exchanging newline-delimited JSON messages
+// over rwc, e.g. a TCP connection or one end of net.Pipe()
+func NewConn(rwc io.ReadWriteCloser) Conn {
+	return &streamConn{
+		rwc:     rwc,
+		decoder: json.NewDecoder(rwc),
+		writer:  bufio.NewWriter(rwc),
+	}
+}
+
+func (c *streamConn) ReadMessage() (*Message, error) {
+	m := &Message{}
+	if err := c.decoder.Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *streamConn) WriteMessage(m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writer.Write(data)
+	c.writer.WriteByte('\n')
+	return c.writer.Flush()
+}
+
+func (c *streamConn) Close() error {
+	return c.rwc.Close()
+}
+
+//
+// Payloads of the messages
+//
+
+// Runtime is the payload of runtime:runtime
+type Runtime struct {
+	Type            string   `json:"type"`
+	Version         string   `json:"version"`
+	Capabilities    []string `json:"capabilities"`
+	AllCapabilities []string `json:"allCapabilities,omitempty"`
+	Graph           string   `json:"graph,omitempty"`
+	ID              string   `json:"id,omitempty"`
+	Label           string   `json:"label,omitempty"`
+}
+
+// Graph is the payload of graph:clear
+type Graph struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Library     string `json:"library,omitempty"`
+	Main        bool   `json:"main,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Node is the payload of graph:addnode and graph:removenode
+type Node struct {
+	ID        string                     `json:"id"`
+	Component string                     `json:"component,omitempty"`
+	Metadata  map[string]json.RawMessage `json:"metadata,omitempty"`
+	Graph     string                     `json:"graph"`
+}
+
+// EdgeEnd is a port of a node in graph:addedge and graph:addinitial
+type EdgeEnd struct {
+	Node  string `json:"node"`
+	Port  string `json:"port"`
+	Index *int   `json:"index,omitempty"`
+}
+
+// Edge is the payload of graph:addedge
+type Edge struct {
+	Src      EdgeEnd                    `json:"src"`
+	Tgt      EdgeEnd                    `json:"tgt"`
+	Metadata map[string]json.RawMessage `json:"metadata,omitempty"`
+	Graph    string                     `json:"graph"`
+}
+
+// Initial is the payload of graph:addinitial
+type Initial struct {
+	Src struct {
+		Data json.RawMessage `json:"data"`
+	} `json:"src"`
+	Tgt      EdgeEnd                    `json:"tgt"`
+	Metadata map[string]json.RawMessage `json:"metadata,omitempty"`
+	Graph    string                     `json:"graph"`
+}
+
+// Network is the payload of the network commands
+type Network struct {
+	Graph string `json:"graph"`
+}
+
+// NetworkStatus is the payload of network:started, network:stopped and
+// network:status
+type NetworkStatus struct {
+	Graph   string `json:"graph"`
+	Time    string `json:"time,omitempty"`
+	Started bool   `json:"started"`
+	Running bool   `json:"running"`
+}
+
+// Packet is the payload of runtime:packet, the data of an exported port
+type Packet struct {
+	Port    string          `json:"port"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Graph   string          `json:"graph"`
+}
+
+// Error is the payload of <protocol>:error and network:error
+type Error struct {
+	Message string `json:"message"`
+	Graph   string `json:"graph,omitempty"`
+}
+
+// Component is the payload of component:component
+type Component struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+	Subgraph    bool   `json:"subgraph"`
+	InPorts     []Port `json:"inPorts"`
+	OutPorts    []Port `json:"outPorts"`
+}
+
+// Port of a component in component:component
+type Port struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"`
+	Addressable bool   `json:"addressable"`
+}
+
+// Source is the payload of component:getsource and component:source
+type Source struct {
+	Name     string `json:"name"`
+	Language string `json:"language,omitempty"`
+	Library  string `json:"library,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Tests    string `json:"tests,omitempty"`
+}
+
+// Data is the payload of network:data, a packet going through an edge
+type Data struct {
+	ID    string          `json:"id,omitempty"`
+	Src   *EdgeEnd        `json:"src,omitempty"`
+	Tgt   *EdgeEnd        `json:"tgt,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Graph string          `json:"graph"`
+}
+
+// Output is the payload of network:output, a message of the runtime
+type Output struct {
+	Type    string `json:"type,omitempty"`
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+}
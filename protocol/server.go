@@ -0,0 +1,537 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oleksandr/fbp"
+	"github.com/oleksandr/fbp/runtime"
+)
+
+// Version of the FBP protocol the server speaks
+const Version = "0.7"
+
+var capabilities = []string{
+	"protocol:runtime",
+	"protocol:graph",
+	"protocol:network",
+	"protocol:component",
+	"component:getsource",
+	"network:control",
+	"network:status",
+}
+
+type handler func(s *Server, c Conn, payload json.RawMessage) error
+
+var handlers = map[string]handler{
+	"runtime:getruntime":  getRuntime,
+	"graph:clear":         clearGraph,
+	"graph:addnode":       addNode,
+	"graph:removenode":    removeNode,
+	"graph:addedge":       addEdge,
+	"graph:addinitial":    addInitial,
+	"network:start":       startNetwork,
+	"network:stop":        stopNetwork,
+	"network:getstatus":   networkStatus,
+	"component:list":      listComponents,
+	"component:getsource": getSource,
+}
+
+//
+// Server of the FBP protocol. Graphs are edited as fbp.BaseFbp and run by
+// the runtime package with components of Registry. All the clients share
+// the graphs and get the events of all the networks.
+//
+type Server struct {
+	// Registry of the components (runtime.DefaultRegistry if nil)
+	Registry *runtime.Registry
+	// ID and Label of the runtime as reported by runtime:runtime
+	ID    string
+	Label string
+	// Secret the messages must carry, if set
+	Secret string
+	// Origins of the pages allowed to connect over WebSocket besides the
+	// server's own, e.g. "https://app.flowhub.io" ("*" allows any)
+	Origins []string
+	// Main graph reported by runtime:runtime
+	Main string
+	// Time a network has to finish once network:stop closed its exported
+	// in-ports, before it is cancelled (5s if 0)
+	StopTimeout time.Duration
+
+	mutex    sync.Mutex
+	graphs   map[string]*fbp.BaseFbp
+	networks map[string]*runtime.Network
+	conns    map[Conn]bool
+	sources  map[string]*Source
+}
+
+// NewServer returns a server with components from registry
+func NewServer(registry *runtime.Registry) *Server {
+	if registry == nil {
+		registry = runtime.DefaultRegistry
+	}
+	return &Server{
+		Registry: registry,
+		graphs:   make(map[string]*fbp.BaseFbp),
+		networks: make(map[string]*runtime.Network),
+		conns:    make(map[Conn]bool),
+		sources:  make(map[string]*Source),
+	}
+}
+
+// AddGraph makes a parsed graph available to the clients under id
+func (s *Server) AddGraph(id string, graph *fbp.BaseFbp) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.graphs[id] = graph
+	if s.Main == "" {
+		s.Main = id
+	}
+}
+
+// Graph returns the graph with id or nil
+func (s *Server) Graph(id string) *fbp.BaseFbp {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.graphs[id]
+}
+
+// Serve handles the messages of a client until it disconnects
+func (s *Server) Serve(c Conn) error {
+	s.mutex.Lock()
+	s.conns[c] = true
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, c)
+		s.mutex.Unlock()
+		c.Close()
+	}()
+	for {
+		m, err := c.ReadMessage()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := s.handle(c, m); err != nil {
+			return err
+		}
+	}
+}
+
+// handle replies to a message, failed commands are reported to the client
+func (s *Server) handle(c Conn, m *Message) error {
+	var err error
+	if s.Secret != "" && m.Secret != s.Secret {
+		err = fmt.Errorf("invalid secret")
+	} else if h, ok := handlers[m.Protocol+":"+m.Command]; ok {
+		err = h(s, c, m.Payload)
+	} else {
+		err = fmt.Errorf("unknown command %s:%s", m.Protocol, m.Command)
+	}
+	if err != nil {
+		return s.send(c, m.Protocol, "error", &Error{Message: err.Error()})
+	}
+	return nil
+}
+
+// ServeTCP accepts clients exchanging newline-delimited JSON messages
+func (s *Server) ServeTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.Serve(NewConn(conn)); err != nil {
+				log.Println("protocol:", err)
+			}
+		}()
+	}
+}
+
+// ServeHTTP accepts WebSocket clients
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := Upgrade(w, r, s.Origins...)
+	if err != nil {
+		return
+	}
+	if err := s.Serve(c); err != nil {
+		log.Println("protocol:", err)
+	}
+}
+
+func (s *Server) send(c Conn, protocol, command string, payload interface{}) error {
+	m, err := NewMessage(protocol, command, payload)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(m)
+}
+
+// broadcast sends a message to all the clients
+func (s *Server) broadcast(protocol, command string, payload interface{}) {
+	m, err := NewMessage(protocol, command, payload)
+	if err != nil {
+		log.Println("protocol:", err)
+		return
+	}
+	s.mutex.Lock()
+	conns := make([]Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mutex.Unlock()
+	for _, c := range conns {
+		c.WriteMessage(m)
+	}
+}
+
+// graph returns the graph of a command, the caller holds the mutex
+func (s *Server) graph(id string) (*fbp.BaseFbp, error) {
+	g, ok := s.graphs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown graph %q", id)
+	}
+	return g, nil
+}
+
+//
+// runtime subprotocol
+//
+
+func getRuntime(s *Server, c Conn, payload json.RawMessage) error {
+	s.mutex.Lock()
+	r := &Runtime{
+		Type:            "fbp-go",
+		Version:         Version,
+		Capabilities:    capabilities,
+		AllCapabilities: capabilities,
+		Graph:           s.Main,
+		ID:              s.ID,
+		Label:           s.Label,
+	}
+	s.mutex.Unlock()
+	return s.send(c, "runtime", "runtime", r)
+}
+
+//
+// graph subprotocol, the commands are acknowledged with their own payload
+//
+
+func clearGraph(s *Server, c Conn, payload json.RawMessage) error {
+	var p Graph
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	if p.ID == "" {
+		return fmt.Errorf("graph id missing")
+	}
+	g := &fbp.BaseFbp{}
+	if p.Name != "" {
+		g.Properties = map[string]string{"name": p.Name}
+	}
+	s.AddGraph(p.ID, g)
+	if p.Main {
+		s.mutex.Lock()
+		s.Main = p.ID
+		s.mutex.Unlock()
+	}
+	return s.send(c, "graph", "clear", payload)
+}
+
+func addNode(s *Server, c Conn, payload json.RawMessage) error {
+	var p Node
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	err := func() error {
+		g, err := s.graph(p.Graph)
+		if err != nil {
+			return err
+		}
+		for _, proc := range g.Processes {
+			if proc.Name == p.ID {
+				return fmt.Errorf("node %q already exists", p.ID)
+			}
+		}
+		g.Processes = append(g.Processes, &fbp.Process{
+			Name:      p.ID,
+			Component: p.Component,
+			Metadata:  metadata(p.Metadata),
+		})
+		return nil
+	}()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.send(c, "graph", "addnode", payload)
+}
+
+// removeNode removes a node with its edges, IIPs and exported ports
+func removeNode(s *Server, c Conn, payload json.RawMessage) error {
+	var p Node
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	err := func() error {
+		g, err := s.graph(p.Graph)
+		if err != nil {
+			return err
+		}
+		processes := g.Processes[:0]
+		for _, proc := range g.Processes {
+			if proc.Name != p.ID {
+				processes = append(processes, proc)
+			}
+		}
+		if len(processes) == len(g.Processes) {
+			return fmt.Errorf("unknown node %q", p.ID)
+		}
+		g.Processes = processes
+		connections := g.Connections[:0]
+		for _, conn := range g.Connections {
+			if conn.Target.Process != p.ID && (conn.Source == nil || conn.Source.Process != p.ID) {
+				connections = append(connections, conn)
+			}
+		}
+		g.Connections = connections
+		for _, ports := range []map[string]*fbp.Endpoint{g.Inports, g.Outports} {
+			for name, e := range ports {
+				if e.Process == p.ID {
+					delete(ports, name)
+				}
+			}
+		}
+		return nil
+	}()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.send(c, "graph", "removenode", payload)
+}
+
+func addEdge(s *Server, c Conn, payload json.RawMessage) error {
+	var p Edge
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	err := func() error {
+		g, err := s.graph(p.Graph)
+		if err != nil {
+			return err
+		}
+		src, err := endpoint(g, p.Src)
+		if err != nil {
+			return err
+		}
+		tgt, err := endpoint(g, p.Tgt)
+		if err != nil {
+			return err
+		}
+		g.Connections = append(g.Connections, &fbp.Connection{
+			Source:   src,
+			Target:   tgt,
+			Metadata: metadata(p.Metadata),
+		})
+		return nil
+	}()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.send(c, "graph", "addedge", payload)
+}
+
+func addInitial(s *Server, c Conn, payload json.RawMessage) error {
+	var p Initial
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	err := func() error {
+		g, err := s.graph(p.Graph)
+		if err != nil {
+			return err
+		}
+		tgt, err := endpoint(g, p.Tgt)
+		if err != nil {
+			return err
+		}
+		g.Connections = append(g.Connections, &fbp.Connection{
+			Data:     jsonString(p.Src.Data),
+			Target:   tgt,
+			Metadata: metadata(p.Metadata),
+		})
+		return nil
+	}()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.send(c, "graph", "addinitial", payload)
+}
+
+// endpoint returns the endpoint of an existing node, port names are upper
+// case in the graph model
+func endpoint(g *fbp.BaseFbp, end EdgeEnd) (*fbp.Endpoint, error) {
+	for _, p := range g.Processes {
+		if p.Name == end.Node {
+			return &fbp.Endpoint{Process: end.Node, Port: strings.ToUpper(end.Port), Index: end.Index}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown node %q", end.Node)
+}
+
+// metadata keeps strings as they are and other values as JSON text
+func metadata(values map[string]json.RawMessage) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(values))
+	for k, v := range values {
+		m[k] = jsonString(v)
+	}
+	return m
+}
+
+func jsonString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+//
+// network subprotocol
+//
+
+func startNetwork(s *Server, c Conn, payload json.RawMessage) error {
+	var p Network
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	var outports []string
+	n, err := func() (*runtime.Network, error) {
+		g, err := s.graph(p.Graph)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := s.networks[p.Graph]; ok {
+			return nil, fmt.Errorf("network %q is running", p.Graph)
+		}
+		n, err := runtime.Load(g, s.Registry)
+		if err != nil {
+			return nil, err
+		}
+		if err := n.Start(); err != nil {
+			return nil, err
+		}
+		s.networks[p.Graph] = n
+		for name := range g.Outports {
+			outports = append(outports, name)
+		}
+		return n, nil
+	}()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	err = s.send(c, "network", "started", &NetworkStatus{
+		Graph:   p.Graph,
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Started: true,
+		Running: true,
+	})
+	// exported out-ports are drained, their packets go to the clients
+	for _, name := range outports {
+		go s.forward(p.Graph, name, n.Out(name))
+	}
+	go s.wait(p.Graph, n)
+	return err
+}
+
+// forward sends the packets of an exported out-port as runtime:packet
+func (s *Server) forward(graph, port string, ch <-chan interface{}) {
+	for v := range ch {
+		data, err := json.Marshal(v)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(v))
+		}
+		s.broadcast("runtime", "packet", &Packet{Port: port, Event: "data", Payload: data, Graph: graph})
+	}
+}
+
+// wait reports the end of a network to the clients
+func (s *Server) wait(graph string, n *runtime.Network) {
+	err := n.Wait()
+	s.mutex.Lock()
+	if s.networks[graph] == n {
+		delete(s.networks, graph)
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		s.broadcast("network", "error", &Error{Message: err.Error(), Graph: graph})
+	}
+	s.broadcast("network", "stopped", &NetworkStatus{
+		Graph: graph,
+		Time:  time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func stopNetwork(s *Server, c Conn, payload json.RawMessage) error {
+	var p Network
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	n, ok := s.networks[p.Graph]
+	s.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("network %q is not running", p.Graph)
+	}
+	// the network finishes the packets it has, network:stopped is sent
+	// when the processes have returned
+	go func() {
+		timeout := s.StopTimeout
+		if timeout == 0 {
+			timeout = 5 * time.Second
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		n.Shutdown(ctx)
+	}()
+	return nil
+}
+
+func networkStatus(s *Server, c Conn, payload json.RawMessage) error {
+	var p Network
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	_, running := s.networks[p.Graph]
+	_, err := s.graph(p.Graph)
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.send(c, "network", "status", &NetworkStatus{Graph: p.Graph, Started: running, Running: running})
+}
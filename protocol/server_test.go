@@ -0,0 +1,478 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oleksandr/fbp"
+	"github.com/oleksandr/fbp/runtime"
+)
+
+//
+// Test components
+//
+type repeat struct{}
+
+func (repeat) InPorts() []string  { return []string{"IN"} }
+func (repeat) OutPorts() []string { return []string{"OUT"} }
+
+func (repeat) Run(ports *runtime.Ports) error {
+	for {
+		v, ok := ports.In("IN").Receive()
+		if !ok || !ports.Out("OUT").Send(v) {
+			return nil
+		}
+	}
+}
+
+type collect struct {
+	packets chan interface{}
+}
+
+func (c collect) InPorts() []string  { return []string{"IN"} }
+func (c collect) OutPorts() []string { return nil }
+
+func (c collect) Run(ports *runtime.Ports) error {
+	for {
+		v, ok := ports.In("IN").Receive()
+		if !ok {
+			return nil
+		}
+		c.packets <- v
+	}
+}
+
+// closed sends whether its in-port was closed rather than the network
+// cancelled
+type closed struct {
+	packets chan interface{}
+}
+
+func (c closed) InPorts() []string  { return []string{"IN"} }
+func (c closed) OutPorts() []string { return nil }
+
+func (c closed) Run(ports *runtime.Ports) error {
+	for {
+		if _, ok := ports.In("IN").Receive(); !ok {
+			break
+		}
+	}
+	c.packets <- ports.Context().Err() == nil
+	return nil
+}
+
+// stuck returns when the network is cancelled
+type stuck struct{}
+
+func (stuck) InPorts() []string  { return []string{"IN"} }
+func (stuck) OutPorts() []string { return nil }
+
+func (stuck) Run(ports *runtime.Ports) error {
+	<-ports.Context().Done()
+	return nil
+}
+
+func newTestServer() (*Server, chan interface{}) {
+	packets := make(chan interface{}, 10)
+	registry := runtime.NewRegistry()
+	registry.Register("core/repeat", func() runtime.Component { return repeat{} })
+	registry.Register("test/collect", func() runtime.Component { return collect{packets} })
+	return NewServer(registry), packets
+}
+
+//
+// Fake client talking to a server over net.Pipe
+//
+type fakeClient struct {
+	t    *testing.T
+	conn Conn
+}
+
+func connect(t *testing.T, s *Server) *fakeClient {
+	client, server := net.Pipe()
+	go s.Serve(NewConn(server))
+	return &fakeClient{t: t, conn: NewConn(client)}
+}
+
+func (c *fakeClient) send(protocol, command string, payload interface{}) {
+	m, err := NewMessage(protocol, command, payload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.conn.WriteMessage(m); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// expect reads the next message and checks its protocol and command
+func (c *fakeClient) expect(protocol, command string, payload interface{}) {
+	messages := make(chan *Message, 1)
+	go func() {
+		m, err := c.conn.ReadMessage()
+		if err != nil {
+			c.t.Error(err)
+		}
+		messages <- m
+	}()
+	select {
+	case m := <-messages:
+		if m == nil {
+			c.t.FailNow()
+		}
+		if m.Protocol != protocol || m.Command != command {
+			c.t.Fatalf("Expected %s:%s, got %s:%s %s", protocol, command, m.Protocol, m.Command, m.Payload)
+		}
+		if payload != nil {
+			if err := json.Unmarshal(m.Payload, payload); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+	case <-time.After(time.Second):
+		c.t.Fatalf("Timeout waiting for %s:%s", protocol, command)
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	s, _ := newTestServer()
+	s.ID, s.Label = "test-id", "Test runtime"
+	c := connect(t, s)
+
+	c.send("runtime", "getruntime", nil)
+	var r Runtime
+	c.expect("runtime", "runtime", &r)
+	if r.Type != "fbp-go" || r.ID != "test-id" || r.Label != "Test runtime" || len(r.Capabilities) == 0 {
+		t.Fatalf("Unexpected runtime %+v", r)
+	}
+
+	c.send("runtime", "explode", nil)
+	var e Error
+	c.expect("runtime", "error", &e)
+	if e.Message != "unknown command runtime:explode" {
+		t.Fatalf("Unexpected error %q", e.Message)
+	}
+
+	s.Secret = "s3cr3t"
+	c.send("runtime", "getruntime", nil)
+	c.expect("runtime", "error", nil)
+	m, _ := NewMessage("runtime", "getruntime", nil)
+	m.Secret = "s3cr3t"
+	c.conn.WriteMessage(m)
+	c.expect("runtime", "runtime", nil)
+}
+
+func TestGraphAndNetwork(t *testing.T) {
+	s, packets := newTestServer()
+	c := connect(t, s)
+
+	c.send("graph", "clear", &Graph{ID: "main", Name: "Main", Main: true})
+	c.expect("graph", "clear", nil)
+	c.send("graph", "addnode", map[string]interface{}{
+		"id": "Repeat", "component": "core/repeat", "graph": "main",
+		"metadata": map[string]interface{}{"x": 10, "label": "Repeat"},
+	})
+	c.expect("graph", "addnode", nil)
+	c.send("graph", "addnode", &Node{ID: "Collect", Component: "test/collect", Graph: "main"})
+	c.expect("graph", "addnode", nil)
+	c.send("graph", "addnode", &Node{ID: "Lost", Component: "core/repeat", Graph: "main"})
+	c.expect("graph", "addnode", nil)
+	c.send("graph", "addedge", &Edge{
+		Src:   EdgeEnd{Node: "Repeat", Port: "out"},
+		Tgt:   EdgeEnd{Node: "Collect", Port: "in"},
+		Graph: "main",
+	})
+	c.expect("graph", "addedge", nil)
+	c.send("graph", "addedge", &Edge{
+		Src:   EdgeEnd{Node: "Lost", Port: "out"},
+		Tgt:   EdgeEnd{Node: "Collect", Port: "in"},
+		Graph: "main",
+	})
+	c.expect("graph", "addedge", nil)
+	c.send("graph", "addinitial", map[string]interface{}{
+		"src":   map[string]interface{}{"data": "hello"},
+		"tgt":   map[string]interface{}{"node": "Repeat", "port": "in"},
+		"graph": "main",
+	})
+	c.expect("graph", "addinitial", nil)
+	c.send("graph", "removenode", &Node{ID: "Lost", Graph: "main"})
+	c.expect("graph", "removenode", nil)
+
+	g := s.Graph("main")
+	expected := &fbp.BaseFbp{
+		Properties: map[string]string{"name": "Main"},
+		Processes: []*fbp.Process{
+			{Name: "Repeat", Component: "core/repeat", Metadata: map[string]string{"x": "10", "label": "Repeat"}},
+			{Name: "Collect", Component: "test/collect"},
+		},
+		Connections: []*fbp.Connection{
+			{Source: &fbp.Endpoint{Process: "Repeat", Port: "OUT"}, Target: &fbp.Endpoint{Process: "Collect", Port: "IN"}},
+			{Data: "hello", Target: &fbp.Endpoint{Process: "Repeat", Port: "IN"}},
+		},
+	}
+	if a, b := mustJSON(t, g), mustJSON(t, expected); a != b {
+		t.Fatalf("Unexpected graph\n%s\n%s", a, b)
+	}
+
+	for _, errorCase := range []struct {
+		protocol, command string
+		payload           interface{}
+	}{
+		{"graph", "addnode", &Node{ID: "Repeat", Component: "core/repeat", Graph: "main"}},
+		{"graph", "addnode", &Node{ID: "A", Component: "core/repeat", Graph: "other"}},
+		{"graph", "removenode", &Node{ID: "Lost", Graph: "main"}},
+		{"graph", "addedge", &Edge{Src: EdgeEnd{Node: "Lost", Port: "OUT"}, Tgt: EdgeEnd{Node: "Repeat", Port: "IN"}, Graph: "main"}},
+		{"network", "stop", &Network{Graph: "main"}},
+		{"network", "start", &Network{Graph: "other"}},
+	} {
+		c.send(errorCase.protocol, errorCase.command, errorCase.payload)
+		c.expect(errorCase.protocol, "error", nil)
+	}
+
+	c.send("network", "start", &Network{Graph: "main"})
+	var status NetworkStatus
+	c.expect("network", "started", &status)
+	if !status.Running || status.Graph != "main" {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+	select {
+	case v := <-packets:
+		if v != "hello" {
+			t.Fatalf("Unexpected packet %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+	// the network finishes once the IIP is delivered
+	c.expect("network", "stopped", &status)
+	if status.Running {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+	c.send("network", "getstatus", &Network{Graph: "main"})
+	c.expect("network", "status", &status)
+	if status.Running {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+}
+
+func TestNetworkEvents(t *testing.T) {
+	s, _ := newTestServer()
+	parser := &fbp.Fbp{Buffer: `
+	INPORT=Repeat.IN:IN
+	OUTPORT=Repeat.OUT:OUT
+	Input(core/repeat) OUT -> IN Repeat(core/repeat)
+	`}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	parser.Execute()
+	s.AddGraph("exported", &parser.BaseFbp)
+	c := connect(t, s)
+
+	c.send("runtime", "getruntime", nil)
+	var r Runtime
+	c.expect("runtime", "runtime", &r)
+	if r.Graph != "exported" {
+		t.Fatalf("Unexpected main graph %q", r.Graph)
+	}
+
+	c.send("network", "start", &Network{Graph: "exported"})
+	c.expect("network", "started", nil)
+	c.send("network", "start", &Network{Graph: "exported"})
+	c.expect("network", "error", nil)
+	c.send("network", "getstatus", &Network{Graph: "exported"})
+	var status NetworkStatus
+	c.expect("network", "status", &status)
+	if !status.Running {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+	c.send("network", "stop", &Network{Graph: "exported"})
+	c.expect("network", "stopped", nil)
+
+	// a failing network reports the error before it stops
+	s.AddGraph("broken", &fbp.BaseFbp{
+		Processes:   []*fbp.Process{{Name: "A", Component: "core/repeat"}},
+		Connections: []*fbp.Connection{{Data: "x", Target: &fbp.Endpoint{Process: "A", Port: "DATA"}}},
+	})
+	c.send("network", "start", &Network{Graph: "broken"})
+	c.expect("network", "error", nil)
+}
+
+func TestStopNetwork(t *testing.T) {
+	s, packets := newTestServer()
+	s.Registry.Register("test/closed", func() runtime.Component { return closed{packets} })
+	s.Registry.Register("test/stuck", func() runtime.Component { return stuck{} })
+	s.AddGraph("main", &fbp.BaseFbp{
+		Processes: []*fbp.Process{{Name: "A", Component: "test/closed"}},
+		Inports:   map[string]*fbp.Endpoint{"IN": {Process: "A", Port: "IN"}},
+	})
+	c := connect(t, s)
+	c.send("network", "start", &Network{Graph: "main"})
+	c.expect("network", "started", nil)
+	c.send("network", "stop", &Network{Graph: "main"})
+	select {
+	case v := <-packets:
+		if v != true {
+			t.Fatal("Expected the exported in-port closed, the network was cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+	c.expect("network", "stopped", nil)
+
+	// the network is cancelled if it doesn't finish in time
+	s.StopTimeout = 10 * time.Millisecond
+	s.AddGraph("stuck", &fbp.BaseFbp{
+		Processes: []*fbp.Process{{Name: "A", Component: "test/stuck"}},
+		Inports:   map[string]*fbp.Endpoint{"IN": {Process: "A", Port: "IN"}},
+	})
+	c.send("network", "start", &Network{Graph: "stuck"})
+	c.expect("network", "started", nil)
+	c.send("network", "stop", &Network{Graph: "stuck"})
+	c.expect("network", "stopped", nil)
+}
+
+func TestPackets(t *testing.T) {
+	s, _ := newTestServer()
+	s.AddGraph("main", &fbp.BaseFbp{
+		Processes:   []*fbp.Process{{Name: "A", Component: "core/repeat"}},
+		Connections: []*fbp.Connection{{Data: "hi", Target: &fbp.Endpoint{Process: "A", Port: "IN"}}},
+		Outports:    map[string]*fbp.Endpoint{"OUT": {Process: "A", Port: "OUT"}},
+	})
+	c := connect(t, s)
+	c.send("network", "start", &Network{Graph: "main"})
+	c.expect("network", "started", nil)
+	var p Packet
+	c.expect("runtime", "packet", &p)
+	if p.Port != "OUT" || p.Event != "data" || string(p.Payload) != `"hi"` {
+		t.Fatalf("Unexpected packet %+v", p)
+	}
+	c.expect("network", "stopped", nil)
+}
+
+func TestTransports(t *testing.T) {
+	s, _ := newTestServer()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.ServeTCP(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp := &fakeClient{t: t, conn: NewConn(conn)}
+	tcp.send("runtime", "getruntime", nil)
+	tcp.expect("runtime", "runtime", nil)
+	tcp.conn.Close()
+
+	server := httptest.NewServer(s)
+	defer server.Close()
+	ws, err := DialWebSocket("ws" + strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &fakeClient{t: t, conn: ws}
+	c.send("runtime", "getruntime", nil)
+	c.expect("runtime", "runtime", nil)
+	// 16 and 64 bit frame lengths
+	for _, size := range []int{1000, 70000} {
+		name := strings.Repeat("x", size)
+		c.send("graph", "clear", &Graph{ID: "big", Name: name})
+		var g Graph
+		c.expect("graph", "clear", &g)
+		if g.Name != name {
+			t.Fatalf("Unexpected graph name of %d bytes", len(g.Name))
+		}
+	}
+	ws.Close()
+
+	if _, err := DialWebSocket("http" + strings.TrimPrefix(server.URL, "http")); err == nil {
+		t.Fatal("Unsupported scheme should fail")
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	s, _ := newTestServer()
+	s.Origins = []string{"https://app.flowhub.io"}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	for _, test := range []struct {
+		origin string
+		status int
+	}{
+		{"", http.StatusSwitchingProtocols},
+		{"http://" + host, http.StatusSwitchingProtocols},
+		{"https://app.flowhub.io", http.StatusSwitchingProtocols},
+		{"https://evil.example", http.StatusForbidden},
+		{"null", http.StatusForbidden},
+	} {
+		conn, err := net.Dial("tcp", host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := "GET / HTTP/1.1\r\nHost: " + host + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+		if test.origin != "" {
+			request += "Origin: " + test.origin + "\r\n"
+		}
+		if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("Origin %q: expected status %d, got %d", test.origin, test.status, resp.StatusCode)
+		}
+		conn.Close()
+	}
+}
+
+func TestWebSocketMasking(t *testing.T) {
+	for _, client := range []bool{false, true} {
+		a, b := net.Pipe()
+		conn := &webSocketConn{conn: a, reader: bufio.NewReader(a), client: client}
+		// {} in a frame masked as the other side mustn't
+		frame := []byte{0x81, 0x02, '{', '}'}
+		if client {
+			frame = []byte{0x81, 0x82, 0, 0, 0, 0, '{', '}'}
+		}
+		reply := make(chan []byte, 1)
+		go func() {
+			b.Write(frame)
+			data, _ := io.ReadAll(b)
+			reply <- data
+		}()
+		if _, err := conn.ReadMessage(); err != ErrMasking {
+			t.Fatalf("Expected ErrMasking (client %v), got %v", client, err)
+		}
+		data := <-reply
+		if len(data) == 0 || data[0] != 0x88 {
+			t.Fatalf("Expected a close frame (client %v), got %v", client, data)
+		}
+		if !client && !bytes.Equal(data, []byte{0x88, 0x02, 0x03, 0xea}) {
+			t.Fatalf("Expected close status 1002, got %v", data)
+		}
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,321 @@
+package protocol
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WebSocket (RFC 6455) is implemented here as far as the protocol needs it:
+// text messages, fragmentation, ping/pong and close, no extensions.
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	// Subprotocol Flowhub asks for
+	webSocketProtocol = "noflo"
+	webSocketGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	maxMessageSize    = 16 << 20
+)
+
+var ErrHandshake = errors.New("protocol: bad websocket handshake")
+
+// ErrOrigin is returned by Upgrade for a handshake from an origin it doesn't
+// allow
+var ErrOrigin = errors.New("protocol: websocket origin not allowed")
+
+// ErrMasking is returned by ReadMessage for a frame masked by the server or
+// not masked by the client (RFC 6455 5.1), the connection is closed
+var ErrMasking = errors.New("protocol: bad websocket frame masking")
+
+//
+// Connection exchanging messages as WebSocket text frames
+//
+type webSocketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	client bool
+	mutex  sync.Mutex
+}
+
+// Upgrade turns an HTTP request into a WebSocket connection. Browsers tell
+// the origin of the page opening the connection, the handshake is rejected
+// if it isn't the host of the request or one of origins (scheme://host[:port]
+// or "*" for any). Clients other than browsers send no origin.
+func Upgrade(w http.ResponseWriter, r *http.Request, origins ...string) (Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Upgrade", "websocket") ||
+		!headerContains(r.Header, "Connection", "upgrade") || key == "" {
+		http.Error(w, "WebSocket connection expected", http.StatusBadRequest)
+		return nil, ErrHandshake
+	}
+	if !allowedOrigin(r, origins) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return nil, ErrOrigin
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, ErrHandshake
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, ErrHandshake
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if headerContains(r.Header, "Sec-WebSocket-Protocol", webSocketProtocol) {
+		response += "Sec-WebSocket-Protocol: " + webSocketProtocol + "\r\n"
+	}
+	if _, err := rw.WriteString(response + "\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &webSocketConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// DialWebSocket connects to a runtime at ws:// or wss:// URL
+func DialWebSocket(rawurl string) (Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host += ":80"
+		}
+		conn, err = net.Dial("tcp", host)
+	case "wss":
+		if u.Port() == "" {
+			host += ":443"
+		}
+		conn, err = tls.Dial("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("protocol: unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{
+		Method: "GET",
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":                {"websocket"},
+			"Connection":             {"Upgrade"},
+			"Sec-Websocket-Key":      {key},
+			"Sec-Websocket-Version":  {"13"},
+			"Sec-Websocket-Protocol": {webSocketProtocol},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, ErrHandshake
+	}
+	return &webSocketConn{conn: conn, reader: reader, client: true}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains reports whether a comma-separated header has a token
+// allowedOrigin reports whether the Origin of r is missing, the host of r or
+// one of origins
+func allowedOrigin(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *webSocketConn) ReadMessage() (*Message, error) {
+	var data []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opText, opBinary, opContinuation:
+			data = append(data, payload...)
+			if len(data) > maxMessageSize {
+				return nil, errors.New("protocol: message too large")
+			}
+			if fin {
+				m := &Message{}
+				if err := json.Unmarshal(data, m); err != nil {
+					return nil, err
+				}
+				return m, nil
+			}
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, io.EOF
+		}
+	}
+}
+
+func (c *webSocketConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode := header[0]&0x80 != 0, header[0]&0x0f
+	masked, length := header[1]&0x80 != 0, uint64(header[1]&0x7f)
+	if masked == c.client {
+		// close with status 1002 (protocol error)
+		c.writeFrame(opClose, []byte{0x03, 0xea})
+		c.conn.Close()
+		return false, 0, nil, ErrMasking
+	}
+	switch length {
+	case 126:
+		var n [2]byte
+		if _, err := io.ReadFull(c.reader, n[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(n[:]))
+	case 127:
+		var n [8]byte
+		if _, err := io.ReadFull(c.reader, n[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(n[:])
+	}
+	if length > maxMessageSize {
+		return false, 0, nil, errors.New("protocol: message too large")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes an unfragmented frame, masked when sent by a client
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ mask[i%4]
+		}
+		payload = masked
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err := c.conn.Write(append(frame, payload...))
+	return err
+}
+
+func (c *webSocketConn) WriteMessage(m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(opText, data)
+}
+
+func (c *webSocketConn) Close() error {
+	c.writeFrame(opClose, nil)
+	return c.conn.Close()
+}
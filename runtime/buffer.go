@@ -0,0 +1,93 @@
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/oleksandr/fbp"
+)
+
+// Buffers
+//
+// Connections are unbuffered and blocking unless the network or the edge
+// says otherwise. The metadata of an edge (Connection.Metadata, e.g. from
+// a JSON graph) may set:
+//
+//	capacity   number of packets buffered on the edge
+//	overflow   what Send does when the buffer is full: "block",
+//	           "drop-newest", "drop-oldest" or "error"
+//	errorport  out-port of the source process that gets the packets
+//	           the "error" policy rejects (ERROR by default)
+//
+// Edges without metadata take Network.Capacity and Network.Overflow.
+// "drop-oldest" needs something to drop, its capacity is at least one.
+//
+// Unlike FBP runtimes giving every connection a buffer of its own, the
+// buffer belongs to the in-port slot the edge is connected to, as a slot is
+// one channel (see InPort.Chan). Edges into the same slot (fan-in) share
+// it, its capacity is the largest one of them, and the overflow policy of
+// an edge applies when the shared buffer is full, whichever edge filled it.
+
+const (
+	CapacityKey  = "capacity"
+	OverflowKey  = "overflow"
+	ErrorPortKey = "errorport"
+)
+
+//
+// Overflow policy of a connection with a full buffer
+//
+type Overflow int
+
+const (
+	// OverflowBlock makes Send wait for the buffer to have room
+	OverflowBlock Overflow = iota
+	// OverflowDropNewest drops the packet being sent
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest packet of the buffer
+	OverflowDropOldest
+	// OverflowError sends the packet to the error port of the process
+	OverflowError
+)
+
+var overflowNames = []string{"block", "drop-newest", "drop-oldest", "error"}
+
+func (o Overflow) String() string {
+	if o >= 0 && int(o) < len(overflowNames) {
+		return overflowNames[o]
+	}
+	return fmt.Sprintf("Overflow(%d)", int(o))
+}
+
+// ParseOverflow returns the policy of a name used in edge metadata
+func ParseOverflow(name string) (Overflow, error) {
+	for i, n := range overflowNames {
+		if n == name {
+			return Overflow(i), nil
+		}
+	}
+	return OverflowBlock, fmt.Errorf("runtime: unknown overflow policy %q", name)
+}
+
+// buffer returns capacity and overflow policy of an edge
+func (n *Network) buffer(src *fbp.Endpoint, metadata map[string]string) (int, Overflow, error) {
+	capacity, overflow := n.Capacity, n.Overflow
+	if s, ok := metadata[CapacityKey]; ok {
+		c, err := strconv.Atoi(s)
+		if err != nil || c < 0 {
+			return 0, 0, fmt.Errorf("runtime: invalid capacity %q of %s.%s", s, src.Process, src.Port)
+		}
+		capacity = c
+	}
+	if s, ok := metadata[OverflowKey]; ok {
+		o, err := ParseOverflow(s)
+		if err != nil {
+			return 0, 0, err
+		}
+		overflow = o
+	}
+	if overflow == OverflowDropOldest && capacity == 0 {
+		capacity = 1
+	}
+	return capacity, overflow, nil
+}
@@ -0,0 +1,176 @@
+package runtime
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//
+// Component that sends packets 1 to N for IIP N and tells when it is done
+//
+type burst struct {
+	sent chan struct{}
+}
+
+func (b *burst) InPorts() []string  { return []string{"IN"} }
+func (b *burst) OutPorts() []string { return []string{"OUT", "ERROR"} }
+
+func (b *burst) Run(ports *Ports) error {
+	v, ok := ports.In("IN").Receive()
+	if !ok {
+		return nil
+	}
+	count, _ := strconv.Atoi(v.(string))
+	for i := 1; i <= count; i++ {
+		ports.Out("OUT").Send(i)
+	}
+	close(b.sent)
+	return nil
+}
+
+func TestBuffers(t *testing.T) {
+	for _, test := range []struct {
+		metadata map[string]string
+		packets  []interface{}
+		errors   []interface{}
+	}{
+		{map[string]string{"capacity": "5"}, []interface{}{1, 2, 3, 4, 5}, nil},
+		{map[string]string{"capacity": "2", "overflow": "drop-newest"}, []interface{}{1, 2}, nil},
+		{map[string]string{"capacity": "2", "overflow": "drop-oldest"}, []interface{}{4, 5}, nil},
+		{map[string]string{"overflow": "drop-oldest"}, []interface{}{5}, nil},
+		{map[string]string{"capacity": "1", "overflow": "error"}, []interface{}{1}, []interface{}{2, 3, 4, 5}},
+	} {
+		b := &burst{sent: make(chan struct{})}
+		s := &slow{proceed: make(chan struct{}), packets: make(chan interface{}, 10)}
+		errors := make(chan interface{}, 10)
+		registry := NewRegistry()
+		registry.Register("test/burst", func() Component { return b })
+		registry.Register("test/slow", func() Component { return s })
+		registry.Register("test/collect", func() Component { return &collect{count: 10, packets: errors} })
+
+		graph := parse(t, `
+		'5' -> IN Burst(test/burst) OUT -> IN Sink(test/slow)
+		Burst ERROR -> IN Errors(test/collect)
+		`)
+		graph.Connections[1].Metadata = test.metadata
+		n, err := Load(graph, registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Start()
+		// nothing is received before everything is sent
+		select {
+		case <-b.sent:
+		case <-time.After(time.Second):
+			t.Fatalf("Burst blocked with %v", test.metadata)
+		}
+		close(s.proceed)
+		if err := n.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		close(s.packets)
+		close(errors)
+		var packets, rejected []interface{}
+		for p := range s.packets {
+			packets = append(packets, p)
+		}
+		for p := range errors {
+			rejected = append(rejected, p)
+		}
+		if !reflect.DeepEqual(packets, test.packets) || !reflect.DeepEqual(rejected, test.errors) {
+			t.Fatalf("Unexpected packets %v and errors %v with %v", packets, rejected, test.metadata)
+		}
+	}
+}
+
+func TestBuffersFanIn(t *testing.T) {
+	first := &burst{sent: make(chan struct{})}
+	second := &burst{sent: make(chan struct{})}
+	s := &slow{proceed: make(chan struct{}), packets: make(chan interface{}, 10)}
+	registry := NewRegistry()
+	registry.Register("test/first", func() Component { return first })
+	registry.Register("test/second", func() Component { return second })
+	registry.Register("test/slow", func() Component { return s })
+
+	graph := parse(t, `
+	INPORT=Second.IN:IN
+	'3' -> IN First(test/first) OUT -> IN Sink(test/slow)
+	Second(test/second) OUT -> IN Sink
+	`)
+	graph.Connections[1].Metadata = map[string]string{"capacity": "1"}
+	graph.Connections[2].Metadata = map[string]string{"capacity": "3", "overflow": "drop-newest"}
+	n, err := Load(graph, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Start()
+	// the slot buffers 3 packets, First doesn't block after the first one
+	select {
+	case <-first.sent:
+	case <-time.After(time.Second):
+		t.Fatal("First blocked on the buffer of its edge")
+	}
+	// the buffer First filled is full for Second too, its packets are dropped
+	n.In("IN") <- "2"
+	n.CloseIn("IN")
+	<-second.sent
+	close(s.proceed)
+	if err := n.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	close(s.packets)
+	var packets []interface{}
+	for p := range s.packets {
+		packets = append(packets, p)
+	}
+	if !reflect.DeepEqual(packets, []interface{}{1, 2, 3}) {
+		t.Fatalf("Unexpected packets %v", packets)
+	}
+}
+
+func TestBufferErrors(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("test/passthru", func() Component { return passthru{} })
+
+	for _, metadata := range []map[string]string{
+		{"capacity": "-1"},
+		{"capacity": "many"},
+		{"overflow": "explode"},
+		{"overflow": "error"},
+		{"overflow": "error", "errorport": "FAILED"},
+	} {
+		graph := parse(t, `A(test/passthru) OUT -> IN B(test/passthru)`)
+		graph.Connections[0].Metadata = metadata
+		if _, err := Load(graph, registry); err == nil {
+			t.Fatalf("Graph should not load with %v", metadata)
+		}
+	}
+
+	// network-wide default
+	n := NewNetwork(registry)
+	n.Capacity = 2
+	if err := parse(t, `
+	INPORT=A.IN:IN
+	OUTPORT=B.OUT:OUT
+	A(test/passthru) OUT -> IN B(test/passthru)
+	`).Build(n); err != nil {
+		t.Fatal(err)
+	}
+	n.Start()
+	// A -> B and B -> OUT take two packets each, A and B hold one each
+	for i := 1; i <= 6; i++ {
+		select {
+		case n.In("IN") <- i:
+		case <-time.After(time.Second):
+			t.Fatalf("Network did not buffer packet %d", i)
+		}
+	}
+	n.CloseIn("IN")
+	for i := 1; i <= 6; i++ {
+		if v := receive(t, n.Out("OUT")); v != i {
+			t.Fatalf("Unexpected packet %v", v)
+		}
+	}
+}
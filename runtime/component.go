@@ -0,0 +1,144 @@
+// Package runtime executes the networks described by fbp graphs. Every
+// process runs in a goroutine of its own and exchanges packets with the
+// other processes over channels.
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//
+// Component is the code behind a process. InPorts and OutPorts declare the
+// names of its ports, Run is called in a goroutine of its own when the
+// network starts and is expected to return when there is nothing more to
+// do, e.g. when its in-ports are closed or the network is stopped.
+//
+type Component interface {
+	InPorts() []string
+	OutPorts() []string
+	Run(ports *Ports) error
+}
+
+//
+// Description of a component shown by editors (see the protocol package)
+//
+type Description struct {
+	Description string
+	Icon        string
+	Subgraph    bool
+	InPorts     []PortDescription
+	OutPorts    []PortDescription
+}
+
+//
+// Description of a port, Type is one of the FBP protocol datatypes, e.g.
+// "all", "string", "number", "boolean", "object", "array" or "bang"
+//
+type PortDescription struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+	Addressable bool
+}
+
+//
+// Describer is implemented by components that describe themselves better
+// than their port names do
+//
+type Describer interface {
+	Describe() *Description
+}
+
+//
+// Factory creates a new instance of a component
+//
+type Factory func() Component
+
+//
+// Registry of components, keyed by the name used in Process.Component
+//
+type Registry struct {
+	sync.RWMutex
+	factories map[string]Factory
+}
+
+// DefaultRegistry is the registry used by Register and Load
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{factories: make(map[string]Factory)}
+}
+
+// Register adds a component factory to the registry
+func (r *Registry) Register(name string, factory Factory) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.factories[name]; ok {
+		return fmt.Errorf("runtime: component %q is already registered", name)
+	}
+	r.factories[name] = factory
+	return nil
+}
+
+// Create returns a new instance of the named component
+func (r *Registry) Create(name string) (Component, error) {
+	r.RLock()
+	factory, ok := r.factories[name]
+	r.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("runtime: unknown component %q", name)
+	}
+	return factory(), nil
+}
+
+// Describe returns the description of the named component, made of its port
+// names unless it implements Describer
+func (r *Registry) Describe(name string) (*Description, error) {
+	c, err := r.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := c.(Describer); ok {
+		if description := d.Describe(); description != nil {
+			return description, nil
+		}
+	}
+	description := &Description{}
+	for _, port := range c.InPorts() {
+		description.InPorts = append(description.InPorts, PortDescription{Name: port, Type: "all"})
+	}
+	for _, port := range c.OutPorts() {
+		description.OutPorts = append(description.OutPorts, PortDescription{Name: port, Type: "all"})
+	}
+	return description, nil
+}
+
+// Ports returns the in- and out-ports of the named component, none if it
+// isn't registered (Registry is fbp.Components for completion)
+func (r *Registry) Ports(name string) ([]string, []string) {
+	c, err := r.Create(name)
+	if err != nil {
+		return nil, nil
+	}
+	return c.InPorts(), c.OutPorts()
+}
+
+// Names returns the sorted names of the registered components
+func (r *Registry) Names() []string {
+	r.RLock()
+	defer r.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Register adds a component factory to DefaultRegistry
+func Register(name string, factory Factory) error {
+	return DefaultRegistry.Register(name, factory)
+}
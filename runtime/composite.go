@@ -0,0 +1,102 @@
+package runtime
+
+import (
+	"sort"
+
+	"github.com/oleksandr/fbp"
+)
+
+//
+// Component made of a graph with exported ports (INPORT/OUTPORT), it runs
+// the network of the graph and passes packets between its own ports and
+// the exported ports of the network
+//
+type composite struct {
+	graph    *fbp.BaseFbp
+	registry *Registry
+}
+
+// Composite returns a factory of components running graph with components
+// from registry (DefaultRegistry if nil). The ports of the component are
+// the exported ports of the graph.
+func Composite(graph *fbp.BaseFbp, registry *Registry) Factory {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return func() Component {
+		return &composite{graph: graph, registry: registry}
+	}
+}
+
+func (c *composite) InPorts() []string {
+	return sortedNames(c.graph.Inports)
+}
+
+func (c *composite) OutPorts() []string {
+	return sortedNames(c.graph.Outports)
+}
+
+func (c *composite) Describe() *Description {
+	d := &Description{
+		Description: c.graph.Properties["description"],
+		Icon:        c.graph.Properties["icon"],
+		Subgraph:    true,
+	}
+	for _, port := range c.InPorts() {
+		d.InPorts = append(d.InPorts, PortDescription{Name: port, Type: "all"})
+	}
+	for _, port := range c.OutPorts() {
+		d.OutPorts = append(d.OutPorts, PortDescription{Name: port, Type: "all"})
+	}
+	return d
+}
+
+func (c *composite) Run(ports *Ports) error {
+	n, err := Load(c.graph, c.registry)
+	if err != nil {
+		return err
+	}
+	if err := n.StartContext(ports.Context()); err != nil {
+		return err
+	}
+	for _, name := range c.InPorts() {
+		go func(name string, in *InPort) {
+			defer n.CloseIn(name)
+			for {
+				v, ok := in.Receive()
+				if !ok {
+					return
+				}
+				select {
+				case n.In(name) <- v:
+				case <-n.Done():
+					return
+				}
+			}
+		}(name, ports.In(name))
+	}
+	done := make(chan struct{})
+	outports := c.OutPorts()
+	for _, name := range outports {
+		go func(ch <-chan interface{}, out *OutPort) {
+			for v := range ch {
+				out.Send(v)
+			}
+			done <- struct{}{}
+		}(n.Out(name), ports.Out(name))
+	}
+	err = n.Wait()
+	for range outports {
+		<-done
+	}
+	return err
+}
+
+func sortedNames(ports map[string]*fbp.Endpoint) []string {
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
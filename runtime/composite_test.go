@@ -0,0 +1,61 @@
+package runtime
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oleksandr/fbp"
+)
+
+func TestComposite(t *testing.T) {
+	packets := make(chan interface{}, 10)
+	registry := NewRegistry()
+	registry.Register("test/passthru", func() Component { return passthru{} })
+	registry.Register("test/collect", func() Component { return &collect{count: 10, packets: packets} })
+	registry.Register("test/chain", Composite(parse(t, `
+	INPORT=A.IN:IN
+	OUTPORT=B.OUT:OUT
+	A(test/passthru) OUT -> IN B(test/passthru)
+	`), registry))
+
+	d, err := registry.Describe("test/chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Description{
+		Subgraph: true,
+		InPorts:  []PortDescription{{Name: "IN", Type: "all"}},
+		OutPorts: []PortDescription{{Name: "OUT", Type: "all"}},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("Unexpected description %+v", d)
+	}
+	var components fbp.Components = registry
+	if in, out := components.Ports("test/chain"); !reflect.DeepEqual(in, []string{"IN"}) || !reflect.DeepEqual(out, []string{"OUT"}) {
+		t.Fatalf("Unexpected ports %v %v", in, out)
+	}
+	if d, _ := registry.Describe("test/passthru"); d.Subgraph || len(d.InPorts) != 1 || d.OutPorts[0].Name != "OUT" {
+		t.Fatalf("Unexpected description %+v", d)
+	}
+
+	n, err := Load(parse(t, `
+	'1' -> IN Chain(test/chain) OUT -> IN Collect(test/collect)
+	'2' -> IN Chain
+	`), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Start()
+	select {
+	case <-n.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Network did not terminate")
+	}
+	if err := n.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 || <-packets != "1" || <-packets != "2" {
+		t.Fatal("Unexpected packets")
+	}
+}
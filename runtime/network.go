@@ -0,0 +1,407 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/oleksandr/fbp"
+)
+
+var (
+	ErrRunning    = errors.New("runtime: network is running")
+	ErrNotRunning = errors.New("runtime: network is not running")
+)
+
+type process struct {
+	name      string
+	component Component
+	ports     *Ports
+}
+
+type initial struct {
+	data string
+	slot *slot
+}
+
+//
+// Network of processes built from a graph. Network implements fbp.Builder,
+// so it can be created with fbp.BaseFbp.Build() or by generated code.
+//
+// A network finishes by itself: when a process returns its out-ports are
+// closed, IIPs are closed once they are delivered, so the in-ports of the
+// downstream processes get closed as well (see the array ports notes for
+// the rules). Exported in-ports stay open until CloseIn() or Shutdown().
+// Stop() and the cancelling of the context given to StartContext() end
+// the network at once, packets on the way are lost.
+//
+type Network struct {
+	// Capacity is the buffer of the connections without one in metadata
+	Capacity int
+	// Overflow is the policy of the connections without one in metadata
+	Overflow Overflow
+
+	registry  *Registry
+	processes []*process
+	initials  []initial
+	inports   map[string]*slot
+	outports  map[string]*slot
+	closedIn  map[string]bool
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	wg      sync.WaitGroup
+	mutex   sync.Mutex
+	err     error
+}
+
+// NewNetwork returns an empty network with components from registry
+// (DefaultRegistry if nil)
+func NewNetwork(registry *Registry) *Network {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Network{
+		registry: registry,
+		inports:  make(map[string]*slot),
+		outports: make(map[string]*slot),
+		closedIn: make(map[string]bool),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Load returns the network of the graph with components from registry
+// (DefaultRegistry if nil)
+func Load(graph *fbp.BaseFbp, registry *Registry) (*Network, error) {
+	n := NewNetwork(registry)
+	if err := graph.Build(n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *Network) AddProcess(name, component string, metadata map[string]string) error {
+	if n.running {
+		return ErrRunning
+	}
+	if n.process(name) != nil {
+		return fmt.Errorf("runtime: process %q already exists", name)
+	}
+	c, err := n.registry.Create(component)
+	if err != nil {
+		return err
+	}
+	p := &process{
+		name:      name,
+		component: c,
+		ports: &Ports{
+			ctx: n.ctx,
+			in:  make(map[string]*InPort),
+			out: make(map[string]*OutPort),
+		},
+	}
+	for _, port := range c.InPorts() {
+		p.ports.in[port] = newInPort(port, n.ctx.Done())
+	}
+	for _, port := range c.OutPorts() {
+		p.ports.out[port] = newOutPort(port, n.ctx.Done())
+	}
+	n.processes = append(n.processes, p)
+	return nil
+}
+
+func (n *Network) Connect(src, tgt *fbp.Endpoint, metadata map[string]string) error {
+	if n.running {
+		return ErrRunning
+	}
+	out, err := n.outPort(src)
+	if err != nil {
+		return err
+	}
+	in, err := n.inPort(tgt)
+	if err != nil {
+		return err
+	}
+	capacity, overflow, err := n.buffer(src, metadata)
+	if err != nil {
+		return err
+	}
+	var errPort *OutPort
+	if overflow == OverflowError {
+		port := metadata[ErrorPortKey]
+		if port == "" {
+			port = "ERROR"
+		}
+		if errPort, err = n.outPort(&fbp.Endpoint{Process: src.Process, Port: port}); err != nil {
+			return err
+		}
+	}
+	s := in.slot(index(tgt))
+	s.grow(capacity)
+	c := out.connect(index(src), s)
+	c.overflow, c.errors = overflow, errPort
+	return nil
+}
+
+func (n *Network) AddInitial(data string, tgt *fbp.Endpoint) error {
+	if n.running {
+		return ErrRunning
+	}
+	in, err := n.inPort(tgt)
+	if err != nil {
+		return err
+	}
+	s := in.slot(index(tgt))
+	s.attach()
+	n.initials = append(n.initials, initial{data: data, slot: s})
+	return nil
+}
+
+func (n *Network) ExportInport(name string, e *fbp.Endpoint) error {
+	if n.running {
+		return ErrRunning
+	}
+	in, err := n.inPort(e)
+	if err != nil {
+		return err
+	}
+	s := in.slot(index(e))
+	s.attach()
+	n.inports[name] = s
+	return nil
+}
+
+func (n *Network) ExportOutport(name string, e *fbp.Endpoint) error {
+	if n.running {
+		return ErrRunning
+	}
+	out, err := n.outPort(e)
+	if err != nil {
+		return err
+	}
+	// the policies apply, except "error" as there is no process to blame
+	capacity, overflow, _ := n.buffer(e, nil)
+	s := newSlot()
+	s.grow(capacity)
+	c := out.connect(index(e), s)
+	if overflow != OverflowError {
+		c.overflow = overflow
+	}
+	n.outports[name] = s
+	return nil
+}
+
+// In returns the channel of an exported in-port or nil if there is no such
+// port. Nothing may be sent to the channel after CloseIn() or Shutdown().
+func (n *Network) In(name string) chan<- interface{} {
+	if s, ok := n.inports[name]; ok {
+		return s.ch
+	}
+	return nil
+}
+
+// CloseIn tells the network that nothing more is going to be sent to an
+// exported in-port
+func (n *Network) CloseIn(name string) error {
+	s, ok := n.inports[name]
+	if !ok {
+		return fmt.Errorf("runtime: unknown inport %q", name)
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if !n.closedIn[name] {
+		n.closedIn[name] = true
+		s.detach()
+	}
+	return nil
+}
+
+// Out returns the channel of an exported out-port or nil if there is no such port
+func (n *Network) Out(name string) <-chan interface{} {
+	if s, ok := n.outports[name]; ok {
+		return s.ch
+	}
+	return nil
+}
+
+// Start runs every process in a goroutine of its own and sends the IIPs
+func (n *Network) Start() error {
+	return n.StartContext(context.Background())
+}
+
+// StartContext starts the network (see Start), cancelling ctx stops it
+func (n *Network) StartContext(ctx context.Context) error {
+	if n.running {
+		return ErrRunning
+	}
+	n.running = true
+	go func() {
+		select {
+		case <-ctx.Done():
+			n.Stop()
+		case <-n.ctx.Done():
+		}
+	}()
+	for _, p := range n.processes {
+		for _, in := range p.ports.in {
+			in.start()
+		}
+	}
+	for _, p := range n.processes {
+		n.wg.Add(1)
+		go n.run(p)
+	}
+	go func() {
+		n.wg.Wait()
+		close(n.done)
+	}()
+
+	// IIPs to the same slot are sent in the order they were added
+	var slots []*slot
+	initials := make(map[*slot][]string)
+	for _, iip := range n.initials {
+		if _, ok := initials[iip.slot]; !ok {
+			slots = append(slots, iip.slot)
+		}
+		initials[iip.slot] = append(initials[iip.slot], iip.data)
+	}
+	for _, s := range slots {
+		// every IIP is a writer of the slot, detached once delivered
+		go func(s *slot, data []string) {
+			for i, d := range data {
+				select {
+				case s.ch <- d:
+					s.detach()
+				case <-n.ctx.Done():
+					for range data[i:] {
+						s.detach()
+					}
+					return
+				}
+			}
+		}(s, initials[s])
+	}
+	return nil
+}
+
+func (n *Network) run(p *process) {
+	defer n.wg.Done()
+	defer func() {
+		// downstream processes learn that this one is done
+		for _, out := range p.ports.out {
+			out.Close()
+		}
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			n.fail(fmt.Errorf("runtime: process %q panicked: %v", p.name, r))
+		}
+	}()
+	if err := p.component.Run(p.ports); err != nil {
+		n.fail(fmt.Errorf("runtime: process %q: %v", p.name, err))
+	}
+}
+
+func (n *Network) fail(err error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.err == nil {
+		n.err = err
+	}
+}
+
+// Done returns a channel that is closed when all the processes have returned
+func (n *Network) Done() <-chan struct{} {
+	return n.done
+}
+
+// Wait blocks until all the processes have returned and returns the first
+// error a process has returned (or panicked with)
+func (n *Network) Wait() error {
+	if !n.running {
+		return ErrNotRunning
+	}
+	<-n.done
+	n.Stop()
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.err
+}
+
+// Stop asks the processes to return at once: Receive and Send of their
+// ports return false and their context is cancelled
+func (n *Network) Stop() {
+	n.cancel()
+}
+
+// Shutdown closes the exported in-ports and waits for the network to finish
+// processing the packets it already has. If ctx is done first the network
+// is stopped and the error of ctx is returned.
+func (n *Network) Shutdown(ctx context.Context) error {
+	if !n.running {
+		return ErrNotRunning
+	}
+	for name := range n.inports {
+		n.CloseIn(name)
+	}
+	select {
+	case <-n.done:
+		return n.Wait()
+	case <-ctx.Done():
+		n.Stop()
+		<-n.done
+		return ctx.Err()
+	}
+}
+
+// index returns the slot of an endpoint
+func index(e *fbp.Endpoint) int {
+	if e.Index == nil {
+		return NoIndex
+	}
+	return *e.Index
+}
+
+func (n *Network) process(name string) *process {
+	for _, p := range n.processes {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// inPort returns the in-port of an endpoint, port names are case insensitive
+// as they are in NoFlo
+func (n *Network) inPort(e *fbp.Endpoint) (*InPort, error) {
+	p := n.process(e.Process)
+	if p == nil {
+		return nil, fmt.Errorf("runtime: unknown process %q", e.Process)
+	}
+	for name, port := range p.ports.in {
+		if strings.EqualFold(name, e.Port) {
+			return port, nil
+		}
+	}
+	return nil, fmt.Errorf("runtime: process %q has no in-port %q", e.Process, e.Port)
+}
+
+func (n *Network) outPort(e *fbp.Endpoint) (*OutPort, error) {
+	p := n.process(e.Process)
+	if p == nil {
+		return nil, fmt.Errorf("runtime: unknown process %q", e.Process)
+	}
+	for name, port := range p.ports.out {
+		if strings.EqualFold(name, e.Port) {
+			return port, nil
+		}
+	}
+	return nil, fmt.Errorf("runtime: process %q has no out-port %q", e.Process, e.Port)
+}
@@ -0,0 +1,403 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/oleksandr/fbp"
+)
+
+//
+// Test components
+//
+type passthru struct{}
+
+func (passthru) InPorts() []string  { return []string{"IN"} }
+func (passthru) OutPorts() []string { return []string{"OUT"} }
+
+func (passthru) Run(ports *Ports) error {
+	for {
+		v, ok := ports.In("IN").Receive()
+		if !ok {
+			return nil
+		}
+		if !ports.Out("OUT").Send(v) {
+			return nil
+		}
+	}
+}
+
+type collect struct {
+	count   int
+	packets chan interface{}
+}
+
+func (c *collect) InPorts() []string  { return []string{"IN"} }
+func (c *collect) OutPorts() []string { return nil }
+
+func (c *collect) Run(ports *Ports) error {
+	for i := 0; i < c.count; i++ {
+		v, ok := ports.In("IN").Receive()
+		if !ok {
+			return nil
+		}
+		c.packets <- v
+	}
+	return nil
+}
+
+type failing struct{}
+
+func (failing) InPorts() []string      { return []string{"IN"} }
+func (failing) OutPorts() []string     { return nil }
+func (failing) Run(ports *Ports) error { return errors.New("broken") }
+
+func parse(t *testing.T, graph string) *fbp.BaseFbp {
+	parser := &fbp.Fbp{Buffer: graph}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		t.Fatal(err)
+	}
+	parser.Execute()
+	return &parser.BaseFbp
+}
+
+func receive(t *testing.T, ch <-chan interface{}) interface{} {
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatal("Timeout")
+	}
+	return nil
+}
+
+func TestNetwork(t *testing.T) {
+	packets := make(chan interface{}, 10)
+	registry := NewRegistry()
+	registry.Register("test/passthru", func() Component { return passthru{} })
+	registry.Register("test/collect", func() Component { return &collect{count: 2, packets: packets} })
+
+	n, err := Load(parse(t, `
+	'hello' -> IN Forward(test/passthru) OUT -> IN Collect(test/collect)
+	'world' -> IN Forward
+	`), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if v := receive(t, packets); v != "hello" {
+		t.Fatalf("Unexpected packet %v", v)
+	}
+	if v := receive(t, packets); v != "world" {
+		t.Fatalf("Unexpected packet %v", v)
+	}
+	n.Stop()
+	if err := n.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Start(); err != ErrRunning {
+		t.Fatal("Network should not start twice")
+	}
+}
+
+func TestNetworkExportedPorts(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("test/passthru", func() Component { return passthru{} })
+
+	n, err := Load(parse(t, `
+	INPORT=First.IN:IN
+	OUTPORT=Second.OUT:OUT
+	First(test/passthru) OUT -> IN Second(test/passthru)
+	`), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.In("IN") == nil || n.Out("OUT") == nil || n.In("OUT") != nil {
+		t.Fatal("Unexpected exported ports")
+	}
+	n.Start()
+	n.In("IN") <- 42
+	if v := receive(t, n.Out("OUT")); v != 42 {
+		t.Fatalf("Unexpected packet %v", v)
+	}
+	n.Stop()
+	n.Wait()
+}
+
+func TestNetworkErrors(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("test/passthru", func() Component { return passthru{} })
+	registry.Register("test/failing", func() Component { return failing{} })
+	if err := registry.Register("test/failing", func() Component { return failing{} }); err == nil {
+		t.Fatal("Component should not be registered twice")
+	}
+
+	for _, graph := range []string{
+		`'x' -> IN A(test/unknown)`,
+		`'x' -> DATA A(test/passthru)`,
+		`A(test/passthru) ERROR -> IN B(test/passthru)`,
+		`'x' -> IN A`,
+	} {
+		if _, err := Load(parse(t, graph), registry); err == nil {
+			t.Fatalf("Graph should not load: %s", graph)
+		} else {
+			t.Log(err)
+		}
+	}
+
+	n, err := Load(parse(t, `'x' -> IN A(test/failing)`), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Start()
+	if err := n.Wait(); err == nil || err.Error() != `runtime: process "A": broken` {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+//
+// Component that records everything it receives from an array port
+//
+type gather struct {
+	mode    string
+	slots   chan []int
+	packets chan string
+}
+
+func (g *gather) InPorts() []string  { return []string{"IN"} }
+func (g *gather) OutPorts() []string { return nil }
+
+func (g *gather) Run(ports *Ports) error {
+	in := ports.In("IN")
+	g.slots <- in.Slots()
+	for !in.Closed() {
+		var (
+			v     interface{}
+			index int
+			ok    bool
+		)
+		switch g.mode {
+		case "roundrobin":
+			v, index, ok = in.ReceiveRoundRobin()
+		default:
+			v, index, ok = in.ReceiveAny()
+		}
+		if ok {
+			g.packets <- fmt.Sprintf("%v from %d", v, index)
+		} else if index != NoIndex {
+			g.packets <- fmt.Sprintf("%d closed", index)
+		}
+	}
+	return nil
+}
+
+//
+// Component that sends IIPs it gets to the slots of an array port
+//
+type scatter struct{}
+
+func (scatter) InPorts() []string  { return []string{"IN"} }
+func (scatter) OutPorts() []string { return []string{"OUT"} }
+
+func (scatter) Run(ports *Ports) error {
+	out := ports.Out("OUT")
+	for {
+		v, ok := ports.In("IN").Receive()
+		if !ok {
+			return nil
+		}
+		switch v {
+		case "all":
+			out.Send(v)
+		case "close":
+			out.CloseSlot(1)
+		case "done":
+			out.Close()
+			return nil
+		default:
+			i, _ := strconv.Atoi(v.(string))
+			out.SendTo(i, v)
+		}
+	}
+}
+
+func TestArrayPorts(t *testing.T) {
+	for _, mode := range []string{"any", "roundrobin"} {
+		g := &gather{mode: mode, slots: make(chan []int, 1), packets: make(chan string, 10)}
+		registry := NewRegistry()
+		registry.Register("test/scatter", func() Component { return scatter{} })
+		registry.Register("test/gather", func() Component { return g })
+
+		n, err := Load(parse(t, `
+		'0' -> IN Scatter(test/scatter)
+		'1' -> IN Scatter
+		'5' -> IN Scatter
+		'close' -> IN Scatter
+		'all' -> IN Scatter
+		'done' -> IN Scatter
+		Scatter OUT[0] -> IN[0] Gather(test/gather)
+		Scatter OUT[1] -> IN[1] Gather
+		`), registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Start()
+		if slots := <-g.slots; !reflect.DeepEqual(slots, []int{0, 1}) {
+			t.Fatalf("Unexpected slots %v", slots)
+		}
+		var packets []string
+		for i := 0; i < 5; i++ {
+			select {
+			case p := <-g.packets:
+				packets = append(packets, p)
+			case <-time.After(time.Second):
+				t.Fatalf("Timeout in %s mode, received %v", mode, packets)
+			}
+		}
+		// nothing goes to unconnected index 5, "all" goes to open slots only
+		expected := []string{"0 closed", "0 from 0", "1 closed", "1 from 1", "all from 0"}
+		sort.Strings(packets)
+		if !reflect.DeepEqual(packets, expected) {
+			t.Fatalf("Unexpected packets in %s mode: %v", mode, packets)
+		}
+		n.Stop()
+		if err := n.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNetworkTermination(t *testing.T) {
+	packets := make(chan interface{}, 10)
+	registry := NewRegistry()
+	registry.Register("test/passthru", func() Component { return passthru{} })
+	registry.Register("test/collect", func() Component { return &collect{count: 10, packets: packets} })
+
+	// no Stop: closing of the IIPs goes down the chain
+	n, err := Load(parse(t, `
+	'1' -> IN A(test/passthru) OUT -> IN B(test/passthru) OUT -> IN C(test/collect)
+	'2' -> IN A
+	`), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Wait(); err != ErrNotRunning {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	n.Start()
+	select {
+	case <-n.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Network did not terminate")
+	}
+	if err := n.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 2 {
+		t.Fatalf("Unexpected number of packets %d", len(packets))
+	}
+}
+
+func TestNetworkContext(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("test/passthru", func() Component { return passthru{} })
+
+	n, err := Load(parse(t, `
+	INPORT=A.IN:IN
+	A(test/passthru) OUT -> IN B(test/passthru)
+	`), registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n.StartContext(ctx)
+	n.In("IN") <- 1
+	cancel()
+	select {
+	case <-n.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Network did not stop")
+	}
+	if err := n.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//
+// Component that waits for a signal before taking every packet
+//
+type slow struct {
+	proceed chan struct{}
+	packets chan interface{}
+}
+
+func (s *slow) InPorts() []string  { return []string{"IN"} }
+func (s *slow) OutPorts() []string { return nil }
+
+func (s *slow) Run(ports *Ports) error {
+	for {
+		select {
+		case <-s.proceed:
+		case <-ports.Context().Done():
+			return nil
+		}
+		v, ok := ports.In("IN").Receive()
+		if !ok {
+			return nil
+		}
+		s.packets <- v
+	}
+}
+
+func TestNetworkShutdown(t *testing.T) {
+	for _, drain := range []bool{true, false} {
+		s := &slow{proceed: make(chan struct{}), packets: make(chan interface{}, 10)}
+		registry := NewRegistry()
+		registry.Register("test/passthru", func() Component { return passthru{} })
+		registry.Register("test/slow", func() Component { return s })
+
+		n, err := Load(parse(t, `
+		INPORT=A.IN:IN
+		A(test/passthru) OUT -> IN B(test/slow)
+		`), registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Start()
+		// A takes the packet and waits for B to be ready
+		n.In("IN") <- 1
+
+		if drain {
+			go func() {
+				for i := 0; i < 2; i++ {
+					s.proceed <- struct{}{}
+				}
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			err = n.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.packets) != 1 {
+				t.Fatalf("Unexpected number of packets %d", len(s.packets))
+			}
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			err = n.Shutdown(ctx)
+			cancel()
+			if err != context.DeadlineExceeded {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package runtime
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Array ports
+//
+// A port used with an index in the graph (e.g. "OUT[1] -> IN[0] Log") is an
+// array port. Every index is a slot with a channel of its own, connections
+// without an index share the slot NoIndex of the port.
+//
+// Out-ports: Send broadcasts a packet to all the connections of the port
+// (every slot), SendTo delivers it to the connections of one slot only.
+// Packets sent to an index without connections are dropped, the same way
+// as packets sent to a port without connections.
+//
+// In-ports: Slots lists the indexes with connections, Slot and ReceiveFrom
+// read from one of them. Receive merges all the slots of the port in order
+// of arrival, ReceiveAny does the same and tells the index the packet came
+// from, ReceiveRoundRobin takes turns over the slots in index order. An
+// index without connections has no channel: Slot returns nil and
+// ReceiveFrom returns false right away.
+//
+// A slot is closed when all its writers (connections, IIPs, exported port)
+// are closed, a slot without writers is closed when the network starts.
+// ReceiveAny and ReceiveRoundRobin report the closing of a slot once by
+// returning false with its index, Closed reports whether all the slots of
+// the port are closed (or the network stopped).
+
+// NoIndex is the slot of the connections without an index
+const NoIndex = -1
+
+//
+// Channel of an in-port slot, closed when its last writer is detached
+//
+type slot struct {
+	ch      chan interface{}
+	mutex   sync.Mutex
+	writers int
+}
+
+func newSlot() *slot {
+	return &slot{ch: make(chan interface{})}
+}
+
+// grow makes the channel buffer at least capacity packets, the network must
+// not be running
+func (s *slot) grow(capacity int) {
+	if capacity > cap(s.ch) {
+		s.ch = make(chan interface{}, capacity)
+	}
+}
+
+func (s *slot) attach() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writers++
+}
+
+func (s *slot) detach() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.writers--
+	if s.writers == 0 {
+		close(s.ch)
+	}
+}
+
+// closeUnused closes the channel of a slot nobody writes to
+func (s *slot) closeUnused() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.writers == 0 {
+		close(s.ch)
+		return true
+	}
+	return false
+}
+
+//
+// Connection from an out-port to an in-port slot
+//
+type connection struct {
+	target   *slot
+	overflow Overflow
+	errors   *OutPort
+	closed   bool
+}
+
+// send delivers v according to the overflow policy, it returns false when
+// the network is stopped
+func (c *connection) send(v interface{}, quit <-chan struct{}) bool {
+	if c.overflow == OverflowBlock {
+		select {
+		case c.target.ch <- v:
+			return true
+		case <-quit:
+			return false
+		}
+	}
+	for {
+		select {
+		case c.target.ch <- v:
+			return true
+		default:
+		}
+		switch c.overflow {
+		case OverflowDropNewest:
+			return true
+		case OverflowDropOldest:
+			select {
+			case <-c.target.ch:
+			default:
+			}
+		case OverflowError:
+			return c.errors.Send(v)
+		}
+	}
+}
+
+func (c *connection) close() {
+	if !c.closed {
+		c.closed = true
+		c.target.detach()
+	}
+}
+
+//
+// InPort of a running process. The receiving methods keep state and must
+// be called from the process goroutine only.
+//
+type InPort struct {
+	name  string
+	slots map[int]*slot
+	quit  <-chan struct{}
+
+	closed map[int]bool
+	next   int
+}
+
+func newInPort(name string, quit <-chan struct{}) *InPort {
+	return &InPort{
+		name:   name,
+		slots:  map[int]*slot{NoIndex: newSlot()},
+		quit:   quit,
+		closed: make(map[int]bool),
+	}
+}
+
+func (p *InPort) Name() string {
+	return p.name
+}
+
+// slot returns the slot of an index, creating it if necessary
+func (p *InPort) slot(index int) *slot {
+	s, ok := p.slots[index]
+	if !ok {
+		s = newSlot()
+		p.slots[index] = s
+	}
+	return s
+}
+
+// start closes the slots without writers, their closing is not reported
+func (p *InPort) start() {
+	for index, s := range p.slots {
+		if s.closeUnused() {
+			p.closed[index] = true
+		}
+	}
+}
+
+// indexes returns the indexes of all the slots, NoIndex first
+func (p *InPort) indexes() []int {
+	indexes := make([]int, 0, len(p.slots))
+	for index := range p.slots {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// Chan returns the channel of the connections without an index, to be used
+// in select statements
+func (p *InPort) Chan() <-chan interface{} {
+	return p.slots[NoIndex].ch
+}
+
+// Slots returns the connected indexes of an array port in ascending order
+func (p *InPort) Slots() []int {
+	var indexes []int
+	for _, index := range p.indexes() {
+		if index != NoIndex {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// Slot returns the channel of an index or nil if it has no connections
+func (p *InPort) Slot(index int) <-chan interface{} {
+	if s, ok := p.slots[index]; ok && index != NoIndex {
+		return s.ch
+	}
+	return nil
+}
+
+// ReceiveFrom waits for the next packet of one slot. It returns false when
+// the slot is closed (or has no connections) or the network is stopped.
+func (p *InPort) ReceiveFrom(index int) (interface{}, bool) {
+	s, ok := p.slots[index]
+	if !ok || p.closed[index] {
+		return nil, false
+	}
+	select {
+	case v, ok := <-s.ch:
+		if !ok {
+			p.closed[index] = true
+		}
+		return v, ok
+	case <-p.quit:
+		return nil, false
+	}
+}
+
+// Receive waits for the next packet from any slot. It returns false when
+// all the slots are closed or the network is stopped.
+func (p *InPort) Receive() (interface{}, bool) {
+	for {
+		v, _, ok := p.ReceiveAny()
+		if ok || p.Closed() {
+			return v, ok
+		}
+	}
+}
+
+// ReceiveAny waits for the next packet from any slot and returns its index.
+// When a slot gets closed it returns false with the index of the slot,
+// when there is nothing more to receive it returns false and Closed is true.
+func (p *InPort) ReceiveAny() (interface{}, int, bool) {
+	indexes := []int{}
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(p.quit)}}
+	for _, index := range p.indexes() {
+		if !p.closed[index] {
+			indexes = append(indexes, index)
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(p.slots[index].ch)})
+		}
+	}
+	if len(indexes) == 0 {
+		return nil, NoIndex, false
+	}
+	chosen, v, ok := reflect.Select(cases)
+	if chosen == 0 {
+		return nil, NoIndex, false
+	}
+	index := indexes[chosen-1]
+	if !ok {
+		p.closed[index] = true
+		return nil, index, false
+	}
+	return v.Interface(), index, true
+}
+
+// ReceiveRoundRobin waits for a packet from the slots in turn (in index
+// order), closing of slots is reported the same way as by ReceiveAny
+func (p *InPort) ReceiveRoundRobin() (interface{}, int, bool) {
+	indexes := p.indexes()
+	for i := range indexes {
+		index := indexes[(p.next+i)%len(indexes)]
+		if p.closed[index] {
+			continue
+		}
+		p.next = (p.next + i + 1) % len(indexes)
+		v, ok := p.ReceiveFrom(index)
+		return v, index, ok
+	}
+	return nil, NoIndex, false
+}
+
+// Closed reports whether all the slots of the port are closed or the
+// network is stopped
+func (p *InPort) Closed() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+	}
+	for index := range p.slots {
+		if !p.closed[index] {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// OutPort of a running process. The sending methods must be called from
+// the process goroutine only.
+//
+type OutPort struct {
+	name        string
+	connections map[int][]*connection
+	quit        <-chan struct{}
+}
+
+func newOutPort(name string, quit <-chan struct{}) *OutPort {
+	return &OutPort{
+		name:        name,
+		connections: make(map[int][]*connection),
+		quit:        quit,
+	}
+}
+
+func (p *OutPort) Name() string {
+	return p.name
+}
+
+// connect adds a connection to an in-port slot from an index of the port
+func (p *OutPort) connect(index int, target *slot) *connection {
+	target.attach()
+	c := &connection{target: target}
+	p.connections[index] = append(p.connections[index], c)
+	return c
+}
+
+// Connected reports whether the port has any connection
+func (p *OutPort) Connected() bool {
+	return len(p.connections) > 0
+}
+
+// Slots returns the connected indexes of an array port in ascending order
+func (p *OutPort) Slots() []int {
+	var indexes []int
+	for index := range p.connections {
+		if index != NoIndex {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// Send delivers v to all the connections of the port (broadcast to all the
+// slots of an array port). It returns false when the network is stopped.
+func (p *OutPort) Send(v interface{}) bool {
+	if !p.SendTo(NoIndex, v) {
+		return false
+	}
+	for _, index := range p.Slots() {
+		if !p.SendTo(index, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// SendTo delivers v to the connections of one slot, packets sent to an
+// index without connections are dropped. It returns false when the network
+// is stopped.
+func (p *OutPort) SendTo(index int, v interface{}) bool {
+	for _, c := range p.connections[index] {
+		if !c.closed && !c.send(v, p.quit) {
+			return false
+		}
+	}
+	return true
+}
+
+// CloseSlot closes the connections of one slot, the receiving slots get
+// closed once all their writers are closed
+func (p *OutPort) CloseSlot(index int) {
+	for _, c := range p.connections[index] {
+		c.close()
+	}
+}
+
+// Close closes all the connections of the port
+func (p *OutPort) Close() {
+	for index := range p.connections {
+		p.CloseSlot(index)
+	}
+}
+
+//
+// Ports of a running process, as declared by its component
+//
+type Ports struct {
+	ctx context.Context
+	in  map[string]*InPort
+	out map[string]*OutPort
+}
+
+// Context returns the context of the network, it is done when the network
+// is stopped
+func (p *Ports) Context() context.Context {
+	return p.ctx
+}
+
+// In returns the named in-port or nil if the component doesn't declare it
+func (p *Ports) In(name string) *InPort {
+	return p.in[name]
+}
+
+// Out returns the named out-port or nil if the component doesn't declare it
+func (p *Ports) Out(name string) *OutPort {
+	return p.out[name]
+}
@@ -0,0 +1,195 @@
+package fbp
+
+import (
+	"sort"
+	"strings"
+)
+
+//
+// Symbol is an occurrence of a process name in a .fbp source: in a
+// connection, where it may declare the component, or in an INPORT/OUTPORT
+// line (Export). Offsets are in bytes.
+//
+type Symbol struct {
+	Name       string
+	Begin, End int
+	Component  string // if the occurrence declares it
+	Metadata   string // as written after the component name
+	Export     bool
+}
+
+//
+// INPORT or OUTPORT line, begin is the offset of the process name
+//
+type export struct {
+	kind      string
+	name      string
+	process   *Symbol
+	port      string
+	begin     int
+	nameBegin int
+}
+
+//
+// Processes and exported ports of a parsed source
+//
+type symbolIndex struct {
+	names     []string // in order of the first occurrence
+	processes map[string][]*Symbol
+	exports   []*export
+}
+
+func newSymbolIndex(root *syntaxNode, buffer string) *symbolIndex {
+	index := &symbolIndex{processes: make(map[string][]*Symbol)}
+	for _, line := range root.children {
+		if line.rule != ruleline {
+			continue
+		}
+		if c := line.child(ruleconnection); c != nil {
+			c.walk(func(n *syntaxNode) {
+				if n.rule == rulenode {
+					index.addNode(n, buffer)
+				}
+			})
+			continue
+		}
+		directive := strings.TrimSpace(line.text(buffer))
+		for _, kind := range []string{"INPORT", "OUTPORT"} {
+			if !strings.HasPrefix(strings.ToUpper(directive), kind+"=") {
+				continue
+			}
+			for _, c := range line.children {
+				if c.rule != rulePegText {
+					continue
+				}
+				// Process.PORT:NAME
+				text := c.text(buffer)
+				parts, ok := splitExport(text)
+				if !ok {
+					continue
+				}
+				index.exports = append(index.exports, &export{
+					kind:      kind,
+					name:      text[parts.colon+1 : parts.end],
+					process:   &Symbol{Name: text[:parts.dot], Begin: c.begin, End: c.begin + parts.dot, Export: true},
+					port:      text[parts.dot+1 : parts.colon],
+					begin:     c.begin,
+					nameBegin: c.begin + parts.colon + 1,
+				})
+			}
+		}
+	}
+	return index
+}
+
+func (index *symbolIndex) addNode(n *syntaxNode, buffer string) {
+	var name *syntaxNode
+	for _, c := range n.children {
+		if c.rule == rulePegText {
+			name = c
+			break
+		}
+	}
+	if name == nil {
+		return
+	}
+	s := &Symbol{Name: name.text(buffer), Begin: name.begin, End: name.end}
+	if c := n.child(rulecomponent); c != nil {
+		if captures := c.captures(buffer); len(captures) > 0 {
+			s.Component = captures[0]
+		}
+		if meta := c.child(rulecompMeta); meta != nil {
+			s.Metadata = meta.captures(buffer)[0]
+		}
+	}
+	if _, ok := index.processes[s.Name]; !ok {
+		index.names = append(index.names, s.Name)
+	}
+	index.processes[s.Name] = append(index.processes[s.Name], s)
+}
+
+// declaration returns the occurrence that declares the component of a
+// process (the first one, as createNode() has it) or nil
+func (index *symbolIndex) declaration(name string) *Symbol {
+	for _, s := range index.processes[name] {
+		if s.Component != "" {
+			return s
+		}
+	}
+	return nil
+}
+
+// references returns the occurrences of a process, exports included, in
+// the order of the source
+func (index *symbolIndex) references(name string) []*Symbol {
+	references := append([]*Symbol(nil), index.processes[name]...)
+	for _, e := range index.exports {
+		if e.process.Name == name {
+			references = append(references, e.process)
+		}
+	}
+	sort.SliceStable(references, func(i, j int) bool {
+		return references[i].Begin < references[j].Begin
+	})
+	return references
+}
+
+//
+// Symbols are the process names of a .fbp source, for editors and
+// refactorings
+//
+type Symbols struct {
+	index *symbolIndex
+}
+
+// ParseSymbols parses src and returns its symbols
+func ParseSymbols(src []byte) (*Symbols, error) {
+	parser := &Fbp{Buffer: string(src)}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		return nil, err
+	}
+	return &Symbols{index: newSymbolIndex(parser.syntaxTree(), parser.Buffer)}, nil
+}
+
+// Names returns the process names in the order of their first occurrence
+func (s *Symbols) Names() []string {
+	return append([]string(nil), s.index.names...)
+}
+
+// At returns the occurrence at offset (its end included, where the cursor
+// is after typing a name) or nil
+func (s *Symbols) At(offset int) *Symbol {
+	for _, name := range s.index.names {
+		for _, symbol := range s.index.processes[name] {
+			if symbol.Begin <= offset && offset <= symbol.End {
+				return symbol
+			}
+		}
+	}
+	for _, e := range s.index.exports {
+		if e.process.Begin <= offset && offset <= e.process.End {
+			return e.process
+		}
+	}
+	return nil
+}
+
+// Declaration returns the occurrence declaring the component of a process,
+// the first one if there are several, or nil
+func (s *Symbols) Declaration(name string) *Symbol {
+	return s.index.declaration(name)
+}
+
+// References returns all occurrences of a process in the order of the source
+func (s *Symbols) References(name string) []*Symbol {
+	return s.index.references(name)
+}
+
+// walk calls f for every node of the subtree, depth first
+func (n *syntaxNode) walk(f func(*syntaxNode)) {
+	for _, c := range n.children {
+		f(c)
+		c.walk(f)
+	}
+}
@@ -0,0 +1,60 @@
+package fbp
+
+import (
+	"testing"
+)
+
+func TestSymbols(t *testing.T) {
+	src := "INPORT=Read.IN:FILE\n'x' -> IN Read(fs/read:a=1) OUT -> IN Display\nRead ERROR -> IN Display(core/out)\n"
+	symbols, err := ParseSymbols([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := symbols.Names(); len(names) != 2 || names[0] != "Read" || names[1] != "Display" {
+		t.Fatalf("Unexpected names %v", names)
+	}
+
+	read := symbols.At(7)
+	if read == nil || read.Name != "Read" || !read.Export || read.End != 11 {
+		t.Fatalf("Unexpected symbol %+v", read)
+	}
+	if s := symbols.At(0); s != nil {
+		t.Fatalf("Unexpected symbol %+v", s)
+	}
+	if d := symbols.Declaration("Read"); d == nil || d.Component != "fs/read" || d.Metadata != "a=1" || d.Begin != 30 {
+		t.Fatalf("Unexpected declaration %+v", d)
+	}
+	if d := symbols.Declaration("Display"); d == nil || d.Begin != 83 {
+		t.Fatalf("Unexpected declaration %+v", d)
+	}
+
+	var begins []int
+	for _, s := range symbols.References("Read") {
+		if src[s.Begin:s.End] != "Read" {
+			t.Fatalf("Unexpected reference %+v", s)
+		}
+		begins = append(begins, s.Begin)
+	}
+	if len(begins) != 3 || begins[0] != 7 || begins[1] != 30 || begins[2] != 66 {
+		t.Fatalf("Unexpected references %v", begins)
+	}
+
+	if _, err := ParseSymbols([]byte("A OUT ->\n")); err == nil {
+		t.Fatal("Expected syntax error")
+	}
+}
+
+func TestSymbolsLowerCaseDirective(t *testing.T) {
+	symbols, err := ParseSymbols([]byte("inport=Read.IN:FILE\noutport=Read.OUT:OUT\nRead(fs/read) ERROR -> IN Log(core/log)\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if references := symbols.References("Read"); len(references) != 3 || !references[0].Export || !references[1].Export {
+		t.Fatalf("Unexpected references %v", references)
+	}
+
+	diagnostics := Check([]byte("inport=Ghost.IN:X\nA(a) OUT -> IN B(b)\n"))
+	if len(diagnostics) != 1 || diagnostics[0].String() != "1:8: error: INPORT of unknown process Ghost" {
+		t.Fatalf("Unexpected diagnostics %v", diagnostics)
+	}
+}
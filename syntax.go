@@ -0,0 +1,91 @@
+package fbp
+
+//
+// Concrete syntax tree node built from the parser's token tree. Unlike
+// Execute() it keeps everything the grammar has matched, comments and
+// whitespace included. Offsets are in bytes of the parsed Buffer.
+//
+type syntaxNode struct {
+	rule       pegRule
+	begin, end int
+	children   []*syntaxNode
+}
+
+// syntaxTree returns the concrete syntax tree of the last successful Parse()
+func (p *Fbp) syntaxTree() *syntaxNode {
+	// token offsets are rune indexes into p.buffer
+	offsets := make([]int, 0, len(p.Buffer)+1)
+	for i := range p.Buffer {
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(p.Buffer), len(p.Buffer))
+
+	var convert func(n *node32) []*syntaxNode
+	convert = func(n *node32) []*syntaxNode {
+		var nodes []*syntaxNode
+		for ; n != nil; n = n.next {
+			switch {
+			case n.pegRule >= ruleAction0 && n.pegRule <= ruleAction14:
+				continue
+			case n.pegRule == rulePre_ || n.pegRule == rule_In_ || n.pegRule == rule_Suf:
+				// literal text, covered by the gaps between children
+				continue
+			}
+			nodes = append(nodes, &syntaxNode{
+				rule:     n.pegRule,
+				begin:    offsets[n.begin],
+				end:      offsets[n.end],
+				children: convert(n.up),
+			})
+		}
+		return nodes
+	}
+
+	root := &syntaxNode{rule: rulestart, begin: 0, end: len(p.Buffer)}
+	if ast := p.tokenTree.AST(); ast != nil {
+		if ast.pegRule == rulestart {
+			root.children = convert(ast.up)
+		} else {
+			root.children = convert(ast)
+		}
+	}
+	return root
+}
+
+func (n *syntaxNode) text(buffer string) string {
+	return buffer[n.begin:n.end]
+}
+
+// child returns the first direct child of the given rule
+func (n *syntaxNode) child(rule pegRule) *syntaxNode {
+	for _, c := range n.children {
+		if c.rule == rule {
+			return c
+		}
+	}
+	return nil
+}
+
+// find returns the first node of the given rule in the subtree (depth first)
+func (n *syntaxNode) find(rule pegRule) *syntaxNode {
+	for _, c := range n.children {
+		if c.rule == rule {
+			return c
+		}
+		if f := c.find(rule); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// captures returns the text of all PegText children
+func (n *syntaxNode) captures(buffer string) []string {
+	var texts []string
+	for _, c := range n.children {
+		if c.rule == rulePegText {
+			texts = append(texts, c.text(buffer))
+		}
+	}
+	return texts
+}
@@ -0,0 +1,256 @@
+package fbp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//
+// Kind of a token of .fbp source
+//
+type TokenKind int
+
+const (
+	TokenProcess TokenKind = iota + 1
+	TokenComponent
+	TokenMetadata
+	TokenPort
+	TokenIndex
+	TokenIIP
+	TokenComment
+	TokenArrow
+	TokenDirective
+	// a line that doesn't parse
+	TokenInvalid
+)
+
+var tokenKindNames = map[TokenKind]string{
+	TokenProcess:   "process",
+	TokenComponent: "component",
+	TokenMetadata:  "metadata",
+	TokenPort:      "port",
+	TokenIndex:     "index",
+	TokenIIP:       "iip",
+	TokenComment:   "comment",
+	TokenArrow:     "arrow",
+	TokenDirective: "directive",
+	TokenInvalid:   "invalid",
+}
+
+func (k TokenKind) String() string {
+	if name, ok := tokenKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("TokenKind(%d)", int(k))
+}
+
+func (k TokenKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *TokenKind) UnmarshalText(text []byte) error {
+	for kind, name := range tokenKindNames {
+		if name == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("fbp: unknown token kind %q", text)
+}
+
+//
+// Token of .fbp source, Offset and Length are in bytes. Whitespace and the
+// punctuation around components, indexes and exported ports aren't tokens.
+//
+type Token struct {
+	Kind   TokenKind `json:"kind"`
+	Offset int       `json:"offset"`
+	Length int       `json:"length"`
+}
+
+// Text returns the text of the token in src
+func (t Token) Text(src []byte) string {
+	return string(src[t.Offset : t.Offset+t.Length])
+}
+
+//
+// TokenIterator yields the tokens of a source in order:
+//
+//	it := fbp.NewTokenIterator(src)
+//	for it.Next() {
+//		token := it.Token()
+//	}
+//
+type TokenIterator struct {
+	tokens []Token
+	next   int
+}
+
+// NewTokenIterator returns an iterator over the tokens of src
+func NewTokenIterator(src []byte) *TokenIterator {
+	return &TokenIterator{tokens: Tokenize(src)}
+}
+
+// Next advances to the next token, it returns false at the end
+func (it *TokenIterator) Next() bool {
+	if it.next >= len(it.tokens) {
+		return false
+	}
+	it.next++
+	return true
+}
+
+// Token returns the current token
+func (it *TokenIterator) Token() Token {
+	return it.tokens[it.next-1]
+}
+
+// Tokenize returns the tokens of src. Lines that don't parse (e.g. the one
+// being typed) are TokenInvalid, the others are tokenized as usual. An IIP
+// spanning lines parses with its lines.
+func Tokenize(src []byte) []Token {
+	buffer := string(src)
+	parser := &Fbp{Buffer: buffer}
+	parser.Init()
+	if err := parser.Parse(); err == nil {
+		return tokenizeTree(parser.syntaxTree(), buffer)
+	}
+
+	blanked, invalid := blankInvalidLines(buffer)
+	parser = &Fbp{Buffer: blanked}
+	parser.Init()
+	var tokens []Token
+	if err := parser.Parse(); err == nil {
+		tokens = tokenizeTree(parser.syntaxTree(), parser.Buffer)
+	}
+	for _, line := range invalid {
+		tokens = append(tokens, Token{Kind: TokenInvalid, Offset: line[0], Length: line[1] - line[0]})
+	}
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Offset < tokens[j].Offset
+	})
+	return tokens
+}
+
+func tokenizeTree(root *syntaxNode, buffer string) []Token {
+	var tokens []Token
+	add := func(kind TokenKind, begin, end int) {
+		if end > begin {
+			tokens = append(tokens, Token{Kind: kind, Offset: begin, Length: end - begin})
+		}
+	}
+	for _, line := range root.children {
+		if line.rule != ruleline {
+			continue
+		}
+		text := line.text(buffer)
+		trimmed := strings.TrimLeft(text, " \t")
+		begin := line.begin + len(text) - len(trimmed)
+		for _, directive := range []string{"INPORT=", "OUTPORT=", "EXPORT="} {
+			if strings.HasPrefix(strings.ToUpper(trimmed), directive) {
+				add(TokenDirective, begin, begin+len(directive)-1)
+				tokenizeExport(trimmed[len(directive):], begin+len(directive), add)
+			}
+		}
+		line.walk(func(n *syntaxNode) {
+			switch n.rule {
+			case rulecomment:
+				add(TokenComment, n.begin+strings.IndexByte(n.text(buffer), '#'), n.end)
+			case ruleiip:
+				add(TokenIIP, n.begin, n.end)
+			case rulenode:
+				if name := n.child(rulePegText); name != nil {
+					add(TokenProcess, name.begin, name.end)
+				}
+			case rulecomponent:
+				if name := n.child(rulePegText); name != nil {
+					add(TokenComponent, name.begin, name.end)
+				}
+			case rulecompMeta:
+				if meta := n.child(rulePegText); meta != nil {
+					add(TokenMetadata, meta.begin, meta.end)
+				}
+			case ruleport, ruleportWithIndex:
+				for i, c := range n.children {
+					if c.rule != rulePegText {
+						continue
+					}
+					if i == 0 {
+						add(TokenPort, c.begin, c.end)
+					} else {
+						add(TokenIndex, c.begin, c.end)
+					}
+				}
+			case ruleconnection:
+				if n == line.child(ruleconnection) {
+					tokens = append(tokens, arrows(n, buffer)...)
+				}
+			}
+		})
+	}
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Offset < tokens[j].Offset
+	})
+	return tokens
+}
+
+// tokenizeExport adds the tokens of Process.PORT:NAME at offset
+func tokenizeExport(text string, offset int, add func(TokenKind, int, int)) {
+	parts, ok := splitExport(text)
+	if !ok {
+		return
+	}
+	add(TokenProcess, offset, offset+parts.dot)
+	add(TokenPort, offset+parts.dot+1, offset+parts.portEnd)
+	if parts.indexBegin >= 0 {
+		add(TokenIndex, offset+parts.indexBegin, offset+parts.indexEnd)
+	}
+	add(TokenPort, offset+parts.colon+1, offset+parts.end)
+}
+
+// arrows returns the "->" tokens of a connection, they are the literals
+// between its bridges
+func arrows(connection *syntaxNode, buffer string) []Token {
+	var tokens []Token
+	for n := connection; n != nil; n = n.child(ruleconnection) {
+		bridge := n.child(rulebridge)
+		next := n.child(ruleconnection)
+		if bridge == nil || next == nil {
+			break
+		}
+		if i := strings.Index(buffer[bridge.end:next.begin], "->"); i >= 0 {
+			tokens = append(tokens, Token{Kind: TokenArrow, Offset: bridge.end + i, Length: 2})
+		}
+	}
+	return tokens
+}
+
+// blankInvalidLines replaces the lines of buffer (or lines of an IIP
+// spanning them) that don't parse on their own with spaces, so that the
+// offsets of the rest stay, and returns the ranges of their text by line
+func blankInvalidLines(buffer string) (string, [][2]int) {
+	blanked := []byte(buffer)
+	var invalid [][2]int
+	begin := 0
+	for _, statement := range splitStatements(buffer) {
+		parser := &Fbp{Buffer: statement}
+		parser.Init()
+		if err := parser.Parse(); err != nil {
+			offset := begin
+			for _, line := range splitLines(statement) {
+				text := strings.TrimRight(line, "\n\r")
+				for i := 0; i < len(text); i++ {
+					blanked[offset+i] = ' '
+				}
+				if trimmed := strings.TrimSpace(text); trimmed != "" {
+					b := offset + strings.Index(text, trimmed)
+					invalid = append(invalid, [2]int{b, b + len(trimmed)})
+				}
+				offset += len(line)
+			}
+		}
+		begin += len(statement)
+	}
+	return string(blanked), invalid
+}
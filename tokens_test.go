@@ -0,0 +1,64 @@
+package fbp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	src := []byte(`# graph
+INPORT=Read.IN[0]:FILE # exported
+'a -> b' -> IN Read(fs/read:a=1) OUT -> IN[1] Display(core/out), # chain
+Read ERROR ->
+EXPORT=Display.OUT:OUT
+`)
+	expected := []string{
+		"comment:# graph",
+		"directive:INPORT", "process:Read", "port:IN", "index:0", "port:FILE", "comment:# exported",
+		"iip:'a -> b'", "arrow:->", "port:IN", "process:Read", "component:fs/read", "metadata:a=1",
+		"port:OUT", "arrow:->", "port:IN", "index:1", "process:Display", "component:core/out", "comment:# chain",
+		"invalid:Read ERROR ->",
+		"directive:EXPORT", "process:Display", "port:OUT", "port:OUT",
+	}
+	var tokens []string
+	it := NewTokenIterator(src)
+	for it.Next() {
+		token := it.Token()
+		tokens = append(tokens, token.Kind.String()+":"+token.Text(src))
+	}
+	if strings.Join(tokens, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected tokens %q", tokens)
+	}
+
+	var kind TokenKind
+	if err := kind.UnmarshalText([]byte("arrow")); err != nil || kind != TokenArrow {
+		t.Fatalf("Unexpected kind %v %v", kind, err)
+	}
+	if tokens := Tokenize(nil); len(tokens) != 0 {
+		t.Fatalf("Unexpected tokens %v", tokens)
+	}
+}
+
+func TestTokenizeMultilineIIP(t *testing.T) {
+	src := []byte("'a\nb' -> IN X(c)\nbroken ->\n'open\n-> IN X\n")
+	expected := []string{
+		"iip:'a\nb'", "arrow:->", "port:IN", "process:X", "component:c",
+		"invalid:broken ->",
+		"invalid:'open", "invalid:-> IN X",
+	}
+	var tokens []string
+	for _, token := range Tokenize(src) {
+		tokens = append(tokens, token.Kind.String()+":"+token.Text(src))
+	}
+	if strings.Join(tokens, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected tokens %q", tokens)
+	}
+}
+
+func TestTokenizeLowerCaseDirective(t *testing.T) {
+	src := []byte("inport=Read.IN:FILE\nRead(fs/read) OUT -> IN Display(core/out)\n")
+	tokens := Tokenize(src)
+	if len(tokens) < 4 || tokens[0].Kind != TokenDirective || tokens[0].Text(src) != "inport" || tokens[1].Kind != TokenProcess || tokens[3].Text(src) != "FILE" {
+		t.Fatalf("Unexpected tokens %v", tokens)
+	}
+}
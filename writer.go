@@ -0,0 +1,174 @@
+package fbp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//
+// Lexical rules of the .fbp DSL (see grammar.peg) used to check that
+// a graph can be written out without producing an invalid file
+//
+var (
+	processNameRe   = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+	componentNameRe = regexp.MustCompile(`^[a-zA-Z/\-0-9_]+$`)
+	metaKeyRe       = regexp.MustCompile(`^[a-zA-Z/_0-9]+$`)
+	metaValueRe     = regexp.MustCompile(`^[a-zA-Z/=_0-9]*$`)
+	portNameRe      = regexp.MustCompile(`^[A-Z.0-9_]+$`)
+	exportedPortRe  = regexp.MustCompile(`^[A-Z0-9_]+$`)
+)
+
+// MarshalFbp returns the graph as .fbp DSL text
+func (self *BaseFbp) MarshalFbp() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := self.WriteFbp(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFbp writes the graph to w as .fbp DSL text. Exported ports go first,
+// followed by one connection per line in the order of self.Connections.
+// A process component (and metadata) is declared at the first occurrence
+// of the process only, so that the output parses back to the same graph.
+// The .fbp DSL has no syntax for the metadata of connections, the graph
+// properties and the groups (of NoFlo JSON graphs), they are left out.
+func (self *BaseFbp) WriteFbp(w io.Writer) error {
+	processes := make(map[string]*Process, len(self.Processes))
+	for _, p := range self.Processes {
+		if err := checkProcess(p); err != nil {
+			return err
+		}
+		processes[p.Name] = p
+	}
+
+	var buf bytes.Buffer
+	if err := writeExports(&buf, "INPORT", self.Inports); err != nil {
+		return err
+	}
+	if err := writeExports(&buf, "OUTPORT", self.Outports); err != nil {
+		return err
+	}
+
+	declared := make(map[string]bool, len(processes))
+	node := func(name string) string {
+		p, ok := processes[name]
+		if !ok || declared[name] {
+			return name
+		}
+		declared[name] = true
+		return p.Name + "(" + p.Component + formatMetadata(p.Metadata) + ")"
+	}
+
+	for i, c := range self.Connections {
+		if c.Target == nil {
+			return fmt.Errorf("fbp: connection #%d has no target", i)
+		}
+		if err := checkEndpoint(c.Target); err != nil {
+			return err
+		}
+		if c.Source != nil {
+			if err := checkEndpoint(c.Source); err != nil {
+				return err
+			}
+			src := node(c.Source.Process)
+			fmt.Fprintf(&buf, "%s %s -> ", src, formatPort(c.Source))
+		} else {
+			iip, err := quoteIIP(c.Data)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&buf, "%s -> ", iip)
+		}
+		fmt.Fprintf(&buf, "%s %s\n", formatPort(c.Target), node(c.Target.Process))
+	}
+
+	for _, p := range self.Processes {
+		if !declared[p.Name] {
+			return fmt.Errorf("fbp: process %q is not connected and cannot be written as .fbp", p.Name)
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeExports(buf *bytes.Buffer, directive string, ports map[string]*Endpoint) error {
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e := ports[name]
+		if !exportedPortRe.MatchString(name) {
+			return fmt.Errorf("fbp: invalid exported port name %q", name)
+		}
+		if !processNameRe.MatchString(e.Process) || !exportedPortRe.MatchString(e.Port) {
+			return fmt.Errorf("fbp: invalid endpoint %s of exported port %q", e, name)
+		}
+		fmt.Fprintf(buf, "%s=%s.%s:%s\n", directive, e.Process, formatPort(e), name)
+	}
+	return nil
+}
+
+func checkProcess(p *Process) error {
+	if !processNameRe.MatchString(p.Name) {
+		return fmt.Errorf("fbp: invalid process name %q", p.Name)
+	}
+	if !componentNameRe.MatchString(p.Component) {
+		return fmt.Errorf("fbp: invalid component name %q of process %q", p.Component, p.Name)
+	}
+	for k, v := range p.Metadata {
+		if !metaKeyRe.MatchString(k) || !metaValueRe.MatchString(v) {
+			return fmt.Errorf("fbp: metadata %s=%s of process %q cannot be written as .fbp", k, v, p.Name)
+		}
+	}
+	return nil
+}
+
+func checkEndpoint(e *Endpoint) error {
+	if !processNameRe.MatchString(e.Process) || !portNameRe.MatchString(e.Port) {
+		return fmt.Errorf("fbp: invalid endpoint %s", e)
+	}
+	if e.Index != nil && *e.Index < 0 {
+		return fmt.Errorf("fbp: negative port index in endpoint %s", e)
+	}
+	return nil
+}
+
+func formatPort(e *Endpoint) string {
+	if e.Index != nil {
+		return fmt.Sprintf("%s[%d]", e.Port, *e.Index)
+	}
+	return e.Port
+}
+
+func formatMetadata(m map[string]string) string {
+	if len(m) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + m[k]
+	}
+	return ":" + strings.Join(pairs, ",")
+}
+
+// quoteIIP is the reverse of unescapeIIP. The grammar has no escape for
+// a backslash, so data ending with one cannot be written.
+func quoteIIP(data string) (string, error) {
+	if strings.HasSuffix(data, `\`) {
+		return "", fmt.Errorf("fbp: IIP %q ends with a backslash and cannot be written as .fbp", data)
+	}
+	return "'" + strings.Replace(data, `'`, `\'`, -1) + "'", nil
+}
@@ -0,0 +1,211 @@
+package fbp
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func parseGraph(t *testing.T, graph string) *Fbp {
+	parser := &Fbp{Buffer: graph}
+	parser.Init()
+	if err := parser.Parse(); err != nil {
+		t.Fatalf("%s\n%s", graph, err.Error())
+	}
+	parser.Execute()
+	return parser
+}
+
+func equivalentGraphs(a, b *BaseFbp) bool {
+	procs := func(g *BaseFbp) map[string]*Process {
+		m := make(map[string]*Process)
+		for _, p := range g.Processes {
+			m[p.Name] = p
+		}
+		return m
+	}
+	pa, pb := procs(a), procs(b)
+	if len(pa) != len(pb) {
+		return false
+	}
+	for name, p := range pa {
+		q, ok := pb[name]
+		if !ok || p.Component != q.Component || len(p.Metadata) != len(q.Metadata) {
+			return false
+		}
+		for k, v := range p.Metadata {
+			if w, ok := q.Metadata[k]; !ok || v != w {
+				return false
+			}
+		}
+	}
+	if len(a.Connections) != len(b.Connections) {
+		return false
+	}
+	for i := range a.Connections {
+		if !reflect.DeepEqual(a.Connections[i], b.Connections[i]) {
+			return false
+		}
+	}
+	return reflect.DeepEqual(a.Inports, b.Inports) && reflect.DeepEqual(a.Outports, b.Outports)
+}
+
+//
+// Random graph for property tests
+//
+type randomGraph struct {
+	*BaseFbp
+}
+
+func (randomGraph) Generate(r *rand.Rand, size int) reflect.Value {
+	const (
+		letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_"
+		upper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_"
+		iip     = "abc XYZ 019 '\\\",.:;()[]#->\t\n"
+	)
+	word := func(first, rest string, n int) string {
+		s := []byte{first[r.Intn(len(first))]}
+		for i := r.Intn(n); i > 0; i-- {
+			s = append(s, rest[r.Intn(len(rest))])
+		}
+		return string(s)
+	}
+	endpoint := func(process string) *Endpoint {
+		e := &Endpoint{Process: process, Port: word("ABCDEFGHIJKLMNOPQRSTUVWXYZ", upper, 6)}
+		if r.Intn(3) == 0 {
+			e.Index = new(int)
+			*e.Index = r.Intn(10)
+		}
+		return e
+	}
+
+	g := &BaseFbp{}
+	names := []string{}
+	seen := map[string]bool{}
+	for i := r.Intn(size+1) + 1; i > 0; i-- {
+		name := word("abcdefghijklmnopqrstuvwxyz", letters, 8)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if r.Intn(4) == 0 {
+			// process used without a component declaration
+			continue
+		}
+		p := &Process{Name: name, Component: word("abcdefghijklmnopqrstuvwxyz", letters+"/-", 12)}
+		if r.Intn(2) == 0 {
+			p.Metadata = map[string]string{}
+			for j := r.Intn(3) + 1; j > 0; j-- {
+				p.Metadata[word("abcdefghijklmnopqrstuvwxyz", letters, 5)] = word("abcdefghijklmnopqrstuvwxyz", letters+"=/", 5)
+			}
+		}
+		g.Processes = append(g.Processes, p)
+	}
+	// every process takes part in at least one connection
+	for i, name := range names {
+		c := &Connection{Target: endpoint(name)}
+		if i > 0 && r.Intn(3) != 0 {
+			c.Source = endpoint(names[r.Intn(len(names))])
+		} else {
+			c.Data = word(iip, iip, 16)
+			c.Data = strings.TrimRight(c.Data, `\`)
+		}
+		g.Connections = append(g.Connections, c)
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		if g.Inports == nil {
+			g.Inports = map[string]*Endpoint{}
+		}
+		e := endpoint(names[r.Intn(len(names))])
+		e.Port = strings.Replace(e.Port, ".", "_", -1)
+		g.Inports[word(upper, upper, 6)] = e
+	}
+	for i := r.Intn(3); i > 0; i-- {
+		if g.Outports == nil {
+			g.Outports = map[string]*Endpoint{}
+		}
+		e := endpoint(names[r.Intn(len(names))])
+		e.Port = strings.Replace(e.Port, ".", "_", -1)
+		g.Outports[word(upper, upper, 6)] = e
+	}
+	return reflect.ValueOf(randomGraph{g})
+}
+
+func TestWriteFbpRoundTrip(t *testing.T) {
+	for _, graph := range []string{graphTickLogger, graphOneLiner, graphDemo, graphExportedInPort, graphArrayPorts, graphExportedArrayPort} {
+		parser := parseGraph(t, graph)
+		text, err := parser.MarshalFbp()
+		if err != nil {
+			t.Fatal(err)
+		}
+		again := parseGraph(t, string(text))
+		if !equivalentGraphs(&parser.BaseFbp, &again.BaseFbp) {
+			t.Fatalf("Graph changed after round trip:\n%s\n%s", graph, text)
+		}
+	}
+}
+
+func TestWriteFbpRoundTripProperty(t *testing.T) {
+	roundTrip := func(g randomGraph) bool {
+		text, err := g.MarshalFbp()
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		parser := &Fbp{Buffer: string(text)}
+		parser.Init()
+		if err := parser.Parse(); err != nil {
+			t.Log(string(text), err)
+			return false
+		}
+		parser.Execute()
+		if !equivalentGraphs(g.BaseFbp, &parser.BaseFbp) {
+			t.Log(string(text))
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 500}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteFbpDeclaresComponentOnce(t *testing.T) {
+	parser := parseGraph(t, graphTickLogger)
+	text, err := parser.MarshalFbp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(text), "(core/passthru)"); n != 1 {
+		t.Fatalf("Component declared %d times:\n%s", n, text)
+	}
+}
+
+func TestWriteFbpIIPEscaping(t *testing.T) {
+	parser := parseGraph(t, `'it\'s' -> IN A(core/console)`)
+	if parser.Connections[0].Data != "it's" {
+		t.Fatalf("Unexpected IIP %q", parser.Connections[0].Data)
+	}
+	text, err := parser.MarshalFbp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(text), `'it\'s' -> IN A(core/console)`) {
+		t.Fatalf("Unexpected output %q", text)
+	}
+
+	parser.Connections[0].Data = `trailing\`
+	if _, err := parser.MarshalFbp(); err == nil {
+		t.Fatal("IIP ending with a backslash should not be written")
+	}
+}
+
+func TestWriteFbpUnconnectedProcess(t *testing.T) {
+	g := &BaseFbp{Processes: []*Process{{Name: "A", Component: "core/console"}}}
+	if _, err := g.MarshalFbp(); err == nil {
+		t.Fatal("Unconnected process should not be written")
+	}
+}